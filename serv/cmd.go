@@ -3,29 +3,51 @@ package serv
 import (
 	"encoding/json"
 
-	simplejson "github.com/bitly/go-simplejson"
 	"github.com/chenyoufu/esql/sp"
 )
 
-//CmdTranslator return string
-func CmdTranslator(sql string, pretty bool) string {
+// translateSQL returns the translation of sql to render: the dsl with the
+// index, count, warnings and composite sources of the statement, or the error.
+func translateSQL(sql string) map[string]interface{} {
 	m := make(map[string]interface{}, 1)
-	var bs []byte
-	var err error
-
 	m["sql"] = sql
-	dsl, err := sp.EsDsl(sql)
+
+	stmt, err := sp.ParseSelectStatement(sql)
 	if err != nil {
 		m["err"] = err.Error()
-	} else {
-		js, _ := simplejson.NewJson([]byte(dsl))
-		m["dsl"] = js.MustMap()
+		return m
+	}
+
+	// read the statement before the translation rewrites it
+	index, count := stmt.Index(), stmt.IsCountQuery()
+	warnings, sources := stmt.Diagnostics(), stmt.CompositeSources()
+
+	dsl, err := stmt.DslMap(nil)
+	if err != nil {
+		m["err"] = err.Error()
+		return m
+	}
+	m["dsl"] = dsl
+	m["index"] = index
+	m["count"] = count
+	if len(warnings) > 0 {
+		m["warnings"] = warnings
 	}
+	if len(sources) > 0 {
+		m["composite_sources"] = sources
+	}
+	return m
+}
+
+//CmdTranslator return string
+func CmdTranslator(sql string, pretty bool) string {
+	var bs []byte
 
+	m := translateSQL(sql)
 	if pretty {
-		bs, err = json.MarshalIndent(m, "", "  ")
+		bs, _ = json.MarshalIndent(m, "", "  ")
 	} else {
-		bs, err = json.Marshal(m)
+		bs, _ = json.Marshal(m)
 	}
 	return string(bs)
 }
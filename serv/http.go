@@ -8,9 +8,7 @@ import (
 
 	"io/ioutil"
 
-	simplejson "github.com/bitly/go-simplejson"
 	"github.com/chenyoufu/esql/g"
-	"github.com/chenyoufu/esql/sp"
 	"github.com/toolkits/file"
 )
 
@@ -31,8 +29,6 @@ func renderJSON(w http.ResponseWriter, v interface{}, pretty bool) {
 }
 
 func translate(w http.ResponseWriter, r *http.Request) {
-	m := make(map[string]interface{}, 1)
-
 	var pretty string
 	pretty = r.URL.Query().Get("sql")
 
@@ -54,16 +50,7 @@ func translate(w http.ResponseWriter, r *http.Request) {
 		sql = string(body)
 	}
 
-	m["sql"] = sql
-
-	dsl, err := sp.EsDsl(sql)
-	if err != nil {
-		m["err"] = err.Error()
-	} else {
-		js, _ := simplejson.NewJson([]byte(dsl))
-		m["dsl"] = js.MustMap()
-	}
-
+	m := translateSQL(sql)
 	if pretty == "1" {
 		renderJSON(w, m, true)
 	} else {
@@ -33,6 +33,9 @@ var (
 	// ErrInvalidTime is returned when the timestamp string used to
 	// compare against time field is invalid.
 	ErrInvalidTime = errors.New("invalid timestamp string")
+
+	// ErrInvalidDuration is returned when parsing a malformed duration.
+	ErrInvalidDuration = errors.New("invalid duration")
 )
 
 // InspectDataType returns the data type of a given value.
@@ -86,27 +89,36 @@ func (Statements) node() {}
 
 func (*SelectStatement) node() {}
 
-func (*BinaryExpr) node()     {}
-func (*BooleanLiteral) node() {}
-func (*Call) node()           {}
-func (*Dimension) node()      {}
-func (Dimensions) node()      {}
-func (*IntegerLiteral) node() {}
-func (*Field) node()          {}
-func (Fields) node()          {}
-func (*Measurement) node()    {}
-func (Measurements) node()    {}
-func (*nilLiteral) node()     {}
-func (*NumberLiteral) node()  {}
-func (*ParenExpr) node()      {}
-func (*RegexLiteral) node()   {}
-func (*ListLiteral) node()    {}
-func (*SortField) node()      {}
-func (SortFields) node()      {}
-func (Sources) node()         {}
-func (*StringLiteral) node()  {}
-func (*VarRef) node()         {}
-func (*Wildcard) node()       {}
+func (*BetweenExpr) node()     {}
+func (*BinaryExpr) node()      {}
+func (*BooleanLiteral) node()  {}
+func (*Call) node()            {}
+func (*CaseExpr) node()        {}
+func (*Collapse) node()        {}
+func (*Highlight) node()       {}
+func (*Dimension) node()       {}
+func (Dimensions) node()       {}
+func (*DurationLiteral) node() {}
+func (*IntegerLiteral) node()  {}
+func (*IsNullExpr) node()      {}
+func (*Field) node()           {}
+func (Fields) node()           {}
+func (*Measurement) node()     {}
+func (Measurements) node()     {}
+func (*NotExpr) node()         {}
+func (*nilLiteral) node()      {}
+func (*NullLiteral) node()     {}
+func (*NumberLiteral) node()   {}
+func (*OrderByExpr) node()     {}
+func (*ParenExpr) node()       {}
+func (*RegexLiteral) node()    {}
+func (*ListLiteral) node()     {}
+func (*SortField) node()       {}
+func (SortFields) node()       {}
+func (Sources) node()          {}
+func (*StringLiteral) node()   {}
+func (*VarRef) node()          {}
+func (*Wildcard) node()        {}
 
 // Statements represents a list of statements.
 type Statements []Statement
@@ -139,20 +151,48 @@ func (*SelectStatement) stmt() {}
 type Expr interface {
 	Node
 	expr()
+	Pos() Pos
+}
+
+// position records where a node starts in the query text.
+// It is embedded in the expression and field nodes set by the parser.
+type position struct {
+	pos Pos
 }
 
-func (*BinaryExpr) expr()     {}
-func (*BooleanLiteral) expr() {}
-func (*Call) expr()           {}
-func (*IntegerLiteral) expr() {}
-func (*nilLiteral) expr()     {}
-func (*NumberLiteral) expr()  {}
-func (*ParenExpr) expr()      {}
-func (*RegexLiteral) expr()   {}
-func (*ListLiteral) expr()    {}
-func (*StringLiteral) expr()  {}
-func (*VarRef) expr()         {}
-func (*Wildcard) expr()       {}
+// Pos returns the position of the node in the query text.
+func (p *position) Pos() Pos { return p.pos }
+
+func (p *position) setPos(pos Pos) { p.pos = pos }
+
+// setPos records pos as the start of node, nodes without a position are ignored.
+func setPos(node Node, pos Pos) {
+	if n, ok := node.(interface {
+		setPos(Pos)
+	}); ok {
+		n.setPos(pos)
+	}
+}
+
+func (*BetweenExpr) expr()     {}
+func (*BinaryExpr) expr()      {}
+func (*BooleanLiteral) expr()  {}
+func (*Call) expr()            {}
+func (*CaseExpr) expr()        {}
+func (*DurationLiteral) expr() {}
+func (*IntegerLiteral) expr()  {}
+func (*IsNullExpr) expr()      {}
+func (*nilLiteral) expr()      {}
+func (*NullLiteral) expr()     {}
+func (*NotExpr) expr()         {}
+func (*NumberLiteral) expr()   {}
+func (*OrderByExpr) expr()     {}
+func (*ParenExpr) expr()       {}
+func (*RegexLiteral) expr()    {}
+func (*ListLiteral) expr()     {}
+func (*StringLiteral) expr()   {}
+func (*VarRef) expr()          {}
+func (*Wildcard) expr()        {}
 
 // Literal represents a static literal.
 type Literal interface {
@@ -160,13 +200,15 @@ type Literal interface {
 	literal()
 }
 
-func (*BooleanLiteral) literal() {}
-func (*IntegerLiteral) literal() {}
-func (*nilLiteral) literal()     {}
-func (*NumberLiteral) literal()  {}
-func (*RegexLiteral) literal()   {}
-func (*ListLiteral) literal()    {}
-func (*StringLiteral) literal()  {}
+func (*BooleanLiteral) literal()  {}
+func (*DurationLiteral) literal() {}
+func (*IntegerLiteral) literal()  {}
+func (*nilLiteral) literal()      {}
+func (*NullLiteral) literal()     {}
+func (*NumberLiteral) literal()   {}
+func (*RegexLiteral) literal()    {}
+func (*ListLiteral) literal()     {}
+func (*StringLiteral) literal()   {}
 
 // Source represents a source of data for a statement.
 type Source interface {
@@ -213,6 +255,64 @@ type SortField struct {
 
 	// Sort order.
 	Ascending bool
+
+	// Placement of missing values, FIRST or LAST. Unset if ILLEGAL.
+	Nulls Token
+
+	// The call sorted by, e.g. an aggregate, nil for a field.
+	Call *Call
+
+	// The RANDOM([seed]) call of a random order, nil otherwise.
+	Random *Call
+
+	position
+}
+
+// Collapse represents a COLLAPSE clause, keeping the top hit of each value of
+// a field.
+type Collapse struct {
+	// Name of the field
+	Field string
+
+	// Number of collapsed hits returned with each hit, none if zero.
+	Size int
+
+	position
+}
+
+// String returns a string representation of the collapse clause.
+func (c *Collapse) String() string {
+	if c.Size > 0 {
+		return fmt.Sprintf("COLLAPSE(%s, %d)", c.Field, c.Size)
+	}
+	return fmt.Sprintf("COLLAPSE(%s)", c.Field)
+}
+
+// Highlight represents a HIGHLIGHT clause, returning the snippets of the
+// fields matching the full-text queries.
+type Highlight struct {
+	// Names of the highlighted fields.
+	Fields []string
+
+	// Length of a snippet in characters, es default if zero.
+	FragmentSize int
+
+	// Maximum number of snippets of a field, es default if zero.
+	Fragments int
+
+	position
+}
+
+// String returns a string representation of the highlight clause.
+func (h *Highlight) String() string {
+	args := append([]string{}, h.Fields...)
+	if h.FragmentSize > 0 {
+		args = append(args, strconv.Itoa(h.FragmentSize))
+	}
+	if h.Fragments > 0 {
+		args = append(args, strconv.Itoa(h.Fragments))
+	}
+	return fmt.Sprintf("HIGHLIGHT(%s)", strings.Join(args, ", "))
 }
 
 // String returns a string representation of a sort field
@@ -227,6 +327,10 @@ func (field *SortField) String() string {
 	} else {
 		_, _ = buf.WriteString("DESC")
 	}
+	if field.Nulls == FIRST || field.Nulls == LAST {
+		_, _ = buf.WriteString(" NULLS ")
+		_, _ = buf.WriteString(field.Nulls.String())
+	}
 	return buf.String()
 }
 
@@ -253,18 +357,37 @@ type SelectStatement struct {
 	// An expression evaluated on data point.
 	Condition Expr
 
+	// An expression filtering the hits after the aggregations are computed.
+	PostFilter Expr
+
+	// Field the hits are deduplicated by.
+	Collapse *Collapse
+
+	// Fields the matches are highlighted in.
+	Highlight *Highlight
+
 	// Fields to sort results by
 	SortFields SortFields
 
 	// Maximum number of rows to be returned. Unlimited if zero.
 	Limit int
 
+	// HasLimit is true if the statement has a LIMIT clause, it tells an
+	// explicit LIMIT 0 from no LIMIT at all.
+	HasLimit bool
+
 	// Returns rows starting at an offset from the first row.
 	Offset int
 
 	// Expressions used for grouping the selection.
 	Dimensions Dimensions
 
+	// Groups by a composite aggregation, paginated by After.
+	Composite bool
+
+	// Dimension values of the last bucket of the previous composite page.
+	After []Expr
+
 	// Expressions used for filter grouping buckets.
 	Having Expr
 
@@ -273,6 +396,25 @@ type SelectStatement struct {
 
 	// Removes duplicate rows from raw queries.
 	Dedupe bool
+
+	// Hints read from "/*+ ... */" comments.
+	Hints []string
+}
+
+// hints are the supported query hints.
+var hints = map[string]bool{
+	// count all the hits, which may be slow on large indices
+	"track_total_hits": true,
+}
+
+// HasHint returns true if the statement has the hint name.
+func (s *SelectStatement) HasHint(name string) bool {
+	for _, h := range s.Hints {
+		if h == name {
+			return true
+		}
+	}
+	return false
 }
 
 // HasDerivative returns true if one of the function calls in the statement is a
@@ -345,6 +487,67 @@ func matchExactRegex(v string) (string, bool) {
 	return "", true
 }
 
+// Index returns the comma separated names of the sources, which is the
+// target of the search request, e.g. "logs-2023,logs-2024".
+func (s *SelectStatement) Index() string {
+	return strings.Join(s.Sources.Names(), ",")
+}
+
+// Diagnostics returns warnings about conditions translated more leniently
+// than SQL would evaluate them, e.g. "field = NULL" which never matches is
+// translated as "field IS NULL".
+func (s *SelectStatement) Diagnostics() []string {
+	var warnings []string
+	warn := func(n Node) {
+		expr, ok := n.(*BinaryExpr)
+		if !ok {
+			return
+		}
+		if field := nullComparison(expr); field != nil {
+			name := cleanDocString(field.String())
+			is := &IsNullExpr{Expr: &VarRef{Val: name}, Not: expr.Op == NEQ}
+			warnings = append(warnings, fmt.Sprintf("comparison of %s with NULL translated as %s", name, is.String()))
+		}
+	}
+	WalkFunc(s.Condition, warn)
+	WalkFunc(s.PostFilter, warn)
+	for _, f := range s.Fields {
+		WalkFunc(f.Filter, warn)
+	}
+	return warnings
+}
+
+// nullComparison returns the field of a "field = NULL" or "field <> NULL"
+// comparison, nil if expr is not one.
+func nullComparison(expr *BinaryExpr) Expr {
+	if expr.Op != EQ && expr.Op != NEQ {
+		return nil
+	}
+	if _, ok := expr.RHS.(*NullLiteral); ok {
+		if _, ok := expr.LHS.(*VarRef); ok {
+			return expr.LHS
+		}
+	}
+	if _, ok := expr.LHS.(*NullLiteral); ok {
+		if _, ok := expr.RHS.(*VarRef); ok {
+			return expr.RHS
+		}
+	}
+	return nil
+}
+
+// IsCountQuery returns true if the statement only counts the matching
+// documents, e.g. "SELECT count(*) FROM logs WHERE ...", which is sent to
+// the _count endpoint instead of _search.
+func (s *SelectStatement) IsCountQuery() bool {
+	if len(s.Fields) != 1 || len(s.Dimensions) > 0 || s.Dedupe {
+		return false
+	}
+	f := s.Fields[0]
+	call, ok := f.Expr.(*Call)
+	return ok && f.Filter == nil && call.Name == "count" && call.metricAggType() == StarCount
+}
+
 // ColumnNames will walk all fields and functions and return the appropriate field names for the select statement
 // while maintaining order of the field names
 func (s *SelectStatement) ColumnNames() []string {
@@ -396,6 +599,12 @@ func (s *SelectStatement) ColumnNames() []string {
 func (s *SelectStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("SELECT ")
+	if len(s.Hints) > 0 {
+		_, _ = fmt.Fprintf(&buf, "/*+ %s */ ", strings.Join(s.Hints, " "))
+	}
+	if s.Dedupe {
+		_, _ = buf.WriteString("DISTINCT ")
+	}
 	_, _ = buf.WriteString(s.Fields.String())
 
 	if len(s.Sources) > 0 {
@@ -406,10 +615,31 @@ func (s *SelectStatement) String() string {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
 	}
+	if s.PostFilter != nil {
+		_, _ = fmt.Fprintf(&buf, " POST_FILTER(WHERE %s)", s.PostFilter.String())
+	}
+	if s.Collapse != nil {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(s.Collapse.String())
+	}
+	if s.Highlight != nil {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(s.Highlight.String())
+	}
 	if len(s.Dimensions) > 0 {
 		_, _ = buf.WriteString(" GROUP BY ")
+		if s.Composite {
+			_, _ = buf.WriteString("COMPOSITE ")
+		}
 		_, _ = buf.WriteString(s.Dimensions.String())
 	}
+	if len(s.After) > 0 {
+		keys := make([]string, len(s.After))
+		for i, key := range s.After {
+			keys[i] = key.String()
+		}
+		_, _ = fmt.Fprintf(&buf, " AFTER(%s)", strings.Join(keys, ", "))
+	}
 	if s.Having != nil {
 		_, _ = buf.WriteString(" HAVING ")
 		_, _ = buf.WriteString(s.Having.String())
@@ -418,21 +648,39 @@ func (s *SelectStatement) String() string {
 		_, _ = buf.WriteString(" ORDER BY ")
 		_, _ = buf.WriteString(s.SortFields.String())
 	}
-	if s.Limit > 0 {
+	if s.HasLimit || s.Limit > 0 || s.Offset > 0 {
 		_, _ = fmt.Fprintf(&buf, " LIMIT %d", s.Limit)
 	}
 	if s.Offset > 0 {
-		_, _ = buf.WriteString(", ")
+		_, _ = buf.WriteString(" OFFSET ")
 		_, _ = buf.WriteString(strconv.Itoa(s.Offset))
 	}
 	return buf.String()
 }
 
+// Validate checks that the statement is supported by the translator. The
+// parser validates every statement, so this is only needed for statements
+// built or changed in code.
+func Validate(stmt *SelectStatement) error {
+	return stmt.validate()
+}
+
 func (s *SelectStatement) validate() error {
+	// Hints aren't nodes, they are reported at the start of the query.
+	for _, h := range s.Hints {
+		if !hints[h] {
+			return &ParseError{Message: fmt.Sprintf("unknown hint %s", h), Pos: Pos{}}
+		}
+	}
+
 	if err := s.validateFields(); err != nil {
 		return err
 	}
 
+	if err := s.validateGrouping(); err != nil {
+		return err
+	}
+
 	if err := s.validateAggregates(); err != nil {
 		return err
 	}
@@ -441,96 +689,1009 @@ func (s *SelectStatement) validate() error {
 		return err
 	}
 
-	return nil
+	if err := s.validateDistinct(); err != nil {
+		return err
+	}
+
+	if err := s.validateCollapse(); err != nil {
+		return err
+	}
+
+	if err := s.validateHighlight(); err != nil {
+		return err
+	}
+
+	if err := s.validateComposite(); err != nil {
+		return err
+	}
+
+	if err := s.validateDimensions(); err != nil {
+		return err
+	}
+
+	if err := s.validatePipelines(); err != nil {
+		return err
+	}
+
+	if err := s.validateSortFields(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateSortFields checks that aggregations are sorted by a dimension or a metric.
+func (s *SelectStatement) validateSortFields() error {
+	if len(s.Dimensions) == 0 {
+		// The hits are only sorted by fields or randomly.
+		aliases := s.aliasTable()
+		for _, sf := range s.SortFields {
+			if sf.Call != nil && sf.Random == nil {
+				return &ParseError{Message: fmt.Sprintf("invalid ORDER BY, unsupport function %s", sf.Call.String()), Pos: sf.Call.Pos()}
+			}
+			if f, ok := aliases[sf.Name]; ok && s.IsRawQuery {
+				if _, ok := f.Expr.(*VarRef); !ok {
+					return &ParseError{Message: fmt.Sprintf("invalid ORDER BY %s, script fields can't sort the hits", sf.Name), Pos: sf.Pos()}
+				}
+			}
+		}
+		return nil
+	}
+	for _, sf := range s.SortFields {
+		if sf.Nulls != ILLEGAL {
+			return &ParseError{Message: fmt.Sprintf("invalid ORDER BY %s, NULLS %s is not supported with GROUP BY", sf.Name, sf.Nulls), Pos: sf.Pos()}
+		}
+		if sf.Name == "" || s.isGroupBySort(sf.Name) || s.sortMetric(sf.Name) != nil {
+			continue
+		}
+		return &ParseError{Message: fmt.Sprintf("invalid ORDER BY %s, expected a dimension or metric", sf.Name), Pos: sf.Pos()}
+	}
+	return nil
+}
+
+func (s *SelectStatement) validateDimensions() error {
+	for _, d := range s.Dimensions {
+		if c, ok := d.Expr.(*CaseExpr); ok {
+			if _, err := painlessScript(c); err != nil {
+				return err
+			}
+			continue
+		}
+		call, ok := d.Expr.(*Call)
+		if !ok {
+			continue
+		}
+		switch call.Name {
+		case "range":
+			if len(call.Args) < 2 {
+				return &ParseError{Message: fmt.Sprintf("invalid number of arguments for range, expected at least 2, got %d", len(call.Args)), Pos: call.Pos()}
+			}
+			switch call.Args[0].(type) {
+			case *VarRef, *BinaryExpr:
+			default:
+				return &ParseError{Message: "expected field argument in range()", Pos: call.Args[0].Pos()}
+			}
+			// Breakpoints must be ascending numbers, each one starts a bucket.
+			for i, arg := range call.Args[1:] {
+				if !isNumberLiteral(arg) {
+					return &ParseError{Message: fmt.Sprintf("invalid range breakpoint %s, expected a number", arg.String()), Pos: arg.Pos()}
+				}
+				if prev := call.Args[i]; i > 0 && castToFloat(literalValue(arg)) <= castToFloat(literalValue(prev)) {
+					return &ParseError{Message: fmt.Sprintf("invalid range breakpoints, %s must be greater than %s", arg.String(), prev.String()), Pos: arg.Pos()}
+				}
+			}
+		case "date_range":
+			if len(call.Args) < 3 {
+				return &ParseError{Message: fmt.Sprintf("invalid number of arguments for date_range, expected at least 3, got %d", len(call.Args)), Pos: call.Pos()}
+			}
+			// Bounds are es date math, passed through as strings.
+			for _, arg := range call.Args[1:] {
+				if _, ok := arg.(*StringLiteral); !ok {
+					return &ParseError{Message: fmt.Sprintf("invalid date_range bound %s, expected a string", arg.String()), Pos: arg.Pos()}
+				}
+			}
+		case "date_histogram":
+			if len(call.Args) != 2 {
+				return &ParseError{Message: fmt.Sprintf("invalid number of arguments for date_histogram, expected 2, got %d", len(call.Args)), Pos: call.Pos()}
+			}
+			interval, ok := call.Args[1].(*StringLiteral)
+			if !ok {
+				return &ParseError{Message: "expected interval string argument in date_histogram()", Pos: call.Args[1].Pos()}
+			}
+			if _, err := dateHistogramInterval(interval.Val); err != nil {
+				return &ParseError{Message: err.Error(), Pos: interval.Pos()}
+			}
+		case "terms":
+			if len(call.Args) < 2 || len(call.Args) > 4 {
+				return &ParseError{Message: fmt.Sprintf("invalid number of arguments for terms, expected 2 to 4, got %d", len(call.Args)), Pos: call.Pos()}
+			}
+			if _, ok := call.Args[0].(*VarRef); !ok {
+				return &ParseError{Message: "expected field argument in terms()", Pos: call.Args[0].Pos()}
+			}
+			if n, ok := call.Args[1].(*IntegerLiteral); !ok || n.Val <= 0 {
+				return &ParseError{Message: fmt.Sprintf("invalid terms size %s, expected a positive integer", call.Args[1].String()), Pos: call.Args[1].Pos()}
+			}
+			args := call.Args[2:]
+			if len(args) > 0 {
+				if missing, ok := args[len(args)-1].(*Call); ok && missing.Name == "missing" {
+					if len(missing.Args) != 1 {
+						return &ParseError{Message: fmt.Sprintf("invalid number of arguments for missing, expected 1, got %d", len(missing.Args)), Pos: missing.Pos()}
+					}
+					if _, ok := missing.Args[0].(*StringLiteral); !ok {
+						return &ParseError{Message: fmt.Sprintf("invalid missing label %s, expected a string", missing.Args[0].String()), Pos: missing.Args[0].Pos()}
+					}
+					args = args[:len(args)-1]
+				}
+			}
+			if len(args) > 1 {
+				return &ParseError{Message: fmt.Sprintf("invalid terms argument %s, expected missing()", args[1].String()), Pos: args[1].Pos()}
+			}
+			if len(args) == 1 {
+				order, ok := args[0].(*OrderByExpr)
+				if !ok {
+					return &ParseError{Message: fmt.Sprintf("invalid terms order %s, expected a sort", call.Args[2].String()), Pos: call.Args[2].Pos()}
+				}
+				// Buckets sort by key, doc count or a metric of the select.
+				for _, sf := range order.SortFields {
+					switch sf.Name {
+					case "_key", "_count", "count(*)":
+						continue
+					}
+					if s.sortMetric(sf.Name) == nil && !s.isGroupBySort(sf.Name) {
+						return &ParseError{Message: fmt.Sprintf("invalid terms order %s, expected a metric of the select", sf.Name), Pos: sf.Pos()}
+					}
+				}
+			}
+		case "geohash":
+			if len(call.Args) != 2 {
+				return &ParseError{Message: fmt.Sprintf("invalid number of arguments for geohash, expected 2, got %d", len(call.Args)), Pos: call.Pos()}
+			}
+			if _, ok := call.Args[0].(*VarRef); !ok {
+				return &ParseError{Message: "expected geo_point field argument in geohash()", Pos: call.Args[0].Pos()}
+			}
+			if n, ok := call.Args[1].(*IntegerLiteral); !ok || n.Val < 1 || n.Val > 12 {
+				return &ParseError{Message: fmt.Sprintf("invalid geohash precision %s, expected an integer from 1 to 12", call.Args[1].String()), Pos: call.Args[1].Pos()}
+			}
+		case "missing":
+			return &ParseError{Message: "invalid missing() outside terms()", Pos: call.Pos()}
+		case "histogram":
+			if len(call.Args) < 2 || len(call.Args) > 3 {
+				return &ParseError{Message: fmt.Sprintf("invalid number of arguments for histogram, expected 2 or 3, got %d", len(call.Args)), Pos: call.Pos()}
+			}
+			// Non-numeric intervals cast to zero and are rejected too.
+			if castToFloat(literalValue(call.Args[1])) <= 0 {
+				return &ParseError{Message: fmt.Sprintf("invalid histogram interval %s, expected a positive number", call.Args[1].String()), Pos: call.Args[1].Pos()}
+			}
+			if len(call.Args) == 3 {
+				if n, ok := call.Args[2].(*IntegerLiteral); !ok || n.Val < 0 {
+					return &ParseError{Message: fmt.Sprintf("invalid histogram min_doc_count %s, expected a non-negative integer", call.Args[2].String()), Pos: call.Args[2].Pos()}
+				}
+			}
+		default:
+			// Other functions are terms over a lucene expression script.
+			if !expressionFunctions[call.Name] {
+				return &ParseError{Message: fmt.Sprintf("invalid GROUP BY, unsupport function %s", call.String()), Pos: call.Pos()}
+			}
+			if len(call.Args) < 1 {
+				return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected at least 1, got %d", call.Name, len(call.Args)), Pos: call.Pos()}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SelectStatement) validateDistinct() error {
+	if !s.Dedupe {
+		return nil
+	}
+	if len(s.Dimensions) > 0 {
+		return &ParseError{Message: "invalid DISTINCT with GROUP BY", Pos: s.Dimensions[0].Pos()}
+	}
+	for _, f := range s.Fields {
+		if calls := walkFunctionCalls(f.Expr); len(calls) > 0 {
+			return &ParseError{Message: fmt.Sprintf("invalid DISTINCT with aggregate function %s", calls[0].String()), Pos: calls[0].Pos()}
+		}
+		if _, ok := f.Expr.(*VarRef); !ok {
+			return &ParseError{Message: fmt.Sprintf("invalid DISTINCT field %s, only support fields", f.Expr.String()), Pos: f.Expr.Pos()}
+		}
+	}
+	return nil
+}
+
+// validateCollapse checks that a collapsed query returns hits.
+func (s *SelectStatement) validateCollapse() error {
+	if s.Collapse == nil {
+		return nil
+	}
+	if len(s.Dimensions) > 0 || s.Dedupe || !s.IsRawQuery {
+		return &ParseError{Message: "invalid COLLAPSE with aggregations", Pos: s.Collapse.Pos()}
+	}
+	return nil
+}
+
+// validateComposite checks that the dimensions of GROUP BY COMPOSITE are
+// composite sources sorted by themselves, and that AFTER has a key for each.
+func (s *SelectStatement) validateComposite() error {
+	if !s.Composite {
+		if len(s.After) > 0 {
+			return &ParseError{Message: "invalid AFTER without GROUP BY COMPOSITE", Pos: s.After[0].Pos()}
+		}
+		return nil
+	}
+	for _, d := range s.Dimensions {
+		switch expr := d.Expr.(type) {
+		case *Call:
+			if expr.Name != "histogram" && expr.Name != "date_histogram" {
+				return &ParseError{Message: fmt.Sprintf("invalid GROUP BY COMPOSITE, %s is not a composite source", expr.String()), Pos: d.Pos()}
+			}
+		case *RegexLiteral:
+			return &ParseError{Message: fmt.Sprintf("invalid GROUP BY COMPOSITE, %s is not a composite source", expr.String()), Pos: d.Pos()}
+		}
+	}
+	for _, sf := range s.SortFields {
+		if sf.Name != "" && !s.isGroupBySort(sf.Name) {
+			return &ParseError{Message: fmt.Sprintf("invalid ORDER BY %s, GROUP BY COMPOSITE only sorts by dimensions", sf.Name), Pos: sf.Pos()}
+		}
+	}
+	if n := len(s.After); n > 0 && n != len(s.Dimensions) {
+		return &ParseError{Message: fmt.Sprintf("invalid AFTER, expected %d keys, got %d", len(s.Dimensions), n), Pos: s.After[0].Pos()}
+	}
+	return nil
+}
+
+// CompositeSources returns the names of the sources of GROUP BY COMPOSITE in
+// order, they are the keys of the buckets and of the after_key of a response.
+func (s *SelectStatement) CompositeSources() []string {
+	if !s.Composite {
+		return nil
+	}
+	names := make([]string, len(s.Dimensions))
+	for i, d := range s.Dimensions {
+		names[i] = d.aggName()
+	}
+	return names
+}
+
+// validateHighlight checks that a highlighted query returns hits matched by a
+// full-text query.
+func (s *SelectStatement) validateHighlight() error {
+	if s.Highlight == nil {
+		return nil
+	}
+	if len(s.Dimensions) > 0 || s.Dedupe || !s.IsRawQuery {
+		return &ParseError{Message: "invalid HIGHLIGHT with aggregations", Pos: s.Highlight.Pos()}
+	}
+	if !isFullText(s.Condition) {
+		return &ParseError{Message: "invalid HIGHLIGHT without a full-text query in WHERE", Pos: s.Highlight.Pos()}
+	}
+	return nil
+}
+
+func (s *SelectStatement) validateConditions() error {
+	if err := validateCondition(s.Condition, ILLEGAL); err != nil {
+		return err
+	}
+	if s.PostFilter == nil {
+		return nil
+	}
+	// The post filter only leaves the aggregations unfiltered.
+	if len(s.Dimensions) == 0 && !s.Dedupe && s.IsRawQuery {
+		return &ParseError{Message: "invalid POST_FILTER without aggregations, use WHERE", Pos: s.PostFilter.Pos()}
+	}
+	return validateCondition(s.PostFilter, ILLEGAL)
+}
+
+// valid condition expr.
+func validateCondition(expr Expr, op Token) error {
+	if expr == nil {
+		return nil
+	}
+
+	switch expr := expr.(type) {
+	case *Call:
+		if expr.Name == "now" {
+			if len(expr.Args) != 0 {
+				return &ParseError{Message: fmt.Sprintf("invalid number of arguments for now, expected 0, got %d", len(expr.Args)), Pos: expr.Pos()}
+			}
+			switch op {
+			case ILLEGAL, AND, OR:
+				return &ParseError{Message: "invalid filter, now() must be compared with a time field", Pos: expr.Pos()}
+			}
+			return nil
+		}
+		switch op {
+		case ILLEGAL, AND, OR:
+		default:
+			return &ParseError{Message: fmt.Sprintf("invalid filter, unsupport function %s", expr.String()), Pos: expr.Pos()}
+		}
+		return expr.validateQueryArgs()
+	case *BinaryExpr:
+		_, lhsDuration := expr.LHS.(*DurationLiteral)
+		_, rhsDuration := expr.RHS.(*DurationLiteral)
+		if (lhsDuration || rhsDuration) && !isTimeExpr(expr) {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s: durations can only be added to or subtracted from now()", expr.String()), Pos: expr.Pos()}
+		}
+		if isTimeExpr(expr.LHS) || isTimeExpr(expr.RHS) {
+			_, lhsRef := expr.LHS.(*VarRef)
+			_, rhsRef := expr.RHS.(*VarRef)
+			switch expr.Op {
+			case LT, LTE, GT, GTE:
+				if !lhsRef && !rhsRef {
+					return &ParseError{Message: fmt.Sprintf("invalid filter, %s: now() must be compared with a time field", expr.String()), Pos: expr.Pos()}
+				}
+			default:
+				if !isTimeExpr(expr) {
+					return &ParseError{Message: fmt.Sprintf("invalid filter, unsupport op %s for now()", expr.Op.String()), Pos: expr.Pos()}
+				}
+			}
+		}
+		if IsLikeOp(expr.Op) {
+			_, isRef := expr.LHS.(*VarRef)
+			_, isStr := expr.RHS.(*StringLiteral)
+			if !isRef || !isStr {
+				return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a field and a string pattern", expr.Op.String()), Pos: expr.Pos()}
+			}
+		}
+		if IsListOp(expr.Op) {
+			if _, ok := expr.LHS.(*VarRef); !ok {
+				return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a field", expr.Op.String()), Pos: expr.LHS.Pos()}
+			}
+			if _, ok := expr.RHS.(*ListLiteral); !ok {
+				return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a list", expr.Op.String()), Pos: expr.RHS.Pos()}
+			}
+		}
+		if expr.Op != AND && expr.Op != OR {
+			for _, operand := range []Expr{expr.LHS, expr.RHS} {
+				if isPredicate(operand) {
+					return &ParseError{Message: fmt.Sprintf("invalid filter, %s can't be an operand of %s", operand.String(), expr.Op.String()), Pos: operand.Pos()}
+				}
+			}
+		}
+		_, lhsNull := expr.LHS.(*NullLiteral)
+		_, rhsNull := expr.RHS.(*NullLiteral)
+		if (lhsNull || rhsNull) && (expr.Op == EQ || expr.Op == NEQ) && nullComparison(expr) == nil {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s NULL requires a field", expr.Op.String()), Pos: expr.Pos()}
+		}
+		err := validateCondition(expr.LHS, expr.Op)
+		if err != nil {
+			return err
+		}
+		return validateCondition(expr.RHS, expr.Op)
+	case *ParenExpr:
+		return validateCondition(expr.Expr, ILLEGAL)
+	case *NotExpr:
+		return validateCondition(expr.Expr, ILLEGAL)
+	case *BetweenExpr:
+		if _, ok := expr.Expr.(*VarRef); !ok {
+			return &ParseError{Message: "invalid filter, BETWEEN requires a field", Pos: expr.Expr.Pos()}
+		}
+		for _, bound := range []Expr{expr.Lower, expr.Upper} {
+			switch bound.(type) {
+			case *IntegerLiteral, *NumberLiteral, *StringLiteral:
+			default:
+				return &ParseError{Message: fmt.Sprintf("invalid filter, unsupport BETWEEN bound %s", bound.String()), Pos: bound.Pos()}
+			}
+		}
+		return nil
+	case *IsNullExpr:
+		if _, ok := expr.Expr.(*VarRef); !ok {
+			return &ParseError{Message: "invalid filter, IS NULL requires a field", Pos: expr.Expr.Pos()}
+		}
+		return nil
+	case *NullLiteral:
+		switch op {
+		case EQ, NEQ:
+			return nil
+		case ILLEGAL, AND, OR:
+			return &ParseError{Message: "invalid filter, NULL must be compared with a field", Pos: expr.Pos()}
+		default:
+			return &ParseError{Message: fmt.Sprintf("invalid filter, unsupport op %s for NULL", op.String()), Pos: expr.Pos()}
+		}
+	case *DurationLiteral:
+		if expr.Val%time.Second != 0 {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, duration %s must be a whole number of seconds", expr.String()), Pos: expr.Pos()}
+		}
+		return nil
+	case *RegexLiteral:
+		switch op {
+		case EQREGEX, NEQREGEX:
+			return nil
+		default:
+			return &ParseError{Message: fmt.Sprintf("invalid filter, unsupport op %s for regex", op.String()), Pos: expr.Pos()}
+		}
+	case *StringLiteral:
+		switch op {
+		case LT, LTE, GT, GTE, SUB, MUL, DIV, MOD, ADD, BITAND, BITOR, BITXOR, CONCAT:
+			return &ParseError{Message: fmt.Sprintf("invalid filter, unsupport op %s for string", op.String()), Pos: expr.Pos()}
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+}
+
+// isPredicate returns true if expr is translated to a query of its own, it
+// can't be compared or computed like a script value.
+func isPredicate(expr Expr) bool {
+	for {
+		paren, ok := expr.(*ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.Expr
+	}
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		return IsListOp(expr.Op) || IsLikeOp(expr.Op)
+	case *Call:
+		return isQueryFunction(expr.Name)
+	case *BetweenExpr, *IsNullExpr, *NotExpr:
+		return true
+	}
+	return false
+}
+
+func (s *SelectStatement) validateFields() error {
+	for _, f := range s.Fields {
+		var c validateField
+		Walk(&c, f.Expr)
+		if c.foundInvalid {
+			return &ParseError{Message: fmt.Sprintf("invalid operator %s in SELECT field, only support +-*/%%&|^ and ||", c.badToken), Pos: c.badPos}
+		}
+		switch expr := f.Expr.(type) {
+		case *BinaryExpr:
+			if err := expr.validate(); err != nil {
+				return err
+			}
+			for _, c := range walkFunctionCalls(expr) {
+				if c.Name == "nested" {
+					return &ParseError{Message: fmt.Sprintf("invalid nested() in SELECT expression %s", expr.String()), Pos: c.Pos()}
+				}
+			}
+		case *CaseExpr:
+			if _, err := painlessScript(expr); err != nil {
+				return err
+			}
+		case *ParenExpr, *Call, *VarRef, *Wildcard:
+		default:
+			return &ParseError{Message: fmt.Sprintf("invalid field %v in SELECT field", expr), Pos: expr.Pos()}
+		}
+		if f.Filter != nil {
+			if err := f.validateFilter(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateGrouping checks that aggregates aren't mixed with plain fields
+// without GROUP BY, the translation returns either the hits or the metrics.
+func (s *SelectStatement) validateGrouping() error {
+	if len(s.Dimensions) > 0 || s.Dedupe {
+		return nil
+	}
+	var plain *Field
+	aggregate := false
+	for _, f := range s.Fields {
+		if len(walkFunctionCalls(f.Expr)) > 0 {
+			aggregate = true
+		} else if plain == nil {
+			plain = f
+		}
+	}
+	if aggregate && plain != nil {
+		msg := fmt.Sprintf("invalid field %s mixed with aggregates, expected GROUP BY", plain.Expr.String())
+		return &ParseError{Message: msg, Pos: plain.Pos()}
+	}
+	return nil
+}
+
+// validateFilter checks the FILTER (WHERE ...) of a field applies to a metric
+// aggregation and is a valid condition.
+func (f *Field) validateFilter() error {
+	c, ok := f.Expr.(*Call)
+	if !ok || !isMetricFunction(c.Name) {
+		return &ParseError{Message: fmt.Sprintf("invalid FILTER on %s, expected a metric aggregation", f.Expr.String()), Pos: f.Pos()}
+	}
+	return validateCondition(f.Filter, ILLEGAL)
+}
+
+// isQueryFunction returns true if name is a function translated to an es
+// query in a condition.
+func isQueryFunction(name string) bool {
+	switch name {
+	case "match", "match_phrase", "multi_match", "query_string", "prefix",
+		"fuzzy", "exists", "terms_lookup", "geo_distance", "geo_bounding_box":
+		return true
+	}
+	return false
+}
+
+// isMetricFunction returns true if name is a metric aggregation function.
+func isMetricFunction(name string) bool {
+	switch name {
+	case "count", "value_count", "cardinality", "avg", "sum", "min", "max",
+		"stats", "extended_stats", "percentile", "percentile_rank", "top", "script":
+		return true
+	}
+	return false
+}
+
+// isAggregateFunction returns true if name is a function the SELECT and
+// HAVING clauses translate to an aggregation.
+func isAggregateFunction(name string) bool {
+	if name == "nested" || isMetricFunction(name) || isPipelineFunction(name) {
+		return true
+	}
+	for i := metricBegin + 1; i < metricEnd; i++ {
+		if aggs[i] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isFieldMetricFunction returns true if name is a metric function whose first
+// argument is the field it aggregates.
+func isFieldMetricFunction(name string) bool {
+	switch name {
+	case "top", "script":
+		return false
+	case "distinct":
+		return true
+	}
+	return isMetricFunction(name)
+}
+
+// isPipelineFunction returns true if name computes a metric over the
+// histogram buckets, e.g. moving_avg(count(*), 5).
+func isPipelineFunction(name string) bool {
+	switch name {
+	case "moving_avg", "derivative", "cumulative_sum":
+		return true
+	}
+	return false
+}
+
+// validatePipelines checks pipeline functions are computed over histogram
+// buckets from a metric of the select, or the doc count.
+func (s *SelectStatement) validatePipelines() error {
+	for _, f := range s.Fields {
+		c, ok := f.Expr.(*Call)
+		if !ok || !isPipelineFunction(c.Name) {
+			continue
+		}
+		var last *Call
+		if n := len(s.Dimensions); n > 0 {
+			last, _ = s.Dimensions[n-1].Expr.(*Call)
+		}
+		if last == nil || (last.Name != "histogram" && last.Name != "date_histogram") {
+			msg := fmt.Sprintf("invalid %s, expected GROUP BY histogram() or date_histogram()", c.String())
+			return &ParseError{Message: msg, Pos: c.Pos()}
+		}
+		if ref, ok := c.Args[0].(*VarRef); ok {
+			field, ok := s.aliasTable()[ref.Val]
+			if !ok {
+				// a field which is not an alias of the select
+				return &ParseError{Message: fmt.Sprintf("expected metric aggregation argument in %s()", c.Name), Pos: ref.Pos()}
+			}
+			if fn, ok := field.Expr.(*Call); !ok || !isPipelineMetric(fn.Name) {
+				msg := fmt.Sprintf("invalid %s, %s is not a metric aggregation", c.String(), ref.Val)
+				return &ParseError{Message: msg, Pos: ref.Pos()}
+			}
+			continue
+		}
+		if metric := c.Args[0].(*Call); metric.metricAggType() != StarCount && s.sortMetric(metric.String()) == nil {
+			msg := fmt.Sprintf("invalid %s, %s is not in the SELECT list", c.String(), metric.String())
+			return &ParseError{Message: msg, Pos: metric.Pos()}
+		}
+	}
+	return nil
+}
+
+func (s *SelectStatement) validateAggregates() error {
+	calls := s.FunctionCalls()
+	if s.Having != nil {
+		if len(s.Dimensions) == 0 {
+			return &ParseError{Message: "invalid HAVING, expected GROUP BY", Pos: s.Having.Pos()}
+		}
+		for _, ref := range havingRefs(s.Having) {
+			if s.sortMetric(ref.Val) == nil && !s.isGroupBySort(ref.Val) {
+				msg := fmt.Sprintf("invalid HAVING, unknown alias %s", ref.Val)
+				return &ParseError{Message: msg, Pos: ref.Pos()}
+			}
+		}
+		for _, c := range walkFunctionCalls(s.Having) {
+			if c.Name == "nested" {
+				return &ParseError{Message: "invalid nested() in HAVING, expected a field alias", Pos: c.Pos()}
+			}
+			calls = append(calls, c)
+		}
+	}
+	for _, expr := range calls {
+		if !isAggregateFunction(expr.Name) {
+			return &ParseError{Message: fmt.Sprintf("invalid aggregate, unsupport function %s", expr.String()), Pos: expr.Pos()}
+		}
+		if len(expr.Args) < 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected at least 1, got %d", expr.Name, len(expr.Args)), Pos: expr.Pos()}
+		}
+		if err := expr.validateAggregateArgs(); err != nil {
+			return err
+		}
+		if expr.Name == "top" || expr.Name == "script" {
+			// the size and sort of top() and the scripts of script() are
+			// checked with their arguments
+			continue
+		}
+		switch fc := expr.Args[0].(type) {
+		case *VarRef:
+			// do nothing
+		case *BinaryExpr:
+			if err := fc.validateArgs(); err != nil {
+				return err
+			}
+		case *Wildcard:
+		case *Call:
+		default:
+			return &ParseError{Message: fmt.Sprintf("expected field argument in %s()", expr.Name), Pos: expr.Args[0].Pos()}
+		}
+	}
+	return nil
+}
+
+// multiMatchTypes are the es multi_match query types.
+var multiMatchTypes = map[string]bool{
+	"best_fields":   true,
+	"most_fields":   true,
+	"cross_fields":  true,
+	"phrase":        true,
+	"phrase_prefix": true,
+	"bool_prefix":   true,
+}
+
+// expressionFunctions are the functions of the lucene expression scripts.
+var expressionFunctions = map[string]bool{
+	"abs": true, "acos": true, "acosh": true, "asin": true, "asinh": true,
+	"atan": true, "atan2": true, "atanh": true, "ceil": true, "cos": true,
+	"cosh": true, "exp": true, "floor": true, "haversin": true, "ln": true,
+	"log10": true, "logn": true, "max": true, "min": true, "pow": true,
+	"sin": true, "sinh": true, "sqrt": true, "tan": true, "tanh": true,
+}
+
+// geoDistanceRegexp matches an es distance, a number followed by a unit.
+var geoDistanceRegexp = regexp.MustCompile(`^\d+(\.\d+)?(mi|miles|yd|yards|ft|feet|in|inch|km|kilometers|m|meters|cm|centimeters|mm|millimeters|NM|nmi|nauticalmiles)$`)
+
+// isPipelineMetric returns true if the function name is a single value
+// metric a pipeline can read from each bucket.
+func isPipelineMetric(name string) bool {
+	switch name {
+	case "count", "value_count", "cardinality", "avg", "sum", "min", "max":
+		return true
+	}
+	return false
 }
 
-func (s *SelectStatement) validateConditions() error {
-	expr := s.Condition
-	if expr == nil {
+// validatePipelineMetric checks the first argument of a pipeline function is
+// a single value metric, which the pipeline reads from each bucket. An alias
+// of the select is resolved by validatePipelines.
+func (c *Call) validatePipelineMetric() error {
+	if _, ok := c.Args[0].(*VarRef); ok {
 		return nil
 	}
-	return validateCondition(expr, ILLEGAL)
+	fn, ok := c.Args[0].(*Call)
+	if !ok || !isPipelineMetric(fn.Name) {
+		return &ParseError{Message: fmt.Sprintf("expected metric aggregation argument in %s()", c.Name), Pos: c.Args[0].Pos()}
+	}
+	if len(fn.Args) < 1 {
+		return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected at least 1, got %d", fn.Name, len(fn.Args)), Pos: fn.Pos()}
+	}
+	return fn.validateAggregateArgs()
 }
 
-// valid condition expr.
-func validateCondition(expr Expr, op Token) error {
-	if expr == nil {
-		return nil
+// validateGeoPoint checks the latitude and longitude arguments of a geo query function.
+func (c *Call) validateGeoPoint(lat, lon Expr) error {
+	if v := castToFloat(literalValue(lat)); !isNumberLiteral(lat) || v < -90 || v > 90 {
+		return &ParseError{Message: fmt.Sprintf("invalid filter, %s latitude %s, expected a number from -90 to 90", c.Name, lat.String()), Pos: lat.Pos()}
 	}
+	if v := castToFloat(literalValue(lon)); !isNumberLiteral(lon) || v < -180 || v > 180 {
+		return &ParseError{Message: fmt.Sprintf("invalid filter, %s longitude %s, expected a number from -180 to 180", c.Name, lon.String()), Pos: lon.Pos()}
+	}
+	return nil
+}
 
-	switch expr := expr.(type) {
-	case *Call:
-		return fmt.Errorf("invalid filter, unsupport function %s", expr.String())
-	case *BinaryExpr:
-		err := validateCondition(expr.LHS, expr.Op)
-		if err != nil {
-			return err
+// validateQueryArgs checks the arguments of a query function used as a WHERE predicate.
+func (c *Call) validateQueryArgs() error {
+	switch c.Name {
+	case "match", "match_phrase":
+		if len(c.Args) < 2 || len(c.Args) > 3 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 2 or 3, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
 		}
-		return validateCondition(expr.RHS, expr.Op)
-	case *ParenExpr:
-		return validateCondition(expr.Expr, ILLEGAL)
-	case *RegexLiteral:
-		switch op {
-		case EQREGEX, NEQREGEX:
-			return nil
-		default:
-			return fmt.Errorf("invalid filter, unsupport op %s for regex", op.String())
+		_, isRef := c.Args[0].(*VarRef)
+		_, isStr := c.Args[1].(*StringLiteral)
+		if !isRef || !isStr {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a field and a string query", c.Name), Pos: c.Pos()}
 		}
-	case *StringLiteral:
-		switch op {
-		case LT, LTE, GT, GTE, SUB, MUL, DIV, ADD:
-			return fmt.Errorf("invalid filter, unsupport op %s for string", op.String())
-		default:
+		if len(c.Args) < 3 {
 			return nil
 		}
-	default:
+		if c.Name == "match_phrase" {
+			if slop, ok := c.Args[2].(*IntegerLiteral); !ok || slop.Val < 0 {
+				return &ParseError{Message: fmt.Sprintf("invalid filter, %s slop must be a non-negative integer", c.Name), Pos: c.Args[2].Pos()}
+			}
+		} else if op, ok := c.Args[2].(*StringLiteral); !ok || (strings.ToLower(op.Val) != "and" && strings.ToLower(op.Val) != "or") {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s operator must be 'and' or 'or'", c.Name), Pos: c.Args[2].Pos()}
+		}
+		return nil
+	case "geo_distance":
+		if len(c.Args) != 4 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 4, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if _, ok := c.Args[0].(*VarRef); !ok {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a geo_point field", c.Name), Pos: c.Args[0].Pos()}
+		}
+		if err := c.validateGeoPoint(c.Args[1], c.Args[2]); err != nil {
+			return err
+		}
+		if d, ok := c.Args[3].(*StringLiteral); !ok || !geoDistanceRegexp.MatchString(d.Val) {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s distance %s, expected a number with a unit such as '10km'", c.Name, c.Args[3].String()), Pos: c.Args[3].Pos()}
+		}
+		return nil
+	case "geo_bounding_box":
+		if len(c.Args) != 5 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 5, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if _, ok := c.Args[0].(*VarRef); !ok {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a geo_point field", c.Name), Pos: c.Args[0].Pos()}
+		}
+		for i := 1; i < len(c.Args); i += 2 {
+			if err := c.validateGeoPoint(c.Args[i], c.Args[i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "prefix":
+		if len(c.Args) < 2 || len(c.Args) > 3 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 2 or 3, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		_, isRef := c.Args[0].(*VarRef)
+		_, isStr := c.Args[1].(*StringLiteral)
+		if !isRef || !isStr {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a field and a string value", c.Name), Pos: c.Pos()}
+		}
+		if len(c.Args) == 3 {
+			if _, ok := c.Args[2].(*BooleanLiteral); !ok {
+				return &ParseError{Message: fmt.Sprintf("invalid filter, %s case insensitivity must be true or false, got %s", c.Name, c.Args[2].String()), Pos: c.Args[2].Pos()}
+			}
+		}
+		return nil
+	case "fuzzy":
+		if len(c.Args) < 2 || len(c.Args) > 3 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 2 or 3, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		_, isRef := c.Args[0].(*VarRef)
+		_, isStr := c.Args[1].(*StringLiteral)
+		if !isRef || !isStr {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a field and a string value", c.Name), Pos: c.Pos()}
+		}
+		if len(c.Args) == 3 && fuzziness(c.Args[2]) == nil {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s fuzziness %s, expected 0, 1, 2 or 'AUTO'", c.Name, c.Args[2].String()), Pos: c.Args[2].Pos()}
+		}
+		return nil
+	case "terms_lookup":
+		if len(c.Args) != 4 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 4, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if _, ok := c.Args[0].(*VarRef); !ok {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a field, got %s", c.Name, c.Args[0].String()), Pos: c.Args[0].Pos()}
+		}
+		for i, name := range []string{"index", "id", "path"} {
+			switch arg := c.Args[i+1].(type) {
+			case *StringLiteral:
+				if arg.Val != "" {
+					continue
+				}
+			case *IntegerLiteral:
+				// document ids are often numbers
+				if name == "id" {
+					continue
+				}
+			}
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s %s %s, expected a non-empty string", c.Name, name, c.Args[i+1].String()), Pos: c.Args[i+1].Pos()}
+		}
+		return nil
+	case "exists":
+		if len(c.Args) != 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if _, ok := c.Args[0].(*VarRef); !ok {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a field, got %s", c.Name, c.Args[0].String()), Pos: c.Args[0].Pos()}
+		}
+		return nil
+	case "query_string":
+		if len(c.Args) != 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if _, ok := c.Args[0].(*StringLiteral); !ok {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a string query", c.Name), Pos: c.Args[0].Pos()}
+		}
+		return nil
+	case "multi_match":
+		if len(c.Args) < 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected at least 2, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if _, ok := c.Args[0].(*StringLiteral); !ok {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires a string query", c.Name), Pos: c.Args[0].Pos()}
+		}
+		fields := c.Args[1:]
+		if n := len(fields); n > 0 {
+			if typ, ok := fields[n-1].(*StringLiteral); ok {
+				if !multiMatchTypes[typ.Val] {
+					return &ParseError{Message: fmt.Sprintf("invalid filter, unsupport %s type %s", c.Name, typ.String()), Pos: typ.Pos()}
+				}
+				fields = fields[:n-1]
+			}
+		}
+		if len(fields) == 0 {
+			return &ParseError{Message: fmt.Sprintf("invalid filter, %s requires at least one field", c.Name), Pos: c.Pos()}
+		}
+		for _, f := range fields {
+			if _, ok := f.(*VarRef); !ok {
+				return &ParseError{Message: fmt.Sprintf("invalid filter, %s expected field argument, got %s", c.Name, f.String()), Pos: f.Pos()}
+			}
+		}
 		return nil
 	}
+	return &ParseError{Message: fmt.Sprintf("invalid filter, unsupport function %s", c.String()), Pos: c.Pos()}
 }
 
-func (s *SelectStatement) validateFields() error {
-	for _, f := range s.Fields {
-		var c validateField
-		Walk(&c, f.Expr)
-		if c.foundInvalid {
-			return fmt.Errorf("invalid operator %s in SELECT field, only support +-*/", c.badToken)
+// fuzziness returns the es fuzziness of the argument of fuzzy(), an edit
+// distance of at most 2 or "AUTO". It returns nil for other values.
+func fuzziness(arg Expr) interface{} {
+	switch arg := arg.(type) {
+	case *IntegerLiteral:
+		if arg.Val >= 0 && arg.Val <= 2 {
+			return arg.Val
 		}
-		switch expr := f.Expr.(type) {
-		case *BinaryExpr:
-			if err := expr.validate(); err != nil {
-				return err
-			}
-		case *ParenExpr, *Call, *VarRef, *Wildcard:
-		default:
-			return fmt.Errorf("invalid field %v in SELECT field", expr)
+	case *StringLiteral:
+		if strings.EqualFold(arg.Val, "auto") {
+			return "AUTO"
 		}
 	}
 	return nil
 }
 
-func (s *SelectStatement) validateAggregates() error {
-	for _, f := range s.Fields {
-		for _, expr := range walkFunctionCalls(f.Expr) {
-			if len(expr.Args) < 1 {
-				return fmt.Errorf("invalid number of arguments for %s, expected at least 1, got %d", expr.Name, len(expr.Args))
+// validateAggregateArgs checks the arguments specific to an aggregate function.
+func (c *Call) validateAggregateArgs() error {
+	if d, ok := c.Args[0].(*Call); ok && d.Name == "distinct" {
+		if c.Name != "count" {
+			return &ParseError{Message: fmt.Sprintf("invalid DISTINCT in %s(), only support count(DISTINCT field)", c.Name), Pos: d.Pos()}
+		} else if len(d.Args) != 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for count(DISTINCT), expected 1, got %d", len(d.Args)), Pos: d.Pos()}
+		} else if _, ok := d.Args[0].(*VarRef); !ok {
+			return &ParseError{Message: "expected field argument in count(DISTINCT)", Pos: d.Args[0].Pos()}
+		}
+	}
+
+	switch c.Name {
+	case "count":
+		if len(c.Args) > 2 || (len(c.Args) == 2 && c.metricAggType() != Cardinality) {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if len(c.Args) == 2 {
+			return c.validatePrecisionThreshold()
+		}
+	case "cardinality":
+		if len(c.Args) > 2 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1 or 2, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if _, ok := c.Args[0].(*VarRef); !ok {
+			return &ParseError{Message: fmt.Sprintf("expected field argument in %s()", c.Name), Pos: c.Args[0].Pos()}
+		}
+		if len(c.Args) == 2 {
+			return c.validatePrecisionThreshold()
+		}
+	case "nested":
+		if len(c.Args) != 2 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 2, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		path, ok := c.Args[0].(*VarRef)
+		if !ok {
+			return &ParseError{Message: fmt.Sprintf("expected nested path argument in %s()", c.Name), Pos: c.Args[0].Pos()}
+		}
+		fn, ok := c.Args[1].(*Call)
+		if !ok || fn.Name == "nested" || !isAggregateFunction(fn.Name) {
+			return &ParseError{Message: fmt.Sprintf("expected metric aggregation argument in %s()", c.Name), Pos: c.Args[1].Pos()}
+		}
+		if len(fn.Args) < 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected at least 1, got %d", fn.Name, len(fn.Args)), Pos: fn.Pos()}
+		}
+		if err := fn.validateAggregateArgs(); err != nil {
+			return err
+		}
+		for _, name := range walkNames(fn) {
+			if !strings.HasPrefix(name, path.Val+".") {
+				return &ParseError{Message: fmt.Sprintf("invalid nested field %s, expected prefix %s.", name, path.Val), Pos: fn.Pos()}
 			}
-			switch fc := expr.Args[0].(type) {
-			case *VarRef:
-				// do nothing
-			case *BinaryExpr:
-				if err := fc.validateArgs(); err != nil {
-					return err
-				}
-			case *Wildcard:
-			case *Call:
-			default:
-				return fmt.Errorf("expected field argument in %s()", expr.Name)
+		}
+	case "avg", "sum", "min", "max", "value_count":
+		if len(c.Args) != 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		switch c.Args[0].(type) {
+		case *VarRef, *BinaryExpr:
+		default:
+			return &ParseError{Message: fmt.Sprintf("expected field argument in %s()", c.Name), Pos: c.Args[0].Pos()}
+		}
+	case "top":
+		if len(c.Args) > 2 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1 or 2, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if size, ok := c.Args[0].(*IntegerLiteral); !ok || size.Val <= 0 {
+			return &ParseError{Message: fmt.Sprintf("invalid size %s in %s(), expected a positive integer", c.Args[0].String(), c.Name), Pos: c.Args[0].Pos()}
+		}
+		if len(c.Args) == 2 {
+			if _, ok := c.Args[1].(*OrderByExpr); !ok {
+				return &ParseError{Message: fmt.Sprintf("invalid sort %s in %s(), expected ORDER BY", c.Args[1].String(), c.Name), Pos: c.Args[1].Pos()}
+			}
+		}
+	case "derivative", "cumulative_sum":
+		if len(c.Args) != 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if err := c.validatePipelineMetric(); err != nil {
+			return err
+		}
+	case "moving_avg":
+		if len(c.Args) != 2 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 2, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		if err := c.validatePipelineMetric(); err != nil {
+			return err
+		}
+		if n, ok := c.Args[1].(*IntegerLiteral); !ok || n.Val <= 0 {
+			return &ParseError{Message: fmt.Sprintf("invalid window %s in %s(), expected a positive integer", c.Args[1].String(), c.Name), Pos: c.Args[1].Pos()}
+		}
+	case "script":
+		if len(c.Args) > 4 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1 to 4, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		for _, arg := range c.Args {
+			if _, ok := arg.(*StringLiteral); !ok {
+				return &ParseError{Message: fmt.Sprintf("invalid script %s in %s(), expected a string", arg.String(), c.Name), Pos: arg.Pos()}
+			}
+		}
+	case "stats", "extended_stats":
+		if len(c.Args) != 1 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected 1, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+	case "percentile", "percentiles":
+		// percentiles() without percents computes the es default ones
+		if c.Name == "percentile" && len(c.Args) < 2 {
+			return &ParseError{Message: fmt.Sprintf("invalid number of arguments for %s, expected at least 2, got %d", c.Name, len(c.Args)), Pos: c.Pos()}
+		}
+		for _, arg := range c.Args[1:] {
+			if v := castToFloat(literalValue(arg)); !isNumberLiteral(arg) || v < 0 || v > 100 {
+				return &ParseError{Message: fmt.Sprintf("invalid percentile %s in %s(), expected a number between 0 and 100", arg.String(), c.Name), Pos: arg.Pos()}
 			}
 		}
 	}
 	return nil
 }
 
+// maxPrecisionThreshold is the highest precision_threshold of es cardinality.
+const maxPrecisionThreshold = 40000
+
+// validatePrecisionThreshold checks the second argument of a cardinality
+// metric, count(DISTINCT field, n) or cardinality(field, n).
+func (c *Call) validatePrecisionThreshold() error {
+	if n, ok := c.Args[1].(*IntegerLiteral); !ok || n.Val <= 0 || n.Val > maxPrecisionThreshold {
+		return &ParseError{Message: fmt.Sprintf("invalid precision_threshold %s in %s(), expected an integer from 1 to %d", c.Args[1].String(), c.Name, maxPrecisionThreshold), Pos: c.Args[1].Pos()}
+	}
+	return nil
+}
+
 // NamesInWhere returns the field and tag names (idents) referenced in the where clause
 func (s *SelectStatement) NamesInWhere() []string {
 	var a []string
@@ -565,12 +1726,67 @@ func (s *SelectStatement) NamesInDimension() []string {
 	var a []string
 
 	for _, d := range s.Dimensions {
+		// documents missing the field are bucketed by missing(), keep them
+		if call, ok := d.Expr.(*Call); ok && termsMissing(call) != nil {
+			continue
+		}
 		a = append(a, walkNames(d.Expr)...)
 	}
 
 	return a
 }
 
+// NamesInStatement returns the unique field names (idents) read by the select
+// list, where, group by, having and order by clauses, in order of appearance.
+// Aliases, literals, function names and sorts by aggregates are left out.
+func (s *SelectStatement) NamesInStatement() []string {
+	aliases := make(map[string]bool)
+	for _, f := range s.Fields {
+		aliases[f.Alias] = f.Alias != ""
+	}
+	for _, d := range s.Dimensions {
+		aliases[d.Alias] = d.Alias != ""
+	}
+
+	var a []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		name = cleanDocString(name)
+		if name == "" || seen[name] || aliases[name] {
+			return
+		}
+		seen[name] = true
+		a = append(a, name)
+	}
+	visit := func(n Node) {
+		switch n := n.(type) {
+		case *VarRef:
+			add(n.Val)
+		case *SortField:
+			// count(*) or _key sorts don't name a field
+			if !strings.Contains(n.Name, "(") && !strings.HasPrefix(n.Name, "_") {
+				add(n.Name)
+			}
+		}
+	}
+	WalkFunc(s, visit)
+	WalkFunc(s.Having, visit)
+	return a
+}
+
+// termsMissing returns the missing() label of a terms() dimension, nil if unset.
+func termsMissing(call *Call) *StringLiteral {
+	if call.Name != "terms" || len(call.Args) < 3 {
+		return nil
+	}
+	missing, ok := call.Args[len(call.Args)-1].(*Call)
+	if !ok || missing.Name != "missing" || len(missing.Args) != 1 {
+		return nil
+	}
+	label, _ := missing.Args[0].(*StringLiteral)
+	return label
+}
+
 // walkNames will walk the Expr and return the database fields
 func walkNames(exp Expr) []string {
 	switch expr := exp.(type) {
@@ -592,6 +1808,12 @@ func walkNames(exp Expr) []string {
 		return ret
 	case *ParenExpr:
 		return walkNames(expr.Expr)
+	case *BetweenExpr:
+		return walkNames(expr.Expr)
+	case *IsNullExpr:
+		return walkNames(expr.Expr)
+	case *NotExpr:
+		return walkNames(expr.Expr)
 	}
 
 	return nil
@@ -624,6 +1846,36 @@ func walkRefs(exp Expr) []VarRef {
 	return nil
 }
 
+// havingRefs returns the field aliases referenced in the having clause
+// outside of function calls.
+func havingRefs(exp Expr) []*VarRef {
+	switch expr := exp.(type) {
+	case *VarRef:
+		return []*VarRef{expr}
+	case *BinaryExpr:
+		var ret []*VarRef
+		ret = append(ret, havingRefs(expr.LHS)...)
+		ret = append(ret, havingRefs(expr.RHS)...)
+		return ret
+	case *ParenExpr:
+		return havingRefs(expr.Expr)
+	}
+	return nil
+}
+
+// aliasTable maps the aliases of the select fields to their fields. ORDER BY,
+// of the buckets or of the hits, HAVING and pipeline functions resolve
+// aliases through it.
+func (s *SelectStatement) aliasTable() map[string]*Field {
+	table := make(map[string]*Field)
+	for _, f := range s.Fields {
+		if f.Alias != "" {
+			table[f.Alias] = f
+		}
+	}
+	return table
+}
+
 // FunctionCalls returns the Call objects from the query
 func (s *SelectStatement) FunctionCalls() []*Call {
 	var a []*Call
@@ -748,8 +2000,10 @@ func (a Fields) String() string {
 
 // Field represents an expression retrieved from a select statement.
 type Field struct {
-	Expr  Expr
-	Alias string
+	Expr   Expr
+	Filter Expr
+	Alias  string
+	position
 }
 
 // Name returns the name of the field. Returns alias, if set.
@@ -780,6 +2034,9 @@ func (f *Field) Name() string {
 // String returns a string representation of the field.
 func (f *Field) String() string {
 	str := f.Expr.String()
+	if f.Filter != nil {
+		str = fmt.Sprintf("%s FILTER (WHERE %s)", str, f.Filter.String())
+	}
 
 	if f.Alias == "" {
 		return str
@@ -808,6 +2065,19 @@ func (a Dimensions) String() string {
 type Dimension struct {
 	Expr  Expr
 	Alias string
+	position
+}
+
+// aggName returns the name of the bucket aggregation of the dimension, its
+// alias or its field.
+func (d *Dimension) aggName() string {
+	if d.Alias != "" {
+		return cleanDocString(d.Alias)
+	}
+	if call, ok := d.Expr.(*Call); ok && call.Name == "terms" {
+		return fieldName(call.Args[0])
+	}
+	return fieldName(d.Expr)
 }
 
 // String returns a string representation of the dimension.
@@ -824,12 +2094,17 @@ func (d *Dimension) String() string {
 type VarRef struct {
 	Val      string
 	Segments []string
+	position
 }
 
-// String returns a string representation of the variable reference.
+// String returns a string representation of the variable reference. The
+// segments of a parsed field path are quoted when needed, a rewritten
+// reference such as a doc value is returned as is.
 func (r *VarRef) String() string {
-	buf := bytes.NewBufferString(r.Val)
-	return buf.String()
+	if len(r.Segments) == 0 || strings.Join(r.Segments, ".") != r.Val {
+		return r.Val
+	}
+	return quoteSegments(r.Segments)
 }
 
 // VarRefs represents a slice of VarRef types.
@@ -848,6 +2123,7 @@ func (a VarRefs) Strings() []string {
 type Call struct {
 	Name string
 	Args []Expr
+	position
 }
 
 // String returns a string representation of the call.
@@ -865,22 +2141,44 @@ func (c *Call) String() string {
 // NumberLiteral represents a numeric literal.
 type NumberLiteral struct {
 	Val float64
+	position
 }
 
 // String returns a string representation of the literal.
-func (l *NumberLiteral) String() string { return strconv.FormatFloat(l.Val, 'f', 3, 64) }
+func (l *NumberLiteral) String() string { return formatNumber(l.Val) }
+
+// formatNumber returns the shortest decimal representation of v which is
+// scanned back as a number, keeping a fraction for integral values.
+func formatNumber(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
 
 // IntegerLiteral represents an integer literal.
 type IntegerLiteral struct {
 	Val int64
+	position
 }
 
 // String returns a string representation of the literal.
 func (l *IntegerLiteral) String() string { return fmt.Sprintf("%d", l.Val) }
 
+// DurationLiteral represents a duration literal.
+type DurationLiteral struct {
+	Val time.Duration
+	position
+}
+
+// String returns a string representation of the literal.
+func (l *DurationLiteral) String() string { return FormatDuration(l.Val) }
+
 // BooleanLiteral represents a boolean literal.
 type BooleanLiteral struct {
 	Val bool
+	position
 }
 
 // String returns a string representation of the literal.
@@ -891,6 +2189,30 @@ func (l *BooleanLiteral) String() string {
 	return "false"
 }
 
+// isNumberLiteral returns true if the expression is an integer or number literal.
+func isNumberLiteral(expr Expr) bool {
+	switch expr.(type) {
+	case *IntegerLiteral, *NumberLiteral:
+		return true
+	}
+	return false
+}
+
+// isTimeExpr returns true if the expression is now() optionally shifted by
+// durations, e.g. "now() - 1h".
+func isTimeExpr(expr Expr) bool {
+	switch expr := expr.(type) {
+	case *Call:
+		return expr.Name == "now"
+	case *BinaryExpr:
+		if _, ok := expr.RHS.(*DurationLiteral); !ok {
+			return false
+		}
+		return (expr.Op == ADD || expr.Op == SUB) && isTimeExpr(expr.LHS)
+	}
+	return false
+}
+
 // isTrueLiteral returns true if the expression is a literal "true" value.
 func isTrueLiteral(expr Expr) bool {
 	if expr, ok := expr.(*BooleanLiteral); ok {
@@ -907,35 +2229,37 @@ func isFalseLiteral(expr Expr) bool {
 	return false
 }
 
-// ListLiteral represents a list of strings literal.
+// ListLiteral represents a list of literals.
 type ListLiteral struct {
 	Vals []interface{}
+	position
 }
 
 // String returns a string representation of the literal.
 func (s *ListLiteral) String() string {
 	var buf bytes.Buffer
-	_, _ = buf.WriteString("[")
+	_, _ = buf.WriteString("(")
 	for idx, tagKey := range s.Vals {
 		if idx != 0 {
 			_, _ = buf.WriteString(", ")
 		}
 		switch v := tagKey.(type) {
 		case string:
-			_, _ = buf.WriteString(QuoteIdent(v))
+			_, _ = buf.WriteString(QuoteString(v))
 		case float64:
-			_, _ = buf.WriteString((fmt.Sprintf("%f", v)))
+			_, _ = buf.WriteString(formatNumber(v))
 		case int64:
 			_, _ = buf.WriteString((fmt.Sprintf("%d", v)))
 		}
 	}
-	_, _ = buf.WriteString("]")
+	_, _ = buf.WriteString(")")
 	return buf.String()
 }
 
 // StringLiteral represents a string literal.
 type StringLiteral struct {
 	Val string
+	position
 }
 
 // String returns a string representation of the literal.
@@ -943,16 +2267,24 @@ func (l *StringLiteral) String() string { return QuoteString(l.Val) }
 
 // nilLiteral represents a nil literal.
 // This is not available to the query language itself. It's only used internally.
-type nilLiteral struct{}
+type nilLiteral struct{ position }
 
 // String returns a string representation of the literal.
 func (l *nilLiteral) String() string { return `nil` }
 
+// NullLiteral represents the NULL literal, only meaningful in a comparison
+// with a field such as "field = NULL".
+type NullLiteral struct{ position }
+
+// String returns a string representation of the literal.
+func (l *NullLiteral) String() string { return `NULL` }
+
 // BinaryExpr represents an operation between two expressions.
 type BinaryExpr struct {
 	Op  Token
 	LHS Expr
 	RHS Expr
+	position
 }
 
 // String returns a string representation of the binary expression.
@@ -966,7 +2298,7 @@ func (e *BinaryExpr) validate() error {
 	if v.err != nil {
 		return v.err
 	} else if v.calls && v.refs {
-		return errors.New("binary expressions cannot mix aggregates and raw fields")
+		return &ParseError{Message: "binary expressions cannot mix aggregates and raw fields", Pos: e.Pos()}
 	}
 	return nil
 }
@@ -977,9 +2309,9 @@ func (e *BinaryExpr) validateArgs() error {
 	if v.err != nil {
 		return v.err
 	} else if v.calls {
-		return errors.New("argument binary expressions cannot mix function")
+		return &ParseError{Message: "argument binary expressions cannot mix function", Pos: e.Pos()}
 	} else if !v.refs {
-		return errors.New("argument binary expressions at least one key")
+		return &ParseError{Message: "argument binary expressions at least one key", Pos: e.Pos()}
 	}
 	return nil
 }
@@ -1036,9 +2368,92 @@ func (v *binaryExprNameVisitor) Visit(n Node) Visitor {
 	return v
 }
 
+// BetweenExpr represents a range predicate "expr [NOT] BETWEEN lower AND upper".
+type BetweenExpr struct {
+	Expr  Expr
+	Lower Expr
+	Upper Expr
+	Not   bool
+	position
+}
+
+// String returns a string representation of the range predicate.
+func (e *BetweenExpr) String() string {
+	op := "BETWEEN"
+	if e.Not {
+		op = "NOT BETWEEN"
+	}
+	return fmt.Sprintf("%s %s %s AND %s", e.Expr.String(), op, e.Lower.String(), e.Upper.String())
+}
+
+// IsNullExpr represents a missing value predicate "expr IS [NOT] NULL".
+type IsNullExpr struct {
+	Expr Expr
+	Not  bool
+	position
+}
+
+// String returns a string representation of the missing value predicate.
+func (e *IsNullExpr) String() string {
+	if e.Not {
+		return fmt.Sprintf("%s IS NOT NULL", e.Expr.String())
+	}
+	return fmt.Sprintf("%s IS NULL", e.Expr.String())
+}
+
+// NotExpr represents a negated predicate "NOT expr".
+type NotExpr struct {
+	Expr Expr
+	position
+}
+
+// String returns a string representation of the negated predicate.
+func (e *NotExpr) String() string { return fmt.Sprintf("NOT %s", e.Expr.String()) }
+
+// CaseExpr represents a "CASE WHEN ... THEN ... [ELSE ...] END" expression.
+type CaseExpr struct {
+	WhenClauses []*WhenClause
+	Else        Expr
+	position
+}
+
+// WhenClause represents a "WHEN condition THEN result" branch of a CASE expression.
+type WhenClause struct {
+	Condition Expr
+	Result    Expr
+}
+
+// String returns a string representation of the case expression.
+func (e *CaseExpr) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("CASE")
+	for _, w := range e.WhenClauses {
+		_, _ = buf.WriteString(" WHEN ")
+		_, _ = buf.WriteString(w.Condition.String())
+		_, _ = buf.WriteString(" THEN ")
+		_, _ = buf.WriteString(w.Result.String())
+	}
+	if e.Else != nil {
+		_, _ = buf.WriteString(" ELSE ")
+		_, _ = buf.WriteString(e.Else.String())
+	}
+	_, _ = buf.WriteString(" END")
+	return buf.String()
+}
+
+// OrderByExpr represents the ORDER BY argument of a function such as top().
+type OrderByExpr struct {
+	SortFields SortFields
+	position
+}
+
+// String returns a string representation of the order by argument.
+func (e *OrderByExpr) String() string { return fmt.Sprintf("ORDER BY %s", e.SortFields.String()) }
+
 // ParenExpr represents a parenthesized expression.
 type ParenExpr struct {
 	Expr Expr
+	position
 }
 
 // String returns a string representation of the parenthesized expression.
@@ -1047,6 +2462,7 @@ func (e *ParenExpr) String() string { return fmt.Sprintf("(%s)", e.Expr.String()
 // RegexLiteral represents a regular expression.
 type RegexLiteral struct {
 	Val *regexp.Regexp
+	position
 }
 
 // String returns a string representation of the literal.
@@ -1074,6 +2490,11 @@ func Walk(v Visitor, node Node) {
 	}
 
 	switch n := node.(type) {
+	case *BetweenExpr:
+		Walk(v, n.Expr)
+		Walk(v, n.Lower)
+		Walk(v, n.Upper)
+
 	case *BinaryExpr:
 		Walk(v, n.LHS)
 		Walk(v, n.RHS)
@@ -1083,9 +2504,25 @@ func Walk(v Visitor, node Node) {
 			Walk(v, expr)
 		}
 
+	case *CaseExpr:
+		for _, w := range n.WhenClauses {
+			Walk(v, w.Condition)
+			Walk(v, w.Result)
+		}
+		Walk(v, n.Else)
+
 	case *Dimension:
 		Walk(v, n.Expr)
 
+	case *IsNullExpr:
+		Walk(v, n.Expr)
+
+	case *NotExpr:
+		Walk(v, n.Expr)
+
+	case *OrderByExpr:
+		Walk(v, n.SortFields)
+
 	case Dimensions:
 		for _, c := range n {
 			Walk(v, c)
@@ -1093,6 +2530,7 @@ func Walk(v Visitor, node Node) {
 
 	case *Field:
 		Walk(v, n.Expr)
+		Walk(v, n.Filter)
 
 	case Fields:
 		for _, c := range n {
@@ -1105,8 +2543,18 @@ func Walk(v Visitor, node Node) {
 	case *SelectStatement:
 		Walk(v, n.Fields)
 		Walk(v, n.Dimensions)
+		for _, key := range n.After {
+			Walk(v, key)
+		}
 		Walk(v, n.Sources)
 		Walk(v, n.Condition)
+		Walk(v, n.PostFilter)
+		if n.Collapse != nil {
+			Walk(v, n.Collapse)
+		}
+		if n.Highlight != nil {
+			Walk(v, n.Highlight)
+		}
 		Walk(v, n.SortFields)
 
 	case SortFields:
@@ -1114,6 +2562,13 @@ func Walk(v Visitor, node Node) {
 			Walk(v, sf)
 		}
 
+	case *SortField:
+		if n.Call != nil {
+			Walk(v, n.Call)
+		} else if n.Random != nil {
+			Walk(v, n.Random)
+		}
+
 	case Sources:
 		for _, s := range n {
 			Walk(v, s)
@@ -1192,11 +2647,24 @@ func (a Measurements) String() string {
 // Measurement represents a single measurement used as a datasource.
 type Measurement struct {
 	Database string
+	Alias    string
 }
 
 // String returns a string representation of the measurement.
 func (m *Measurement) String() string {
-	return m.Database
+	if m.Alias != "" {
+		return fmt.Sprintf("%s AS %s", quoteSourceIdent(m.Database), quoteSourceIdent(m.Alias))
+	}
+	return quoteSourceIdent(m.Database)
+}
+
+// quoteSourceIdent quotes a source name or alias with backticks when it is
+// neither a bare identifier nor an index pattern.
+func quoteSourceIdent(ident string) string {
+	if !IdentNeedsQuotes(ident) || isSourcePattern(ident) {
+		return ident
+	}
+	return "`" + strings.Replace(ident, "`", "``", -1) + "`"
 }
 
 //ESString ...
@@ -1207,6 +2675,7 @@ func (m *Measurement) ESString() string {
 // Wildcard represents a wild card expression.
 type Wildcard struct {
 	Type Token
+	position
 }
 
 // String returns a string representation of the wildcard.
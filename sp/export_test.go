@@ -0,0 +1,11 @@
+package sp
+
+// ClearPos zeroes the positions recorded by the parser in the node tree,
+// so that parsed trees can be compared with reflect.DeepEqual.
+func ClearPos(node Node) {
+	clear := func(n Node) { setPos(n, Pos{}) }
+	WalkFunc(node, clear)
+	if stmt, ok := node.(*SelectStatement); ok {
+		WalkFunc(stmt.Having, clear)
+	}
+}
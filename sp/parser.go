@@ -4,38 +4,145 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Parser represents an InfluxQL parser.
+//
+// A parser holds the state of the query it reads and is not safe for
+// concurrent use, each goroutine needs its own parser. Parsers can be
+// reused one query after another with Reset or a ParserPool.
 type Parser struct {
 	s *bufScanner
+
+	// MaxDepth is how deeply expressions may nest, e.g. in parentheses,
+	// before parsing fails. Zero or less disables the limit.
+	MaxDepth int
+	depth    int
 }
 
+// DefaultMaxDepth is the MaxDepth of parsers returned by NewParser.
+const DefaultMaxDepth = 100
+
 // NewParser returns a new instance of Parser.
 func NewParser(r io.Reader) *Parser {
-	return &Parser{s: newBufScanner(r)}
+	return &Parser{s: newBufScanner(r), MaxDepth: DefaultMaxDepth}
+}
+
+// Reset discards the state of the parser, including its scanner and
+// lookahead buffer, and makes it read from r. MaxDepth is kept.
+func (p *Parser) Reset(r io.Reader) {
+	p.s.s.Reset(r)
+	*p.s = bufScanner{s: p.s.s}
+	p.depth = 0
+}
+
+// ParserPool recycles parsers across queries. It is safe for concurrent
+// use, but a parser returned by Get belongs to the calling goroutine until
+// it is handed back with Put.
+type ParserPool struct {
+	pool sync.Pool
+}
+
+// Get returns a parser reading from r with the default MaxDepth.
+func (pp *ParserPool) Get(r io.Reader) *Parser {
+	p, ok := pp.pool.Get().(*Parser)
+	if !ok {
+		return NewParser(r)
+	}
+	p.Reset(r)
+	p.MaxDepth = DefaultMaxDepth
+	return p
+}
+
+// Put returns a parser to the pool, it must not be used afterwards. The
+// statements it parsed stay valid.
+func (pp *ParserPool) Put(p *Parser) {
+	p.Reset(nil)
+	pp.pool.Put(p)
 }
 
 // ParseStatement parses a statement string and returns its AST representation.
 func ParseStatement(s string) (Statement, error) {
+	stmt, err := NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// ParseSelectStatement parses a SELECT statement string and returns its AST representation.
+func ParseSelectStatement(s string) (*SelectStatement, error) {
 	return NewParser(strings.NewReader(s)).ParseStatement()
 }
 
-// ParseStatement parses an InfluxQL string and returns a Statement AST object.
-func (p *Parser) ParseStatement() (Statement, error) {
+// ParseUnion parses the SELECT statements of a query combined with UNION and
+// returns their AST representations, a query without UNION returns one statement.
+func ParseUnion(s string) ([]*SelectStatement, error) {
+	return NewParser(strings.NewReader(s)).ParseUnion()
+}
+
+// ParseExpr parses an expression string and returns its AST representation.
+func ParseExpr(s string) (Expr, error) {
+	p := NewParser(strings.NewReader(s))
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	// The whole string must be a single expression.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
+	}
+	return expr, nil
+}
+
+// ParseStatement parses a SELECT statement and returns its AST object.
+// Syntax errors are returned as a *ParseError holding the found token and its position.
+func (p *Parser) ParseStatement() (*SelectStatement, error) {
 	// Inspect the first token.
 	tok, pos, lit := p.scanIgnoreWhitespace()
 	switch tok {
 	case SELECT:
-		return p.parseSelectStatement()
+		stmt, err := p.parseSelectStatement()
+		if err != nil {
+			return nil, err
+		}
+		if tok, pos, _ := p.scanIgnoreWhitespace(); tok == UNION {
+			return nil, &ParseError{Message: "UNION is only supported by a multi-search", Pos: pos}
+		}
+		return stmt, nil
 	default:
 		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
 	}
 }
 
+// ParseUnion parses SELECT statements separated by UNION and returns their
+// AST objects in query order.
+func (p *Parser) ParseUnion() ([]*SelectStatement, error) {
+	var stmts []*SelectStatement
+	for {
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SELECT {
+			return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+		}
+		stmt, err := p.parseSelectStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+
+		// The statement ends either the query or a branch of the UNION.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != UNION {
+			return stmts, nil
+		}
+	}
+}
+
 // parseInt parses a string and returns an integer literal.
 func (p *Parser) parseInt(min, max int) (int, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
@@ -44,7 +151,8 @@ func (p *Parser) parseInt(min, max int) (int, error) {
 	}
 
 	// Convert string to int.
-	n, err := strconv.Atoi(lit)
+	v, err := parseIntegerLit(lit)
+	n := int(v)
 	if err != nil {
 		return 0, &ParseError{Message: err.Error(), Pos: pos}
 	} else if min > n || n > max {
@@ -57,6 +165,77 @@ func (p *Parser) parseInt(min, max int) (int, error) {
 	return n, nil
 }
 
+// parseIntegerLit converts the literal of an INTEGER token, optionally signed,
+// into an int64. Literals prefixed by "0x" are read as hexadecimal.
+func parseIntegerLit(lit string) (int64, error) {
+	if s := strings.TrimPrefix(lit, "-"); strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseInt(lit, 0, 64)
+	}
+	return strconv.ParseInt(lit, 10, 64)
+}
+
+// durationUnits maps the unit suffixes of a duration literal to their length.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// durationPartRegexp matches the leading number and unit pair of a duration.
+var durationPartRegexp = regexp.MustCompile(`^(\d+)(ns|us|ms|s|m|h|d|w)`)
+
+// ParseDuration parses a time duration from a string.
+// This is needed instead of time.ParseDuration because it supports days and
+// weeks, compound durations such as "1h30m" are summed up.
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, ErrInvalidDuration
+	}
+
+	var d time.Duration
+	for s != "" {
+		m := durationPartRegexp.FindStringSubmatch(s)
+		if m == nil {
+			return 0, ErrInvalidDuration
+		}
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, ErrInvalidDuration
+		}
+		d += time.Duration(n) * durationUnits[m[2]]
+		s = s[len(m[0]):]
+	}
+	return d, nil
+}
+
+// FormatDuration formats a duration to a string using the largest unit
+// that divides it evenly.
+func FormatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	} else if d%(7*24*time.Hour) == 0 {
+		return fmt.Sprintf("%dw", d/(7*24*time.Hour))
+	} else if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	} else if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	} else if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	} else if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	} else if d%time.Millisecond == 0 {
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	} else if d%time.Microsecond == 0 {
+		return fmt.Sprintf("%dus", d/time.Microsecond)
+	}
+	return fmt.Sprintf("%dns", d)
+}
+
 // parseUInt32 parses a string and returns a 32-bit unsigned integer literal.
 func (p *Parser) parseUInt32() (uint32, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
@@ -124,6 +303,7 @@ func (p *Parser) parseIdentList() ([]string, error) {
 
 // parseSegmentedIdents parses a segmented identifiers.
 // e.g.,  tcp.in_bytes
+// Segments after the first one can be quoted, e.g. user."first name".
 func (p *Parser) parseSegmentedIdents() ([]string, error) {
 	ident, err := p.parseIdent()
 	if err != nil {
@@ -138,7 +318,12 @@ func (p *Parser) parseSegmentedIdents() ([]string, error) {
 			p.unscan()
 			break
 		}
-		// Parse the next identifier.
+		// Parse the next identifier, quoted or not.
+		if tok, _, lit := p.scan(); tok == STRING {
+			idents = append(idents, lit)
+			continue
+		}
+		p.unscan()
 		if ident, err = p.parseIdent(); err != nil {
 			return nil, err
 		}
@@ -188,6 +373,13 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 	stmt := &SelectStatement{}
 	var err error
 
+	// Parse the optional "DISTINCT" modifier.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == DISTINCT {
+		stmt.Dedupe = true
+	} else {
+		p.unscan()
+	}
+
 	// Parse fields: "FIELD+".
 	if stmt.Fields, err = p.parseFields(); err != nil {
 		return nil, err
@@ -203,8 +395,28 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		return nil, err
 	}
 
-	// Parse dimensions: "GROUP BY DIMENSION+".
-	if stmt.Dimensions, err = p.parseDimensions(); err != nil {
+	// Parse post filter: "POST_FILTER(WHERE EXPR)".
+	if stmt.PostFilter, err = p.parsePostFilter(); err != nil {
+		return nil, err
+	}
+
+	// Parse collapse: "COLLAPSE(FIELD[, SIZE])".
+	if stmt.Collapse, err = p.parseCollapse(); err != nil {
+		return nil, err
+	}
+
+	// Parse highlight: "HIGHLIGHT(FIELD+[, SIZE[, NUMBER]])".
+	if stmt.Highlight, err = p.parseHighlight(); err != nil {
+		return nil, err
+	}
+
+	// Parse dimensions: "GROUP BY [COMPOSITE] DIMENSION+".
+	if stmt.Dimensions, stmt.Composite, err = p.parseDimensions(); err != nil {
+		return nil, err
+	}
+
+	// Parse after: "AFTER(KEY+)".
+	if stmt.After, err = p.parseAfter(); err != nil {
 		return nil, err
 	}
 
@@ -219,14 +431,20 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 	}
 
 	// Parse limit: "LIMIT <m>,<n>".
-	if stmt.Limit, stmt.Offset, err = p.parseLimit(); err != nil {
+	if stmt.Limit, stmt.Offset, stmt.HasLimit, err = p.parseLimit(); err != nil {
 		return nil, err
 	}
 
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EOF {
+	// A UNION starts the next statement, it is read by the caller.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok == UNION {
+		p.unscan()
+	} else if tok != EOF {
 		return nil, newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
 	}
 
+	// Hint comments are read by the scanner wherever they appear.
+	stmt.Hints = p.s.s.Hints
+
 	// Set if the query is a raw data query or one with an aggregate
 	stmt.IsRawQuery = true
 	WalkFunc(stmt.Fields, func(n Node) {
@@ -235,6 +453,9 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		}
 	})
 
+	// Fields qualified by a source alias refer to the plain field name.
+	stmt.rewriteSourceAliases()
+
 	if err := stmt.validate(); err != nil {
 		return nil, err
 	}
@@ -276,7 +497,19 @@ func (p *Parser) parseField() (*Field, error) {
 		return nil, err
 	}
 
-	f.Expr = expr
+	if f.Expr, err = lowerConcat(expr); err != nil {
+		return nil, err
+	}
+	f.setPos(expr.Pos())
+
+	// Parse the aggregate filter if the next token is "FILTER".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FILTER {
+		if f.Filter, err = p.parseFilter(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
 
 	// Parse the alias if the current and next tokens are "WS AS".
 	alias, err := p.parseAlias()
@@ -291,23 +524,194 @@ func (p *Parser) parseField() (*Field, error) {
 	return f, nil
 }
 
+// lowerConcat replaces the concat() calls of a SELECT field by the chain of
+// || operators they stand for, which is compiled to a script field.
+func lowerConcat(expr Expr) (Expr, error) {
+	var err error
+	switch expr := expr.(type) {
+	case *ParenExpr:
+		if expr.Expr, err = lowerConcat(expr.Expr); err != nil {
+			return nil, err
+		}
+	case *BinaryExpr:
+		if expr.LHS, err = lowerConcat(expr.LHS); err != nil {
+			return nil, err
+		}
+		if expr.RHS, err = lowerConcat(expr.RHS); err != nil {
+			return nil, err
+		}
+	case *Call:
+		// The arguments of aggregates are not scripts.
+		if expr.Name != "concat" {
+			return expr, nil
+		}
+		for i, arg := range expr.Args {
+			if expr.Args[i], err = lowerConcat(arg); err != nil {
+				return nil, err
+			}
+		}
+		if len(expr.Args) < 2 {
+			msg := fmt.Sprintf("invalid number of arguments for concat, expected at least 2, got %d", len(expr.Args))
+			return nil, &ParseError{Message: msg, Pos: expr.Pos()}
+		}
+		// Operands binding looser than || keep their grouping.
+		operand := func(arg Expr) Expr {
+			if b, ok := arg.(*BinaryExpr); ok && b.Op.Precedence() <= CONCAT.Precedence() {
+				paren := &ParenExpr{Expr: b}
+				paren.setPos(b.Pos())
+				return paren
+			}
+			return arg
+		}
+		chain := operand(expr.Args[0])
+		for _, arg := range expr.Args[1:] {
+			b := &BinaryExpr{Op: CONCAT, LHS: chain, RHS: operand(arg)}
+			b.setPos(expr.Pos())
+			chain = b
+		}
+		return chain, nil
+	}
+	return expr, nil
+}
+
+// parseCaseExpr parses a "CASE WHEN cond THEN expr ... [ELSE expr] END" expression.
+// This function assumes the CASE token has already been consumed.
+func (p *Parser) parseCaseExpr() (*CaseExpr, error) {
+	expr := &CaseExpr{}
+	for {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok != WHEN {
+			if len(expr.WhenClauses) == 0 {
+				return nil, newParseError(tokstr(tok, lit), []string{"WHEN"}, pos)
+			}
+			p.unscan()
+			break
+		}
+		cond, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != THEN {
+			return nil, newParseError(tokstr(tok, lit), []string{"THEN"}, pos)
+		}
+		result, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.WhenClauses = append(expr.WhenClauses, &WhenClause{Condition: cond, Result: result})
+	}
+
+	// Parse the optional ELSE result.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == ELSE {
+		result, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.Else = result
+	} else {
+		p.unscan()
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != END {
+		return nil, newParseError(tokstr(tok, lit), []string{"END"}, pos)
+	}
+	return expr, nil
+}
+
+// parseOrderByExpr parses the sort fields of an ORDER BY function argument.
+// This function assumes the ORDER token has already been consumed.
+func (p *Parser) parseOrderByExpr(pos Pos) (*OrderByExpr, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != BY {
+		return nil, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+	}
+	fields, err := p.parseSortFields()
+	if err != nil {
+		return nil, err
+	}
+	expr := &OrderByExpr{SortFields: fields}
+	expr.setPos(pos)
+	return expr, nil
+}
+
+// parseTermsOrder parses the bucket order argument of terms(), with or without
+// ORDER BY. It returns true if the order is followed by a missing() label.
+func (p *Parser) parseTermsOrder() (*OrderByExpr, bool, error) {
+	tok, pos, _ := p.scanIgnoreWhitespace()
+	if tok == ORDER {
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != BY {
+			return nil, false, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+		}
+	} else {
+		p.unscan()
+	}
+
+	expr := &OrderByExpr{}
+	expr.setPos(pos)
+	for {
+		field, err := p.parseSortField()
+		if err != nil {
+			return nil, false, err
+		}
+		expr.SortFields = append(expr.SortFields, field)
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return expr, false, nil
+		}
+		if p.peekMissing() {
+			return expr, true, nil
+		}
+	}
+}
+
+// peekMissing returns true if the next token starts a missing() label.
+func (p *Parser) peekMissing() bool {
+	tok, _, lit := p.scanIgnoreWhitespace()
+	p.unscan()
+	return tok == IDENT && strings.ToLower(lit) == "missing"
+}
+
+// parseFilter parses the "(WHERE expr)" condition following FILTER.
+func (p *Parser) parseFilter() (Expr, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != WHERE {
+		return nil, newParseError(tokstr(tok, lit), []string{"WHERE"}, pos)
+	}
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return expr, nil
+}
+
 // validateField checks if the Expr is a valid field. We disallow all binary expression
 // that return a boolean
 type validateField struct {
 	foundInvalid bool
 	badToken     Token
+	badPos       Pos
 }
 
 func (c *validateField) Visit(n Node) Visitor {
+	// CASE conditions are compared, they are checked when compiled to a script.
+	if _, ok := n.(*CaseExpr); ok {
+		return nil
+	}
 	e, ok := n.(*BinaryExpr)
 	if !ok {
 		return c
 	}
 
 	switch e.Op {
-	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE, AND, OR, IN, NI:
+	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE, AND, OR, IN, NI, LIKE, NLIKE, IS:
 		c.foundInvalid = true
 		c.badToken = e.Op
+		c.badPos = e.Pos()
 		return nil
 	}
 	return c
@@ -330,20 +734,50 @@ func (p *Parser) parseAlias() (string, error) {
 }
 
 // parseSources parses a comma delimited list of sources.
+//
+// Every query is sent to an index, so constant-only selects such as
+// SELECT 1 are not supported and fail with a missing FROM clause.
 func (p *Parser) parseSources() (Sources, error) {
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok == EOF || (tok > keywordBeg && tok < keywordEnd && tok != FROM) {
+		return nil, &ParseError{Message: "missing FROM clause", Pos: pos}
+	} else if tok != FROM {
 		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
 	}
 	var sources Sources
+	aliases := make(map[string]struct{})
+
+	// Index patterns such as logs-2024.* are only valid as source names, the
+	// scanner goes back to plain identifiers once the source list is read.
+	p.s.s.SourcePatterns = true
+	defer func() { p.s.s.SourcePatterns = false }()
+
+	// FROM must name at least one index, it can't be directly followed by
+	// the end of the query or the next clause.
+	if tok, pos, _ := p.scanIgnoreWhitespace(); tok == EOF || (tok > keywordBeg && tok < keywordEnd) {
+		return nil, &ParseError{Message: "invalid FROM, expected at least one source", Pos: pos}
+	}
+	p.unscan()
 
 	for {
+		_, pos, _ := p.scanIgnoreWhitespace()
+		p.unscan()
+
 		s, err := p.parseSource()
 		if err != nil {
 			return nil, err
 		}
+		if m, ok := s.(*Measurement); ok && m.Alias != "" {
+			if _, ok := aliases[m.Alias]; ok {
+				return nil, &ParseError{Message: fmt.Sprintf("duplicate source alias %s", m.Alias), Pos: pos}
+			}
+			aliases[m.Alias] = struct{}{}
+		}
 		sources = append(sources, s)
 
-		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok == JOIN || (tok == IDENT && joinTypes[strings.ToLower(lit)]) {
+			return nil, &ParseError{Message: "JOIN is not supported by Elasticsearch; denormalize your data", Pos: pos}
+		} else if tok != COMMA {
 			p.unscan()
 			break
 		}
@@ -352,6 +786,18 @@ func (p *Parser) parseSources() (Sources, error) {
 	return sources, nil
 }
 
+// joinTypes are the words starting a join such as "LEFT OUTER JOIN". They
+// can't follow a source otherwise, so the join is reported from them.
+var joinTypes = map[string]bool{
+	"inner":   true,
+	"left":    true,
+	"right":   true,
+	"full":    true,
+	"outer":   true,
+	"cross":   true,
+	"natural": true,
+}
+
 // peekRune returns the next rune that would be read by the scanner.
 func (p *Parser) peekRune() rune {
 	r, _, _ := p.s.s.r.ReadRune()
@@ -371,6 +817,15 @@ func (p *Parser) parseSource() (Source, error) {
 		return nil, err
 	}
 	m.Database = ident
+
+	// Parse the alias if the next token is "AS".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != AS {
+		p.unscan()
+		return m, nil
+	}
+	if m.Alias, err = p.parseIdent(); err != nil {
+		return nil, err
+	}
 	return m, nil
 }
 
@@ -391,17 +846,156 @@ func (p *Parser) parseCondition() (Expr, error) {
 	return expr, nil
 }
 
+// parsePostFilter parses the "POST_FILTER" clause of a query, if it exists.
+func (p *Parser) parsePostFilter() (Expr, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != POSTFILTER {
+		p.unscan()
+		return nil, nil
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != WHERE {
+		return nil, newParseError(tokstr(tok, lit), []string{"WHERE"}, pos)
+	}
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return expr, nil
+}
+
+// maxInnerHits is the default index.max_inner_result_window of es, the most
+// collapsed hits returned with each hit.
+const maxInnerHits = 100
+
+// parseCollapse parses the "COLLAPSE" clause of a query, if it exists.
+func (p *Parser) parseCollapse() (*Collapse, error) {
+	tok, pos, _ := p.scanIgnoreWhitespace()
+	if tok != COLLAPSE {
+		p.unscan()
+		return nil, nil
+	}
+	c := &Collapse{}
+	c.setPos(pos)
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	// The hits are collapsed on a single field, not on an expression.
+	_, pos, _ = p.scanIgnoreWhitespace()
+	p.unscan()
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	ref, ok := expr.(*VarRef)
+	if !ok {
+		msg := fmt.Sprintf("invalid COLLAPSE(%s), expected a field name", expr.String())
+		return nil, &ParseError{Message: msg, Pos: pos}
+	}
+	c.Field = ref.Val
+
+	// Parse the optional number of inner hits.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == COMMA {
+		if c.Size, err = p.parseInt(1, maxInnerHits); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return c, nil
+}
+
+// parseHighlight parses the "HIGHLIGHT" clause of a query, if it exists.
+func (p *Parser) parseHighlight() (*Highlight, error) {
+	tok, pos, _ := p.scanIgnoreWhitespace()
+	if tok != HIGHLIGHT {
+		p.unscan()
+		return nil, nil
+	}
+	h := &Highlight{}
+	h.setPos(pos)
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	if tok, pos, _ := p.scanIgnoreWhitespace(); tok == RPAREN {
+		return nil, &ParseError{Message: "invalid HIGHLIGHT, expected at least one field", Pos: pos}
+	}
+	p.unscan()
+
+	// Parse the fields, followed by the optional fragment size and number
+	// of fragments.
+	for {
+		_, pos, _ := p.scanIgnoreWhitespace()
+		p.unscan()
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		ref, ok := expr.(*VarRef)
+		if !ok {
+			msg := fmt.Sprintf("invalid HIGHLIGHT(%s), expected a field name", expr.String())
+			return nil, &ParseError{Message: msg, Pos: pos}
+		}
+		h.Fields = append(h.Fields, ref.Val)
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok == INTEGER {
+			p.unscan()
+			if h.FragmentSize, err = p.parseInt(1, math.MaxInt32); err != nil {
+				return nil, err
+			}
+			if tok, _, _ := p.scanIgnoreWhitespace(); tok == COMMA {
+				if h.Fragments, err = p.parseInt(1, math.MaxInt32); err != nil {
+					return nil, err
+				}
+			} else {
+				p.unscan()
+			}
+			break
+		}
+		p.unscan()
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return h, nil
+}
+
 // parseDimensions parses the "GROUP BY" clause of the query, if it exists.
-func (p *Parser) parseDimensions() (Dimensions, error) {
+// It also returns whether the dimensions are grouped in COMPOSITE mode.
+func (p *Parser) parseDimensions() (Dimensions, bool, error) {
 	// If the next token is not GROUP then exit.
 	if tok, _, _ := p.scanIgnoreWhitespace(); tok != GROUP {
 		p.unscan()
-		return nil, nil
+		return nil, false, nil
 	}
 
 	// Now the next token should be "BY".
 	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != BY {
-		return nil, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+		return nil, false, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+	}
+
+	// Check for the optional COMPOSITE mode.
+	tok, _, _ := p.scanIgnoreWhitespace()
+	composite := tok == COMPOSITE
+	if !composite {
+		p.unscan()
 	}
 
 	var dimensions Dimensions
@@ -409,7 +1003,7 @@ func (p *Parser) parseDimensions() (Dimensions, error) {
 		// Parse the dimension.
 		d, err := p.parseDimension()
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
 		// Add new dimension.
@@ -421,7 +1015,46 @@ func (p *Parser) parseDimensions() (Dimensions, error) {
 			break
 		}
 	}
-	return dimensions, nil
+	return dimensions, composite, nil
+}
+
+// parseAfter parses the "AFTER" clause of a query, if it exists.
+func (p *Parser) parseAfter() ([]Expr, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != AFTER {
+		p.unscan()
+		return nil, nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	// Each key is the literal value of a dimension.
+	var keys []Expr
+	for {
+		_, pos, _ := p.scanIgnoreWhitespace()
+		p.unscan()
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		switch expr.(type) {
+		case *StringLiteral, *IntegerLiteral, *NumberLiteral, *BooleanLiteral:
+		default:
+			msg := fmt.Sprintf("invalid AFTER key %s, expected a literal", expr.String())
+			return nil, &ParseError{Message: msg, Pos: pos}
+		}
+		keys = append(keys, expr)
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return keys, nil
 }
 
 // parseDimension parses a single dimension.
@@ -430,7 +1063,9 @@ func (p *Parser) parseDimension() (*Dimension, error) {
 	if err != nil {
 		return nil, err
 	} else if re != nil {
-		return &Dimension{Expr: re}, nil
+		d := &Dimension{Expr: re}
+		d.setPos(re.Pos())
+		return d, nil
 	}
 
 	// Parse the expression first.
@@ -448,7 +1083,9 @@ func (p *Parser) parseDimension() (*Dimension, error) {
 	// Consume all trailing whitespace.
 	p.consumeWhitespace()
 
-	return &Dimension{Expr: expr, Alias: alias}, nil
+	d := &Dimension{Expr: expr, Alias: alias}
+	d.setPos(expr.Pos())
+	return d, nil
 }
 
 // parseHaving parses the "HAVING" clause of the query, if it exists.
@@ -468,48 +1105,66 @@ func (p *Parser) parseHaving() (Expr, error) {
 	return expr, nil
 }
 
-// parseLimit parses the specified token followed
-// by an int, if it exists.
-func (p *Parser) parseLimit() (int, int, error) {
+// parseLimit parses the "LIMIT" clause of a query and reports whether it exists.
+// Both "LIMIT count OFFSET offset" and "LIMIT offset, count" are accepted.
+func (p *Parser) parseLimit() (int, int, bool, error) {
 	// Check if the token exists.
 	if tok, _, _ := p.scanIgnoreWhitespace(); tok != LIMIT {
 		p.unscan()
-		return 0, 0, nil
+		return 0, 0, false, nil
 	}
 
-	// Scan the number.
-	tok, pos, lit := p.scanIgnoreWhitespace()
-	if tok != INTEGER {
-		return 0, 0, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+	n, err := p.parseLimitInt(LIMIT)
+	if err != nil {
+		return 0, 0, false, err
 	}
 
-	// Parse number.
-	n, _ := strconv.ParseInt(lit, 10, 64)
-	if n < 0 {
-		msg := fmt.Sprintf("%s must be >= 0", LIMIT.String())
-		return 0, 0, &ParseError{Message: msg, Pos: pos}
+	switch tok, _, _ := p.scanIgnoreWhitespace(); tok {
+	case COMMA:
+		// The first number is the offset of the MySQL form.
+		m, err := p.parseLimitInt(LIMIT)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return m, n, true, nil
+	case OFFSET:
+		m, err := p.parseLimitInt(OFFSET)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return n, m, true, nil
 	}
+	p.unscan()
 
-	// Parse offset
-	if _tok, _, _ := p.scanIgnoreWhitespace(); _tok != COMMA {
-		p.unscan()
-		return int(n), 0, nil
-	}
+	return n, 0, true, nil
+}
 
-	// Scan the number.
-	tok, pos, lit = p.scanIgnoreWhitespace()
+// parseLimitInt parses the non-negative integer of a LIMIT or OFFSET clause.
+func (p *Parser) parseLimitInt(clause Token) (int, error) {
+	// Scan the number, a negative one is reported at its sign.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	neg := tok == SUB
+	if neg {
+		tok, _, lit = p.scan()
+	}
 	if tok != INTEGER {
-		return 0, 0, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+		return 0, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
 	}
 
 	// Parse number.
-	m, _ := strconv.ParseInt(lit, 10, 64)
-	if m < 0 {
-		msg := fmt.Sprintf("offset must be >= 0")
-		return 0, 0, &ParseError{Message: msg, Pos: pos}
+	n, err := parseIntegerLit(lit)
+	if err != nil {
+		return 0, &ParseError{Message: "unable to parse integer", Pos: pos}
+	}
+	if neg {
+		n = -n
+	}
+	if n < 0 {
+		msg := fmt.Sprintf("%s must be >= 0", clause.String())
+		return 0, &ParseError{Message: msg, Pos: pos}
 	}
 
-	return int(n), int(m), nil
+	return int(n), nil
 }
 
 // parseOrderBy parses the "ORDER BY" clause of a query, if it exists.
@@ -544,6 +1199,10 @@ func (p *Parser) parseSortFields() (SortFields, error) {
 	// The first field after an order by may not have a field name (e.g. ORDER BY ASC)
 	case ASC, DESC:
 		fields = append(fields, &SortField{Ascending: (tok == ASC)})
+		if tok, pos, _ := p.scanIgnoreWhitespace(); tok == NULLS {
+			return nil, &ParseError{Message: "NULLS must follow a sort field", Pos: pos}
+		}
+		p.unscan()
 	// If it's a token, parse it as a sort field.  At least one is required.
 	case IDENT:
 		p.unscan()
@@ -581,14 +1240,43 @@ func (p *Parser) parseSortFields() (SortFields, error) {
 // parseSortField parses one field of an ORDER BY clause.
 func (p *Parser) parseSortField() (*SortField, error) {
 	field := &SortField{}
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	field.setPos(pos)
 
-	// Parse sort field name.
-	ident, err := p.parseIdent()
+	// Parse sort field name, a dotted path is sorted by its full name.
+	segments, err := p.parseSegmentedIdents()
 	if err != nil {
 		return nil, err
 	}
+	ident := strings.Join(segments, ".")
 	field.Name = ident
 
+	// A left parentheses sorts by an aggregate call, named by its string form.
+	if tok, _, _ := p.scan(); tok == LPAREN {
+		call, err := p.parseCall(ident)
+		if err != nil {
+			return nil, err
+		}
+		call.setPos(pos)
+		field.Name = call.String()
+		field.Call = call
+
+		// RANDOM() shuffles the hits, an integer seed makes the order reproducible.
+		if call.Name == "random" {
+			if len(call.Args) > 1 {
+				return nil, &ParseError{Message: fmt.Sprintf("invalid number of arguments for random, expected 0 or 1, got %d", len(call.Args)), Pos: pos}
+			} else if len(call.Args) == 1 {
+				if _, ok := call.Args[0].(*IntegerLiteral); !ok {
+					return nil, &ParseError{Message: fmt.Sprintf("invalid %s, expected an integer seed", call.String()), Pos: pos}
+				}
+			}
+			field.Random = call
+		}
+	} else {
+		p.unscan()
+	}
+
 	// Check for optional ASC or DESC clause. Default is ASC.
 	tok, _, _ := p.scanIgnoreWhitespace()
 	if tok != ASC && tok != DESC {
@@ -597,6 +1285,17 @@ func (p *Parser) parseSortField() (*SortField, error) {
 	}
 	field.Ascending = (tok == ASC)
 
+	// Check for optional NULLS FIRST or NULLS LAST clause.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != NULLS {
+		p.unscan()
+		return field, nil
+	}
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != FIRST && tok != LAST {
+		return nil, newParseError(tokstr(tok, lit), []string{"FIRST", "LAST"}, pos)
+	}
+	field.Nulls = tok
+
 	return field, nil
 }
 
@@ -611,13 +1310,28 @@ func (p *Parser) parseVarRef() (*VarRef, error) {
 	return vr, nil
 }
 
+// parseList parses a list of literals enclosed in parentheses or brackets.
 func (p *Parser) parseList() (*ListLiteral, error) {
 	list := &ListLiteral{}
 
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LBRACKET {
+	var end Token
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case LPAREN:
+		end = RPAREN
+	case LBRACKET:
+		end = RBRACKET
+	default:
 		p.unscan()
-		return nil, newParseError(tokstr(tok, lit), []string{"["}, pos)
+		return nil, newParseError(tokstr(tok, lit), []string{"(", "["}, pos)
 	}
+	list.setPos(pos)
+
+	// An empty list is left for the translator to reject.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == end {
+		return list, nil
+	}
+	p.unscan()
 
 	for {
 		// Read next token.
@@ -632,7 +1346,7 @@ func (p *Parser) parseList() (*ListLiteral, error) {
 			}
 			list.Vals = append(list.Vals, v)
 		case INTEGER:
-			v, err := strconv.ParseInt(lit, 10, 64)
+			v, err := parseIntegerLit(lit)
 			if err != nil {
 				return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
 			}
@@ -648,15 +1362,21 @@ func (p *Parser) parseList() (*ListLiteral, error) {
 		}
 	}
 
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RBRACKET {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != end {
 		p.unscan()
-		return nil, newParseError(tokstr(tok, lit), []string{"]"}, pos)
+		return nil, newParseError(tokstr(tok, lit), []string{end.String()}, pos)
 	}
 	return list, nil
 }
 
 // ParseExpr parses an expression.
 func (p *Parser) ParseExpr() (Expr, error) {
+	return p.parseExpr(0)
+}
+
+// parseExpr parses an expression made of the operators binding tighter than
+// the precedence prec, the next operator is left unscanned.
+func (p *Parser) parseExpr(prec int) (Expr, error) {
 	var err error
 	// Dummy root node.
 	root := &BinaryExpr{}
@@ -672,7 +1392,21 @@ func (p *Parser) ParseExpr() (Expr, error) {
 	for {
 		// If the next token is NOT an operator then return the expression.
 		op, _, _ := p.scanIgnoreWhitespace()
-		if !op.isOperator() {
+		not := false
+		if op == NOT {
+			// NOT only negates the operator that follows it, e.g. "NOT LIKE".
+			switch tok, pos, lit := p.scanIgnoreWhitespace(); tok {
+			case LIKE:
+				op = NLIKE
+			case IN:
+				op = NI
+			case BETWEEN:
+				op, not = BETWEEN, true
+			default:
+				return nil, newParseError(tokstr(tok, lit), []string{"LIKE", "IN", "BETWEEN"}, pos)
+			}
+		}
+		if !op.IsOperator() || op.Precedence() <= prec {
 			p.unscan()
 			return root.RHS, nil
 		}
@@ -695,6 +1429,15 @@ func (p *Parser) ParseExpr() (Expr, error) {
 			if rhs, err = p.parseList(); err != nil {
 				return nil, err
 			}
+		} else if op == BETWEEN {
+			// The bounds are parsed here, the tested expression is filled in below.
+			if rhs, err = p.parseBetween(not); err != nil {
+				return nil, err
+			}
+		} else if op == IS {
+			if rhs, err = p.parseIsNull(); err != nil {
+				return nil, err
+			}
 		} else {
 			if rhs, err = p.parseUnaryExpr(); err != nil {
 				return nil, err
@@ -704,12 +1447,28 @@ func (p *Parser) ParseExpr() (Expr, error) {
 		// Find the right spot in the tree to add the new expression by
 		// descending the RHS of the expression tree until we reach the last
 		// BinaryExpr or a BinaryExpr whose RHS has an operator with
-		// precedence >= the operator being added.
+		// precedence >= the operator being added. The list of an IN is
+		// complete once parsed so the descent never goes into it.
 		for node := root; ; {
 			r, ok := node.RHS.(*BinaryExpr)
-			if !ok || r.Op.Precedence() >= op.Precedence() {
+			if !ok || r.Op.Precedence() >= op.Precedence() || IsListOp(r.Op) {
 				// Add the new expression here and break.
-				node.RHS = &BinaryExpr{LHS: node.RHS, RHS: rhs, Op: op}
+				// The new node starts where its left operand does.
+				pos := node.RHS.Pos()
+				switch pred := rhs.(type) {
+				case *BetweenExpr:
+					pred.Expr = node.RHS
+					pred.setPos(pos)
+					node.RHS = pred
+				case *IsNullExpr:
+					pred.Expr = node.RHS
+					pred.setPos(pos)
+					node.RHS = pred
+				default:
+					expr := &BinaryExpr{LHS: node.RHS, RHS: rhs, Op: op}
+					expr.setPos(pos)
+					node.RHS = expr
+				}
 				break
 			}
 			node = r
@@ -717,8 +1476,65 @@ func (p *Parser) ParseExpr() (Expr, error) {
 	}
 }
 
-// parseUnaryExpr parses an non-binary expression.
+// parseBetween parses the "<lower> AND <upper>" bounds of a BETWEEN predicate.
+// This function assumes the BETWEEN token has already been consumed.
+func (p *Parser) parseBetween(not bool) (*BetweenExpr, error) {
+	lower, err := p.parseUnaryExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != AND {
+		return nil, newParseError(tokstr(tok, lit), []string{"AND"}, pos)
+	}
+
+	upper, err := p.parseUnaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &BetweenExpr{Lower: lower, Upper: upper, Not: not}, nil
+}
+
+// parseIsNull parses the "[NOT] NULL" tail of an IS predicate.
+// This function assumes the IS token has already been consumed.
+func (p *Parser) parseIsNull() (*IsNullExpr, error) {
+	expr := &IsNullExpr{}
+
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == NOT {
+		expr.Not = true
+		if tok, pos, lit = p.scanIgnoreWhitespace(); tok != NULL {
+			return nil, newParseError(tokstr(tok, lit), []string{"NULL"}, pos)
+		}
+	} else if tok != NULL {
+		return nil, newParseError(tokstr(tok, lit), []string{"NULL", "NOT NULL"}, pos)
+	}
+	return expr, nil
+}
+
+// parseUnaryExpr parses an non-binary expression and records where it starts.
 func (p *Parser) parseUnaryExpr() (Expr, error) {
+	_, pos, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+
+	// Every nested expression comes back through here, bound the recursion
+	// before a pathological query can exhaust the stack.
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.MaxDepth > 0 && p.depth > p.MaxDepth {
+		return nil, &ParseError{Message: fmt.Sprintf("invalid expression, exceeded max depth of %d", p.MaxDepth), Pos: pos}
+	}
+
+	expr, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	setPos(expr, pos)
+	return expr, nil
+}
+
+// parseOperand parses an non-binary expression.
+func (p *Parser) parseOperand() (Expr, error) {
 	// If the first token is a LPAREN then parse it as its own grouped expression.
 	if tok, _, _ := p.scanIgnoreWhitespace(); tok == LPAREN {
 		expr, err := p.ParseExpr()
@@ -733,14 +1549,49 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		return &ParenExpr{Expr: expr}, nil
 	}
 	p.unscan()
-	// negative number is treated as 0-x
+	// NOT negates the whole predicate that follows it, e.g. "NOT a = 1".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == NOT {
+		expr, err := p.parseExpr(AND.Precedence())
+		if err != nil {
+			return nil, err
+		}
+
+		return &NotExpr{Expr: expr}, nil
+	}
+	p.unscan()
+	// A minus sign in operand position is unary: a number directly after it is
+	// read as a negative literal, anything else is treated as (0-x) so that it
+	// keeps binding tighter than the operators that follow.
 	if tok, _, _ := p.scanIgnoreWhitespace(); tok == SUB {
-		expr, err := p.ParseExpr()
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		switch tok {
+		case NUMBER:
+			v, err := strconv.ParseFloat("-"+lit, 64)
+			if err != nil {
+				return nil, &ParseError{Message: "unable to parse number", Pos: pos}
+			}
+			return &NumberLiteral{Val: v}, nil
+		case INTEGER:
+			v, err := parseIntegerLit("-" + lit)
+			if err != nil {
+				return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
+			}
+			return &IntegerLiteral{Val: v}, nil
+		case DURATION:
+			v, err := ParseDuration(lit)
+			if err != nil {
+				return nil, &ParseError{Message: "unable to parse duration", Pos: pos}
+			}
+			return &DurationLiteral{Val: -v}, nil
+		}
+		p.unscan()
+
+		expr, err := p.parseUnaryExpr()
 		if err != nil {
 			return nil, err
 		}
 
-		return &BinaryExpr{LHS: &IntegerLiteral{Val: 0}, RHS: expr, Op: SUB}, nil
+		return &ParenExpr{Expr: &BinaryExpr{LHS: &IntegerLiteral{Val: 0}, RHS: expr, Op: SUB}}, nil
 	}
 	p.unscan()
 
@@ -759,6 +1610,8 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 
 		// Parse it as a VarRef.
 		return p.parseVarRef()
+	case CASE:
+		return p.parseCaseExpr()
 	case STRING:
 		return &StringLiteral{Val: lit}, nil
 	case NUMBER:
@@ -768,13 +1621,21 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		}
 		return &NumberLiteral{Val: v}, nil
 	case INTEGER:
-		v, err := strconv.ParseInt(lit, 10, 64)
+		v, err := parseIntegerLit(lit)
 		if err != nil {
 			return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
 		}
 		return &IntegerLiteral{Val: v}, nil
+	case DURATION:
+		v, err := ParseDuration(lit)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse duration", Pos: pos}
+		}
+		return &DurationLiteral{Val: v}, nil
 	case TRUE, FALSE:
 		return &BooleanLiteral{Val: (tok == TRUE)}, nil
+	case NULL:
+		return &NullLiteral{}, nil
 	case MUL:
 		wc := &Wildcard{}
 		return wc, nil
@@ -819,7 +1680,9 @@ func (p *Parser) parseRegex() (*RegexLiteral, error) {
 		return nil, &ParseError{Message: err.Error(), Pos: pos}
 	}
 
-	return &RegexLiteral{Val: re}, nil
+	rl := &RegexLiteral{Val: re}
+	rl.setPos(pos)
+	return rl, nil
 }
 
 // parseCall parses a function call.
@@ -828,58 +1691,208 @@ func (p *Parser) parseCall(name string) (*Call, error) {
 	name = strings.ToLower(name)
 
 	// Parse first function argument if one exists.
+	// The start position of every argument is kept for error reporting.
 	var args []Expr
+	var argPos []Pos
+	var quoted bool // the first argument is a double quoted string
 	re, err := p.parseRegex()
 	if err != nil {
 		return nil, err
 	} else if re != nil {
 		args = append(args, re)
+		argPos = append(argPos, Pos{})
 	} else {
 		// If there's a right paren then just return immediately.
-		if tok, _, _ := p.scan(); tok == RPAREN {
+		tok, pos, _ := p.scan()
+		if tok == RPAREN {
 			return &Call{Name: name}, nil
 		}
-		p.unscan()
 
-		arg, err := p.ParseExpr()
+		quoted = tok == STRING && p.s.quote() == '"'
+		var arg Expr
+		if tok == DISTINCT {
+			var d *Call
+			if d, err = p.parseDistinct(); err != nil {
+				return nil, err
+			}
+			arg = d
+			// The trailing integer of count(DISTINCT field, n) is its precision threshold.
+			if n := len(d.Args); name == "count" && n == 2 {
+				if threshold, ok := d.Args[1].(*IntegerLiteral); ok {
+					d.Args = d.Args[:1]
+					args = append(args, d)
+					argPos = append(argPos, pos)
+					arg = threshold
+				}
+			}
+		} else {
+			p.unscan()
+			arg, err = p.ParseExpr()
+		}
 		if err != nil {
 			return nil, err
 		}
 		args = append(args, arg)
+		argPos = append(argPos, pos)
 	}
 
 	// Parse additional function arguments if there is a comma.
 	for {
 		// If there's not a comma, stop parsing arguments.
-		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+		tok, pos, _ := p.scanIgnoreWhitespace()
+		if tok != COMMA {
 			p.unscan()
 			break
 		}
 
+		// top() takes an ORDER BY sort of its hits.
+		if name == "top" {
+			if tok, pos, _ := p.scanIgnoreWhitespace(); tok == ORDER {
+				arg, err := p.parseOrderByExpr(pos)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				argPos = append(argPos, pos)
+				continue
+			}
+			p.unscan()
+		}
+
+		// terms() takes its bucket order as the third argument, followed
+		// by an optional missing() label.
+		if name == "terms" && len(args) == 2 && !p.peekMissing() {
+			arg, missing, err := p.parseTermsOrder()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			argPos = append(argPos, arg.Pos())
+			if !missing {
+				continue
+			}
+			// The comma before missing() is already consumed.
+			_, pos, _ := p.scanIgnoreWhitespace()
+			p.unscan()
+			expr, err := p.ParseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, expr)
+			argPos = append(argPos, pos)
+			continue
+		}
+
 		re, err := p.parseRegex()
 		if err != nil {
 			return nil, err
 		} else if re != nil {
 			args = append(args, re)
+			argPos = append(argPos, pos)
 			continue
 		}
 
 		// Parse an expression argument.
+		_, pos, _ = p.scan()
+		p.unscan()
 		arg, err := p.ParseExpr()
 		if err != nil {
 			return nil, err
 		}
 		args = append(args, arg)
+		argPos = append(argPos, pos)
 	}
 
 	// There should be a right parentheses at the end.
-	if tok, pos, lit := p.scan(); tok != RPAREN {
+	tok, pos, lit := p.scan()
+	if tok != RPAREN {
 		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
 	}
 
+	// percentile_rank values are checked here to report their position.
+	if name == "percentile_rank" {
+		if len(args) < 2 {
+			msg := fmt.Sprintf("invalid number of arguments for %s, expected at least 2, got %d", name, len(args))
+			return nil, &ParseError{Message: msg, Pos: pos}
+		}
+		for i, arg := range args[1:] {
+			if !isNumberLiteral(arg) {
+				msg := fmt.Sprintf("invalid value %s in %s(), expected a number", arg.String(), name)
+				return nil, &ParseError{Message: msg, Pos: argPos[i+1]}
+			}
+		}
+	}
+
+	if quoted && isFieldMetricFunction(name) {
+		if args[0], err = quotedFieldArg(name, args[0], argPos[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	// count(1) is the sql idiom of count(*), the doc count. Other numbers
+	// are rejected rather than counted as documents too.
+	if name == "count" && len(args) == 1 && isNumberLiteral(args[0]) {
+		if n, ok := args[0].(*IntegerLiteral); !ok || n.Val != 1 {
+			msg := fmt.Sprintf("invalid count(%s), expected count(*), count(1) or count(field)", args[0].String())
+			return nil, &ParseError{Message: msg, Pos: argPos[0]}
+		}
+		wc := &Wildcard{}
+		wc.setPos(argPos[0])
+		args[0] = wc
+	}
+
 	return &Call{Name: name, Args: args}, nil
 }
 
+// parseDistinct parses the arguments of a DISTINCT modifier into a distinct() call,
+// so that "count(DISTINCT field)" and "count(distinct(field))" are equivalent.
+// This function assumes the DISTINCT token has already been consumed.
+func (p *Parser) parseDistinct() (*Call, error) {
+	if tok, _, _ := p.scan(); tok == LPAREN {
+		return p.parseCall("distinct")
+	}
+	p.unscan()
+
+	var args []Expr
+	for {
+		tok, pos, _ := p.scanIgnoreWhitespace()
+		quoted := tok == STRING && p.s.quote() == '"'
+		p.unscan()
+		arg, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if quoted && len(args) == 0 {
+			if arg, err = quotedFieldArg("distinct", arg, pos); err != nil {
+				return nil, err
+			}
+		}
+		args = append(args, arg)
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	return &Call{Name: "distinct", Args: args}, nil
+}
+
+// quotedFieldArg returns the field argument of a metric function, a double
+// quoted field name such as avg("response time") is a field reference named
+// by its unquoted value.
+func quotedFieldArg(name string, arg Expr, pos Pos) (Expr, error) {
+	lit, ok := arg.(*StringLiteral)
+	if !ok {
+		return arg, nil
+	} else if lit.Val == "" {
+		msg := fmt.Sprintf("invalid empty field name in %s()", name)
+		return nil, &ParseError{Message: msg, Pos: pos}
+	}
+	ref := &VarRef{Val: lit.Val, Segments: []string{lit.Val}}
+	ref.setPos(pos)
+	return ref, nil
+}
+
 // scan returns the next token from the underlying scanner.
 func (p *Parser) scan() (tok Token, pos Pos, lit string) { return p.s.Scan() }
 
@@ -903,7 +1916,7 @@ func (p *Parser) consumeWhitespace() {
 func (p *Parser) unscan() { p.s.Unscan() }
 
 var (
-	qsReplacer = strings.NewReplacer("\n", `\n`, `\`, `\\`, `'`, `\'`)
+	qsReplacer = strings.NewReplacer("\n", `\n`, "\t", `\t`, "\r", `\r`, "\f", `\f`, "\b", `\b`, "\x00", `\0`, `\`, `\\`, `'`, `\'`)
 	qiReplacer = strings.NewReplacer("\n", `\n`, `\`, `\\`, `"`, `\"`)
 )
 
@@ -937,11 +1950,29 @@ func QuoteIdent(segments ...string) string {
 	return buf.String()
 }
 
+// quoteSegments joins the segments of a field path with dots, quoting with
+// backticks the segments which are not bare identifiers.
+func quoteSegments(segments []string) string {
+	var buf bytes.Buffer
+	for i, segment := range segments {
+		if i > 0 {
+			_ = buf.WriteByte('.')
+		}
+		if segment != "" && !IdentNeedsQuotes(segment) {
+			_, _ = buf.WriteString(segment)
+			continue
+		}
+		_ = buf.WriteByte('`')
+		_, _ = buf.WriteString(strings.Replace(segment, "`", "``", -1))
+		_ = buf.WriteByte('`')
+	}
+	return buf.String()
+}
+
 // IdentNeedsQuotes returns true if the ident string given would require quotes.
 func IdentNeedsQuotes(ident string) bool {
 	// check if this identifier is a keyword
-	tok := Lookup(ident)
-	if tok != IDENT {
+	if _, ok := Lookup(ident); ok {
 		return true
 	}
 	for i, r := range ident {
@@ -954,6 +1985,22 @@ func IdentNeedsQuotes(ident string) bool {
 	return false
 }
 
+// isSourcePattern returns true if the name can be written as a bare index
+// pattern in the FROM clause.
+func isSourcePattern(name string) bool {
+	if _, ok := Lookup(name); ok || strings.Contains(name, "--") {
+		return false
+	}
+	for i, r := range name {
+		if i == 0 && !isIdentFirstChar(r) && r != '@' && r != '*' {
+			return false
+		} else if i > 0 && !isIdentChar(r) && !isSourcePatternChar(r) {
+			return false
+		}
+	}
+	return name != ""
+}
+
 // ParseError represents an error that occurred during parsing.
 type ParseError struct {
 	Message  string
@@ -967,10 +2014,11 @@ func newParseError(found string, expected []string, pos Pos) *ParseError {
 	return &ParseError{Found: found, Expected: expected, Pos: pos}
 }
 
-// Error returns the string representation of the error.
+// Error returns the string representation of the error, e.g.
+// "found EOF, expected FROM at 1:9". Lines and chars count from 1.
 func (e *ParseError) Error() string {
 	if e.Message != "" {
-		return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+		return fmt.Sprintf("%s at %d:%d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
 	}
-	return fmt.Sprintf("found %s, expected %s at line %d, char %d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
+	return fmt.Sprintf("found %s, expected %s at %d:%d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
 }
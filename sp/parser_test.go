@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -95,11 +96,11 @@ func TestParser_ParseStatement(t *testing.T) {
 
 		// SELECT statement
 		{
-			s: fmt.Sprintf(`SELECT mean(field1), sum(field2) ,count(field3) AS field_x FROM myseries WHERE host = 'hosta.influxdb.org' and time > %d GROUP BY time("10h") ORDER BY DESC LIMIT 20, 10`, now.Unix()),
+			s: fmt.Sprintf(`SELECT avg(field1), sum(field2) ,count(field3) AS field_x FROM myseries WHERE host = 'hosta.influxdb.org' and time > %d GROUP BY date_histogram(time, '10h') ORDER BY DESC LIMIT 20, 10`, now.Unix()),
 			stmt: &sp.SelectStatement{
 				IsRawQuery: false,
 				Fields: []*sp.Field{
-					{Expr: &sp.Call{Name: "mean", Args: []sp.Expr{&sp.VarRef{Val: "field1", Segments: []string{"field1"}}}}},
+					{Expr: &sp.Call{Name: "avg", Args: []sp.Expr{&sp.VarRef{Val: "field1", Segments: []string{"field1"}}}}},
 					{Expr: &sp.Call{Name: "sum", Args: []sp.Expr{&sp.VarRef{Val: "field2", Segments: []string{"field2"}}}}},
 					{Expr: &sp.Call{Name: "count", Args: []sp.Expr{&sp.VarRef{Val: "field3", Segments: []string{"field3"}}}}, Alias: "field_x"},
 				},
@@ -117,12 +118,37 @@ func TestParser_ParseStatement(t *testing.T) {
 						RHS: &sp.IntegerLiteral{Val: now.Unix()},
 					},
 				},
-				Dimensions: []*sp.Dimension{{Expr: &sp.Call{Name: "time", Args: []sp.Expr{&sp.StringLiteral{Val: "10h"}}}}},
+				Dimensions: []*sp.Dimension{{Expr: &sp.Call{Name: "date_histogram", Args: []sp.Expr{&sp.VarRef{Val: "time", Segments: []string{"time"}}, &sp.StringLiteral{Val: "10h"}}}}},
 				SortFields: []*sp.SortField{
 					{Ascending: false},
 				},
-				Limit:  20,
-				Offset: 10,
+				Limit:    10,
+				HasLimit: true,
+				Offset:   20,
+			},
+		},
+
+		// SELECT statement with LIMIT and OFFSET
+		{
+			s: `SELECT * FROM logs LIMIT 20 OFFSET 40`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Limit:      20,
+				HasLimit:   true,
+				Offset:     40,
+			},
+		},
+
+		// SELECT statement with an explicit LIMIT 0
+		{
+			s: `SELECT * FROM logs LIMIT 0`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				HasLimit:   true,
 			},
 		},
 		{
@@ -137,17 +163,17 @@ func TestParser_ParseStatement(t *testing.T) {
 		},
 
 		{
-			s: `SELECT func1(arg1, 100, arg3, arg4) FROM myseries`,
+			s: `SELECT percentile(arg1, 50, 90, 99) FROM myseries`,
 			stmt: &sp.SelectStatement{
 				IsRawQuery: false,
 				Fields: []*sp.Field{
 					{Expr: &sp.Call{
-						Name: "func1",
+						Name: "percentile",
 						Args: []sp.Expr{
 							&sp.VarRef{Val: "arg1", Segments: []string{"arg1"}},
-							&sp.IntegerLiteral{Val: 100},
-							&sp.VarRef{Val: "arg3", Segments: []string{"arg3"}},
-							&sp.VarRef{Val: "arg4", Segments: []string{"arg4"}},
+							&sp.IntegerLiteral{Val: 50},
+							&sp.IntegerLiteral{Val: 90},
+							&sp.IntegerLiteral{Val: 99},
 						}}},
 				},
 				Sources: []sp.Source{&sp.Measurement{Database: "myseries"}},
@@ -155,20 +181,20 @@ func TestParser_ParseStatement(t *testing.T) {
 		},
 
 		{
-			s: `SELECT func1(field1) / func2(field2) FROM myseries`,
+			s: `SELECT sum(field1) / max(field2) FROM myseries`,
 			stmt: &sp.SelectStatement{
 				IsRawQuery: false,
 				Fields: []*sp.Field{
 					{
 						Expr: &sp.BinaryExpr{
 							LHS: &sp.Call{
-								Name: "func1",
+								Name: "sum",
 								Args: []sp.Expr{
 									&sp.VarRef{Val: "field1", Segments: []string{"field1"}},
 								},
 							},
 							RHS: &sp.Call{
-								Name: "func2",
+								Name: "max",
 								Args: []sp.Expr{
 									&sp.VarRef{Val: "field2", Segments: []string{"field2"}},
 								},
@@ -184,16 +210,16 @@ func TestParser_ParseStatement(t *testing.T) {
 		},
 
 		{
-			s: fmt.Sprintf(`SELECT func1(func2(field1)) FROM myseries GROUP BY func3(field3)`),
+			s: fmt.Sprintf(`SELECT derivative(max(field1)), max(field1) FROM myseries GROUP BY histogram(field3, 10)`),
 			stmt: &sp.SelectStatement{
 				IsRawQuery: false,
 				Fields: []*sp.Field{
 					{
 						Expr: &sp.Call{
-							Name: "func1",
+							Name: "derivative",
 							Args: []sp.Expr{
 								&sp.Call{
-									Name: "func2",
+									Name: "max",
 									Args: []sp.Expr{
 										&sp.VarRef{Val: "field1", Segments: []string{"field1"}},
 									},
@@ -201,14 +227,23 @@ func TestParser_ParseStatement(t *testing.T) {
 							},
 						},
 					},
+					{
+						Expr: &sp.Call{
+							Name: "max",
+							Args: []sp.Expr{
+								&sp.VarRef{Val: "field1", Segments: []string{"field1"}},
+							},
+						},
+					},
 				},
 				Sources: []sp.Source{&sp.Measurement{Database: "myseries"}},
 				Dimensions: []*sp.Dimension{
 					{
 						Expr: &sp.Call{
-							Name: "func3",
+							Name: "histogram",
 							Args: []sp.Expr{
 								&sp.VarRef{Val: "field3", Segments: []string{"field3"}},
+								&sp.IntegerLiteral{Val: 10},
 							},
 						},
 					},
@@ -226,20 +261,197 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement with dotted and quoted field paths
+		{
+			s: `select user.address.city, user."zip" from people`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{Expr: &sp.VarRef{Val: "user.address.city", Segments: []string{"user", "address", "city"}}},
+					{Expr: &sp.VarRef{Val: "user.zip", Segments: []string{"user", "zip"}}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "people"}},
+			},
+		},
+
+		// SELECT statement with multiple sources
+		{
+			s: "select * from `logs-2023`, logs_2024",
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs-2023"}, &sp.Measurement{Database: "logs_2024"}},
+			},
+		},
+
+		// SELECT statement with a source alias
+		{
+			s: `select l.host, l.geo.city from logs AS l order by l.host desc`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{Expr: &sp.VarRef{Val: "host", Segments: []string{"host"}}},
+					{Expr: &sp.VarRef{Val: "geo.city", Segments: []string{"geo", "city"}}},
+				},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs", Alias: "l"}},
+				SortFields: []*sp.SortField{{Name: "host"}},
+			},
+		},
+
+		// SELECT statement with NULLS FIRST / NULLS LAST
+		{
+			s: `SELECT * FROM logs ORDER BY referer DESC NULLS FIRST, host nulls last`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				SortFields: []*sp.SortField{
+					{Name: "referer", Nulls: sp.FIRST},
+					{Name: "host", Ascending: true, Nulls: sp.LAST},
+				},
+			},
+		},
+
+		// SELECT statement with POST_FILTER
+		{
+			s: `SELECT max(price) FROM products AS p WHERE p.stock > 0 POST_FILTER(WHERE p.color = 'red') LIMIT 10`,
+			stmt: &sp.SelectStatement{
+				Fields:  []*sp.Field{{Expr: &sp.Call{Name: "max", Args: []sp.Expr{&sp.VarRef{Val: "price", Segments: []string{"price"}}}}}},
+				Sources: []sp.Source{&sp.Measurement{Database: "products", Alias: "p"}},
+				Condition: &sp.BinaryExpr{
+					Op:  sp.GT,
+					LHS: &sp.VarRef{Val: "stock", Segments: []string{"stock"}},
+					RHS: &sp.IntegerLiteral{Val: 0},
+				},
+				PostFilter: &sp.BinaryExpr{
+					Op:  sp.EQ,
+					LHS: &sp.VarRef{Val: "color", Segments: []string{"color"}},
+					RHS: &sp.StringLiteral{Val: "red"},
+				},
+				Limit:    10,
+				HasLimit: true,
+			},
+		},
+
+		// SELECT statement grouped by a composite aggregation
+		{
+			s: `SELECT host, status, count(*) FROM logs GROUP BY COMPOSITE host, status AFTER('web-1', 500) LIMIT 50`,
+			stmt: &sp.SelectStatement{
+				Fields: []*sp.Field{
+					{Expr: &sp.VarRef{Val: "host", Segments: []string{"host"}}},
+					{Expr: &sp.VarRef{Val: "status", Segments: []string{"status"}}},
+					{Expr: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Wildcard{}}}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "logs"}},
+				Dimensions: []*sp.Dimension{
+					{Expr: &sp.VarRef{Val: "host", Segments: []string{"host"}}},
+					{Expr: &sp.VarRef{Val: "status", Segments: []string{"status"}}},
+				},
+				Composite: true,
+				After:     []sp.Expr{&sp.StringLiteral{Val: "web-1"}, &sp.IntegerLiteral{Val: 500}},
+				Limit:     50,
+				HasLimit:  true,
+			},
+		},
+
+		// SELECT statement with CONCAT lowered to || operators
+		{
+			s: `SELECT concat(host, ':', port) AS addr FROM logs`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{
+						Expr: &sp.BinaryExpr{
+							Op: sp.CONCAT,
+							LHS: &sp.BinaryExpr{
+								Op:  sp.CONCAT,
+								LHS: &sp.VarRef{Val: "host", Segments: []string{"host"}},
+								RHS: &sp.StringLiteral{Val: ":"},
+							},
+							RHS: &sp.VarRef{Val: "port", Segments: []string{"port"}},
+						},
+						Alias: "addr",
+					},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "logs"}},
+			},
+		},
+
+		// SELECT statement with COLLAPSE
+		{
+			s: `SELECT * FROM logs AS l WHERE l.a = 1 COLLAPSE(l.user.id, 3) ORDER BY ts DESC`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs", Alias: "l"}},
+				Condition: &sp.BinaryExpr{
+					Op:  sp.EQ,
+					LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}},
+					RHS: &sp.IntegerLiteral{Val: 1},
+				},
+				Collapse:   &sp.Collapse{Field: "user.id", Size: 3},
+				SortFields: []*sp.SortField{{Name: "ts"}},
+			},
+		},
+
+		// SELECT statement with HIGHLIGHT
+		{
+			s: `SELECT * FROM logs AS l WHERE match(l.message, 'err') HIGHLIGHT(l.message, title, 150, 3)`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs", Alias: "l"}},
+				Condition: &sp.Call{
+					Name: "match",
+					Args: []sp.Expr{&sp.VarRef{Val: "message", Segments: []string{"message"}}, &sp.StringLiteral{Val: "err"}},
+				},
+				Highlight: &sp.Highlight{Fields: []string{"message", "title"}, FragmentSize: 150, Fragments: 3},
+			},
+		},
+
+		// SELECT statement in random order
+		{
+			s: `SELECT * FROM logs ORDER BY ts DESC, RANDOM(42)`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				SortFields: []*sp.SortField{
+					{Name: "ts"},
+					{Name: "random(42)", Ascending: true, Call: &sp.Call{Name: "random", Args: []sp.Expr{&sp.IntegerLiteral{Val: 42}}}, Random: &sp.Call{Name: "random", Args: []sp.Expr{&sp.IntegerLiteral{Val: 42}}}},
+				},
+			},
+		},
+
+		// SELECT statement ordered by an aggregate call
+		{
+			s: `SELECT count(*) FROM logs GROUP BY host ORDER BY count(*) DESC`,
+			stmt: &sp.SelectStatement{
+				Fields: []*sp.Field{
+					{Expr: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Wildcard{}}}},
+				},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Dimensions: []*sp.Dimension{{Expr: &sp.VarRef{Val: "host", Segments: []string{"host"}}}},
+				SortFields: []*sp.SortField{
+					{Name: "count(*)", Call: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Wildcard{}}}},
+				},
+			},
+		},
+
 		// SELECT statement with multiple ORDER BY fields
 		{
-			skip: true,
-			s:    `SELECT field1 FROM myseries ORDER BY ASC, field1, field2 DESC LIMIT 10`,
+			s: `SELECT field1 FROM myseries ORDER BY ASC, field1, field2 DESC LIMIT 10`,
 			stmt: &sp.SelectStatement{
 				IsRawQuery: true,
-				Fields:     []*sp.Field{{Expr: &sp.VarRef{Val: "field1"}}},
+				Fields:     []*sp.Field{{Expr: &sp.VarRef{Val: "field1", Segments: []string{"field1"}}}},
 				Sources:    []sp.Source{&sp.Measurement{Database: "myseries"}},
 				SortFields: []*sp.SortField{
 					{Ascending: true},
-					{Name: "field1"},
+					{Name: "field1", Ascending: true},
 					{Name: "field2"},
 				},
-				Limit: 10,
+				Limit:    10,
+				HasLimit: true,
 			},
 		},
 
@@ -279,6 +491,166 @@ func TestParser_ParseStatement(t *testing.T) {
 		},
 
 		// SELECT * FROM WHERE field comparisons
+		// SELECT DISTINCT statement
+		{
+			s: `SELECT DISTINCT country, city FROM logs WHERE status = 200`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Dedupe:     true,
+				Fields: []*sp.Field{
+					{Expr: &sp.VarRef{Val: "country", Segments: []string{"country"}}},
+					{Expr: &sp.VarRef{Val: "city", Segments: []string{"city"}}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "logs"}},
+				Condition: &sp.BinaryExpr{
+					Op:  sp.EQ,
+					LHS: &sp.VarRef{Val: "status", Segments: []string{"status"}},
+					RHS: &sp.IntegerLiteral{Val: 200},
+				},
+			},
+		},
+
+		// SELECT COUNT(DISTINCT field) statement
+		{
+			s: `SELECT COUNT(DISTINCT host) AS hosts FROM logs`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: false,
+				Fields: []*sp.Field{
+					{Expr: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Call{Name: "distinct", Args: []sp.Expr{&sp.VarRef{Val: "host", Segments: []string{"host"}}}}}}, Alias: "hosts"},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "logs"}},
+			},
+		},
+
+		// SELECT COUNT(1) statement is a COUNT(*)
+		{
+			s: `SELECT COUNT(1) AS n FROM logs`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: false,
+				Fields: []*sp.Field{
+					{Expr: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Wildcard{}}}, Alias: "n"},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "logs"}},
+			},
+		},
+
+		// SELECT COUNT(DISTINCT field, precision_threshold) statement
+		{
+			s: `SELECT COUNT(DISTINCT host, 3000) FROM logs`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: false,
+				Fields: []*sp.Field{
+					{Expr: &sp.Call{Name: "count", Args: []sp.Expr{
+						&sp.Call{Name: "distinct", Args: []sp.Expr{&sp.VarRef{Val: "host", Segments: []string{"host"}}}},
+						&sp.IntegerLiteral{Val: 3000},
+					}}},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "logs"}},
+			},
+		},
+
+		// SELECT metric FILTER (WHERE condition) statement
+		{
+			s: `SELECT sum(amount) FILTER (WHERE status = 'paid') AS paid FROM orders`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: false,
+				Fields: []*sp.Field{
+					{
+						Expr: &sp.Call{Name: "sum", Args: []sp.Expr{&sp.VarRef{Val: "amount", Segments: []string{"amount"}}}},
+						Filter: &sp.BinaryExpr{
+							Op:  sp.EQ,
+							LHS: &sp.VarRef{Val: "status", Segments: []string{"status"}},
+							RHS: &sp.StringLiteral{Val: "paid"},
+						},
+						Alias: "paid",
+					},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "orders"}},
+			},
+		},
+
+		// SELECT statement with a hint comment
+		{
+			s: `SELECT /*+ track_total_hits */ * FROM logs`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Hints:      []string{"track_total_hits"},
+			},
+		},
+
+		// SELECT CASE WHEN ... END statement
+		{
+			s: `SELECT CASE WHEN status >= 500 THEN 'err' WHEN status >= 400 THEN 'client' ELSE 'ok' END AS bucket FROM logs`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields: []*sp.Field{
+					{Expr: &sp.CaseExpr{
+						WhenClauses: []*sp.WhenClause{
+							{
+								Condition: &sp.BinaryExpr{Op: sp.GTE, LHS: &sp.VarRef{Val: "status", Segments: []string{"status"}}, RHS: &sp.IntegerLiteral{Val: 500}},
+								Result:    &sp.StringLiteral{Val: "err"},
+							},
+							{
+								Condition: &sp.BinaryExpr{Op: sp.GTE, LHS: &sp.VarRef{Val: "status", Segments: []string{"status"}}, RHS: &sp.IntegerLiteral{Val: 400}},
+								Result:    &sp.StringLiteral{Val: "client"},
+							},
+						},
+						Else: &sp.StringLiteral{Val: "ok"},
+					}, Alias: "bucket"},
+				},
+				Sources: []sp.Source{&sp.Measurement{Database: "logs"}},
+			},
+		},
+
+		// SELECT TOP(size, ORDER BY sort) statement
+		{
+			s: `SELECT top(3, ORDER BY ts DESC) AS latest FROM logs GROUP BY host`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: false,
+				Fields: []*sp.Field{
+					{Expr: &sp.Call{Name: "top", Args: []sp.Expr{
+						&sp.IntegerLiteral{Val: 3},
+						&sp.OrderByExpr{SortFields: []*sp.SortField{{Name: "ts", Ascending: false}}},
+					}}, Alias: "latest"},
+				},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Dimensions: []*sp.Dimension{{Expr: &sp.VarRef{Val: "host", Segments: []string{"host"}}}},
+			},
+		},
+
+		// SELECT ... GROUP BY TERMS(field, size, order) statement
+		{
+			s: `SELECT count(*) FROM logs GROUP BY terms(host, 20, count(*) DESC)`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: false,
+				Fields:     []*sp.Field{{Expr: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Wildcard{}}}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Dimensions: []*sp.Dimension{{Expr: &sp.Call{Name: "terms", Args: []sp.Expr{
+					&sp.VarRef{Val: "host", Segments: []string{"host"}},
+					&sp.IntegerLiteral{Val: 20},
+					&sp.OrderByExpr{SortFields: []*sp.SortField{{Name: "count(*)", Ascending: false, Call: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Wildcard{}}}}}},
+				}}}},
+			},
+		},
+
+		// SELECT ... GROUP BY TERMS(field, size, order, MISSING(label)) statement
+		{
+			s: `SELECT count(*) FROM logs GROUP BY terms(host, 20, _key ASC, missing('unknown'))`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: false,
+				Fields:     []*sp.Field{{Expr: &sp.Call{Name: "count", Args: []sp.Expr{&sp.Wildcard{}}}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Dimensions: []*sp.Dimension{{Expr: &sp.Call{Name: "terms", Args: []sp.Expr{
+					&sp.VarRef{Val: "host", Segments: []string{"host"}},
+					&sp.IntegerLiteral{Val: 20},
+					&sp.OrderByExpr{SortFields: []*sp.SortField{{Name: "_key", Ascending: true}}},
+					&sp.Call{Name: "missing", Args: []sp.Expr{&sp.StringLiteral{Val: "unknown"}}},
+				}}}},
+			},
+		},
+
 		{
 			s: `SELECT * FROM cpu WHERE load > 100`,
 			stmt: &sp.SelectStatement{
@@ -357,40 +729,376 @@ func TestParser_ParseStatement(t *testing.T) {
 				},
 			},
 		},
-		// Errors
-		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
-		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 8`},
-		{s: `blah blah`, err: `found blah, expected SELECT at line 1, char 1`},
-		{s: `SELECT field1 X`, err: `found X, expected FROM at line 1, char 15`},
-		{s: `SELECT field1 FROM "series" WHERE X`, err: `found series, expected identifier at line 1, char 19`},
-		{s: `SELECT field1 FROM myseries GROUP`, err: `found EOF, expected BY at line 1, char 35`},
-		{s: `SELECT field1 FROM myseries LIMIT`, err: `found EOF, expected integer at line 1, char 35`},
-		{s: `SELECT field1 FROM myseries LIMIT 10.5`, err: `found 10.5, expected integer at line 1, char 35`},
-		{s: `SELECT top() FROM myseries`, err: `invalid number of arguments for top, expected at least 1, got 0`},
-		{s: `SELECT field1 FROM myseries ORDER`, err: `found EOF, expected BY at line 1, char 35`},
-		{s: `SELECT field1 FROM myseries ORDER BY`, err: `found EOF, expected identifier, ASC, DESC at line 1, char 38`},
-		{s: `SELECT field1 FROM myseries ORDER BY /`, err: `found /, expected identifier, ASC, DESC at line 1, char 38`},
-		{s: `SELECT field1 FROM myseries ORDER BY 1`, err: `found 1, expected identifier, ASC, DESC at line 1, char 38`},
-		{s: `SELECT field1 FROM myseries ORDER BY time ASC,`, err: `found EOF, expected identifier at line 1, char 47`},
-		{s: `SELECT field1 AS`, err: `found EOF, expected identifier at line 1, char 18`},
-		{s: `SELECT field1 FROM 12`, err: `found 12, expected identifier at line 1, char 20`},
-		{s: `SELECT 1000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000 FROM myseries`, err: `unable to parse integer at line 1, char 8`},
-		{s: `SELECT 10.5h FROM myseries`, err: `found h, expected FROM at line 1, char 12`},
-		{s: `SELECT value > 2 FROM cpu`, err: `invalid operator > in SELECT field, only support +-*/`},
-		{s: `SELECT value = 2 FROM cpu`, err: `invalid operator = in SELECT field, only support +-*/`},
-	}
+		// SELECT statement with LIKE and NOT LIKE conditions
+		{
+			s: `SELECT * FROM symbol WHERE name LIKE 'App%' AND sector NOT LIKE '%tech\_%'`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "symbol"}},
+				Condition: &sp.BinaryExpr{
+					Op: sp.AND,
+					LHS: &sp.BinaryExpr{
+						Op:  sp.LIKE,
+						LHS: &sp.VarRef{Val: "name", Segments: []string{"name"}},
+						RHS: &sp.StringLiteral{Val: "App%"},
+					},
+					RHS: &sp.BinaryExpr{
+						Op:  sp.NLIKE,
+						LHS: &sp.VarRef{Val: "sector", Segments: []string{"sector"}},
+						RHS: &sp.StringLiteral{Val: `%tech\_%`},
+					},
+				},
+			},
+		},
 
-	for i, tt := range tests {
-		if tt.skip {
-			continue
-		}
-		p := sp.NewParser(strings.NewReader(tt.s))
+		// SELECT statement with IN and NOT IN conditions
+		{
+			s: `SELECT * FROM logs WHERE status IN (200, 404) AND host NOT IN ('a', 'b')`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Condition: &sp.BinaryExpr{
+					Op: sp.AND,
+					LHS: &sp.BinaryExpr{
+						Op:  sp.IN,
+						LHS: &sp.VarRef{Val: "status", Segments: []string{"status"}},
+						RHS: &sp.ListLiteral{Vals: []interface{}{int64(200), int64(404)}},
+					},
+					RHS: &sp.BinaryExpr{
+						Op:  sp.NI,
+						LHS: &sp.VarRef{Val: "host", Segments: []string{"host"}},
+						RHS: &sp.ListLiteral{Vals: []interface{}{"a", "b"}},
+					},
+				},
+			},
+		},
 
-		stmt, err := p.ParseStatement()
+		// SELECT statement with BETWEEN condition
+		{
+			s: `SELECT * FROM symbol WHERE exchange = 'nyse' AND last_sale BETWEEN 10 AND 20`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "symbol"}},
+				Condition: &sp.BinaryExpr{
+					Op: sp.AND,
+					LHS: &sp.BinaryExpr{
+						Op:  sp.EQ,
+						LHS: &sp.VarRef{Val: "exchange", Segments: []string{"exchange"}},
+						RHS: &sp.StringLiteral{Val: "nyse"},
+					},
+					RHS: &sp.BetweenExpr{
+						Expr:  &sp.VarRef{Val: "last_sale", Segments: []string{"last_sale"}},
+						Lower: &sp.IntegerLiteral{Val: 10},
+						Upper: &sp.IntegerLiteral{Val: 20},
+					},
+				},
+			},
+		},
 
-		if !reflect.DeepEqual(tt.err, errstring(err)) {
-			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
-		} else if tt.err == "" {
+		// SELECT statement with IS NULL condition
+		{
+			s: `SELECT * FROM logs WHERE referer IS NULL AND agent IS NOT NULL`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Condition: &sp.BinaryExpr{
+					Op:  sp.AND,
+					LHS: &sp.IsNullExpr{Expr: &sp.VarRef{Val: "referer", Segments: []string{"referer"}}},
+					RHS: &sp.IsNullExpr{Expr: &sp.VarRef{Val: "agent", Segments: []string{"agent"}}, Not: true},
+				},
+			},
+		},
+
+		// SELECT statement with NOT condition
+		{
+			s: `SELECT * FROM logs WHERE NOT (status = 200)`,
+			stmt: &sp.SelectStatement{
+				IsRawQuery: true,
+				Fields:     []*sp.Field{{Expr: &sp.Wildcard{}}},
+				Sources:    []sp.Source{&sp.Measurement{Database: "logs"}},
+				Condition: &sp.NotExpr{
+					Expr: &sp.ParenExpr{
+						Expr: &sp.BinaryExpr{Op: sp.EQ, LHS: &sp.VarRef{Val: "status", Segments: []string{"status"}}, RHS: &sp.IntegerLiteral{Val: 200}},
+					},
+				},
+			},
+		},
+
+		// Errors
+		{s: ``, err: `found EOF, expected SELECT at 1:1`},
+		{s: `SELECT * FROM symbol WHERE last_sale BETWEEN 10 AND lower`, err: `invalid filter, unsupport BETWEEN bound lower at 1:53`},
+		{s: `SELECT * FROM symbol WHERE last_sale BETWEEN 10`, err: `found EOF, expected AND at 1:48`},
+		{s: `SELECT * FROM logs WHERE a BETWEEN 1 AND 2 + 3`, err: `invalid filter, a BETWEEN 1 AND 2 can't be an operand of + at 1:26`},
+		{s: `SELECT * FROM logs WHERE a IS NULL + 1`, err: `invalid filter, a IS NULL can't be an operand of + at 1:26`},
+		{s: `SELECT * FROM logs WHERE (a BETWEEN 1 AND 2) = true`, err: `invalid filter, (a BETWEEN 1 AND 2) can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE a = (b BETWEEN 1 AND 2)`, err: `invalid filter, (b BETWEEN 1 AND 2) can't be an operand of = at 1:30`},
+		{s: `SELECT * FROM logs WHERE (a IS NULL) = true`, err: `invalid filter, (a IS NULL) can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE a + (b IS NULL) > 1`, err: `invalid filter, (b IS NULL) can't be an operand of + at 1:30`},
+		{s: `SELECT * FROM logs WHERE match(message)`, err: `invalid number of arguments for match, expected 2 or 3, got 1 at 1:26`},
+		{s: `SELECT * FROM logs WHERE match('error', message)`, err: `invalid filter, match requires a field and a string query at 1:26`},
+		{s: `SELECT * FROM logs WHERE match(message, 'error', 'xor')`, err: `invalid filter, match operator must be 'and' or 'or' at 1:49`},
+		{s: `SELECT * FROM logs WHERE match_phrase(message)`, err: `invalid number of arguments for match_phrase, expected 2 or 3, got 1 at 1:26`},
+		{s: `SELECT * FROM logs WHERE match_phrase(message, 2)`, err: `invalid filter, match_phrase requires a field and a string query at 1:26`},
+		{s: `SELECT * FROM logs WHERE match_phrase(message, 'connection refused', 'x')`, err: `invalid filter, match_phrase slop must be a non-negative integer at 1:69`},
+		{s: `SELECT * FROM shops WHERE geo_distance(location, 40.7, -74.0)`, err: `invalid number of arguments for geo_distance, expected 4, got 3 at 1:27`},
+		{s: `SELECT * FROM shops WHERE geo_distance('location', 40.7, -74.0, '10km')`, err: `invalid filter, geo_distance requires a geo_point field at 1:39`},
+		{s: `SELECT * FROM shops WHERE geo_distance(location, 90.5, -74.0, '10km')`, err: `invalid filter, geo_distance latitude 90.5, expected a number from -90 to 90 at 1:50`},
+		{s: `SELECT * FROM shops WHERE geo_distance(location, 40.7, 'x', '10km')`, err: `invalid filter, geo_distance longitude 'x', expected a number from -180 to 180 at 1:55`},
+		{s: `SELECT * FROM shops WHERE geo_distance(location, 40.7, 181, '10km')`, err: `invalid filter, geo_distance longitude 181, expected a number from -180 to 180 at 1:56`},
+		{s: `SELECT * FROM shops WHERE geo_bounding_box(location, 40.73, -74.1, 40.01)`, err: `invalid number of arguments for geo_bounding_box, expected 5, got 4 at 1:27`},
+		{s: `SELECT * FROM shops WHERE geo_bounding_box(40.73, -74.1, 40.01, -71.12, 1)`, err: `invalid filter, geo_bounding_box requires a geo_point field at 1:44`},
+		{s: `SELECT * FROM shops WHERE geo_bounding_box(location, 40.73, -74.1, -95, -71.12)`, err: `invalid filter, geo_bounding_box latitude -95, expected a number from -90 to 90 at 1:68`},
+		{s: `SELECT * FROM shops WHERE geo_bounding_box(location, 40.73, 'w', 40.01, -71.12)`, err: `invalid filter, geo_bounding_box longitude 'w', expected a number from -180 to 180 at 1:60`},
+		{s: `SELECT * FROM shops WHERE geo_distance(location, 40.7, -74.0, '10 parsecs')`, err: `invalid filter, geo_distance distance '10 parsecs', expected a number with a unit such as '10km' at 1:62`},
+		{s: `SELECT * FROM logs WHERE multi_match('timeout')`, err: `invalid filter, multi_match requires at least one field at 1:26`},
+		{s: `SELECT * FROM logs WHERE multi_match(title, body)`, err: `invalid filter, multi_match requires a string query at 1:38`},
+		{s: `SELECT * FROM logs WHERE multi_match('timeout', title, 'best')`, err: `invalid filter, unsupport multi_match type 'best' at 1:55`},
+		{s: `SELECT * FROM logs WHERE multi_match('timeout', 'title', body)`, err: `invalid filter, multi_match expected field argument, got 'title' at 1:48`},
+		{s: `SELECT * FROM logs WHERE query_string('status:200', 'path:/api/*')`, err: `invalid number of arguments for query_string, expected 1, got 2 at 1:26`},
+		{s: `SELECT * FROM logs WHERE query_string(status)`, err: `invalid filter, query_string requires a string query at 1:39`},
+		{s: `SELECT * FROM logs WHERE prefix(path)`, err: `invalid number of arguments for prefix, expected 2 or 3, got 1 at 1:26`},
+		{s: `SELECT * FROM logs WHERE prefix(path, 1)`, err: `invalid filter, prefix requires a field and a string value at 1:26`},
+		{s: `SELECT * FROM logs WHERE prefix('/api', path)`, err: `invalid filter, prefix requires a field and a string value at 1:26`},
+		{s: `SELECT * FROM logs WHERE prefix(path, '/api', 'yes')`, err: `invalid filter, prefix case insensitivity must be true or false, got 'yes' at 1:46`},
+		{s: `SELECT * FROM users WHERE fuzzy(name)`, err: `invalid number of arguments for fuzzy, expected 2 or 3, got 1 at 1:27`},
+		{s: `SELECT * FROM users WHERE fuzzy(name, jon)`, err: `invalid filter, fuzzy requires a field and a string value at 1:27`},
+		{s: `SELECT * FROM users WHERE fuzzy(name, 'jon', 3)`, err: `invalid filter, fuzzy fuzziness 3, expected 0, 1, 2 or 'AUTO' at 1:46`},
+		{s: `SELECT * FROM users WHERE fuzzy(name, 'jon', 'AUTO:3,6')`, err: `invalid filter, fuzzy fuzziness 'AUTO:3,6', expected 0, 1, 2 or 'AUTO' at 1:45`},
+		{s: `SELECT * FROM tweets WHERE terms_lookup(user_id, 'users', '123')`, err: `invalid number of arguments for terms_lookup, expected 4, got 3 at 1:28`},
+		{s: `SELECT * FROM tweets WHERE terms_lookup('user_id', 'users', '123', 'followers')`, err: `invalid filter, terms_lookup requires a field, got 'user_id' at 1:40`},
+		{s: `SELECT * FROM tweets WHERE terms_lookup(user_id, users, '123', 'followers')`, err: `invalid filter, terms_lookup index users, expected a non-empty string at 1:50`},
+		{s: `SELECT * FROM tweets WHERE terms_lookup(user_id, 'users', '', 'followers')`, err: `invalid filter, terms_lookup id '', expected a non-empty string at 1:58`},
+		{s: `SELECT * FROM tweets WHERE terms_lookup(user_id, 'users', '123', 1)`, err: `invalid filter, terms_lookup path 1, expected a non-empty string at 1:66`},
+		{s: `SELECT * FROM logs WHERE exists()`, err: `invalid number of arguments for exists, expected 1, got 0 at 1:26`},
+		{s: `SELECT * FROM logs WHERE exists(host, path)`, err: `invalid number of arguments for exists, expected 1, got 2 at 1:26`},
+		{s: `SELECT * FROM logs WHERE NOT exists('host')`, err: `invalid filter, exists requires a field, got 'host' at 1:36`},
+		{s: `SELECT * FROM logs WHERE ts > now(1)`, err: `invalid number of arguments for now, expected 0, got 1 at 1:31`},
+		{s: `SELECT * FROM logs WHERE now()`, err: `invalid filter, now() must be compared with a time field at 1:26`},
+		{s: `SELECT * FROM logs WHERE 5 > now() - 1h`, err: `invalid filter, 5 > now() - 1h: now() must be compared with a time field at 1:26`},
+		{s: `SELECT * FROM logs WHERE ts = now()`, err: `invalid filter, unsupport op = for now() at 1:26`},
+		{s: `SELECT * FROM logs WHERE price - 1h > 3`, err: `invalid filter, price - 1h: durations can only be added to or subtracted from now() at 1:26`},
+		{s: `SELECT * FROM logs WHERE ts > 1h`, err: `invalid filter, ts > 1h: durations can only be added to or subtracted from now() at 1:26`},
+		{s: `SELECT * FROM logs WHERE ts > now() - 100ms`, err: `invalid filter, duration 100ms must be a whole number of seconds at 1:39`},
+		{s: `SELECT * FROM logs WHERE match(message, 'error') = true`, err: `invalid filter, match(message, 'error') can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE lower(message) = 'error'`, err: `invalid filter, unsupport function lower(message) at 1:26`},
+		{s: "SELECT * FROM logs WHERE status = 200 AND\n  upper(message)", err: `invalid filter, unsupport function upper(message) at 2:3`},
+		{s: `SELECT * FROM logs WHERE 1 IN (1, 2)`, err: `invalid filter, IN requires a field at 1:26`},
+		{s: `SELECT * FROM logs WHERE a IN (1) = true`, err: `invalid filter, a IN (1) can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE (a IN (1, 2)) = true`, err: `invalid filter, (a IN (1, 2)) can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE flags IN (1, 2) * bytes`, err: `invalid filter, flags IN (1, 2) can't be an operand of * at 1:26`},
+		{s: `SELECT * FROM logs WHERE a LIKE 'x' = true`, err: `invalid filter, a LIKE 'x' can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE (a NOT LIKE 'x%') = true`, err: `invalid filter, (a NOT LIKE 'x%') can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE (match(m, 'x')) = true`, err: `invalid filter, (match(m, 'x')) can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE (exists(host)) = false`, err: `invalid filter, (exists(host)) can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE a + prefix(path, '/api') > 1`, err: `invalid filter, prefix(path, '/api') can't be an operand of + at 1:30`},
+		{s: `SELECT * FROM logs WHERE (fuzzy(name, 'jon')) != true`, err: `invalid filter, (fuzzy(name, 'jon')) can't be an operand of != at 1:26`},
+		{s: `SELECT * FROM logs WHERE (query_string('status:200')) = true`, err: `invalid filter, (query_string('status:200')) can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE (NOT a = 1) = true`, err: `invalid filter, (NOT a = 1) can't be an operand of = at 1:26`},
+		{s: `SELECT * FROM logs WHERE b + (NOT a = 1) > 1`, err: `invalid filter, (NOT a = 1) can't be an operand of + at 1:30`},
+		{s: `SELECT * FROM symbol WHERE name LIKE 10`, err: `invalid filter, LIKE requires a field and a string pattern at 1:28`},
+		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at 1:8`},
+		{s: `blah blah`, err: `found blah, expected SELECT at 1:1`},
+		{s: `SELECT field1 X`, err: `found X, expected FROM at 1:15`},
+		{s: `SELECT 1`, err: `missing FROM clause at 1:9`},
+		{s: `SELECT field1, field2`, err: `missing FROM clause at 1:23`},
+		{s: `SELECT field1 WHERE field1 = 1`, err: `missing FROM clause at 1:15`},
+		{s: `SELECT count(*) GROUP BY host`, err: `missing FROM clause at 1:17`},
+		{s: `SELECT field1 FROM "series" WHERE X`, err: `found series, expected identifier at 1:19`},
+		{s: `SELECT field1 FROM myseries GROUP`, err: `found EOF, expected BY at 1:35`},
+		{s: `SELECT field1 FROM myseries LIMIT`, err: `found EOF, expected integer at 1:35`},
+		{s: `SELECT field1 FROM myseries LIMIT 10.5`, err: `found 10.5, expected integer at 1:35`},
+		{s: `SELECT field1 FROM myseries LIMIT -1`, err: `LIMIT must be >= 0 at 1:35`},
+		{s: `SELECT field1 FROM myseries LIMIT 10 OFFSET -5`, err: `OFFSET must be >= 0 at 1:45`},
+		{s: `SELECT field1 FROM myseries LIMIT -5, 10`, err: `LIMIT must be >= 0 at 1:35`},
+		{s: `SELECT field1 FROM myseries LIMIT 10 OFFSET`, err: `found EOF, expected integer at 1:45`},
+		{s: `SELECT field1 FROM myseries LIMIT 99999999999999999999`, err: `unable to parse integer at 1:35`},
+		{s: `SELECT field1 FROM myseries LIMIT 10 OFFSET 99999999999999999999`, err: `unable to parse integer at 1:45`},
+		{s: `SELECT top() FROM myseries`, err: `invalid number of arguments for top, expected at least 1, got 0 at 1:8`},
+		{s: `SELECT field1 FROM myseries ORDER`, err: `found EOF, expected BY at 1:35`},
+		{s: `SELECT field1 FROM myseries ORDER BY`, err: `found EOF, expected identifier, ASC, DESC at 1:38`},
+		{s: `SELECT field1 FROM myseries ORDER BY /`, err: `found /, expected identifier, ASC, DESC at 1:38`},
+		{s: `SELECT field1 FROM myseries ORDER BY 1`, err: `found 1, expected identifier, ASC, DESC at 1:38`},
+		{s: `SELECT field1 FROM myseries ORDER BY time ASC,`, err: `found EOF, expected identifier at 1:47`},
+		{s: `SELECT field1 FROM myseries ORDER BY NULLS FIRST`, err: `found NULLS, expected identifier, ASC, DESC at 1:38`},
+		{s: `SELECT field1 FROM myseries ORDER BY DESC NULLS FIRST`, err: `NULLS must follow a sort field at 1:43`},
+		{s: `SELECT field1 FROM myseries ORDER BY time NULLS`, err: `found EOF, expected FIRST, LAST at 1:49`},
+		{s: `SELECT count(*) FROM logs GROUP BY host ORDER BY host NULLS LAST`, err: `invalid ORDER BY host, NULLS LAST is not supported with GROUP BY at 1:50`},
+		{s: `SELECT field1 AS`, err: `found EOF, expected identifier at 1:18`},
+		{s: `SELECT field1 FROM 12`, err: `found 12, expected identifier at 1:20`},
+		{s: `SELECT 1000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000 FROM myseries`, err: `unable to parse integer at 1:8`},
+		{s: `SELECT 10.5h FROM myseries`, err: `found h, expected FROM at 1:12`},
+		{s: `SELECT COUNT(DISTINCT host, agent) FROM logs`, err: `invalid number of arguments for count(DISTINCT), expected 1, got 2 at 1:1`},
+		{s: `SELECT SUM(DISTINCT bytes) FROM logs`, err: `invalid DISTINCT in sum(), only support count(DISTINCT field) at 1:1`},
+		{s: `SELECT moving_avg(count(*), 5) FROM logs GROUP BY host`, err: `invalid moving_avg(count(*), 5), expected GROUP BY histogram() or date_histogram() at 1:8`},
+		{s: `SELECT moving_avg(sum(bytes), 5) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `invalid moving_avg(sum(bytes), 5), sum(bytes) is not in the SELECT list at 1:19`},
+		{s: `SELECT sum(bytes), derivative(max(bytes)) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `invalid derivative(max(bytes)), max(bytes) is not in the SELECT list at 1:31`},
+		{s: `SELECT cumulative_sum(count(*), 2) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `invalid number of arguments for cumulative_sum, expected 1, got 2 at 1:8`},
+		{s: `SELECT derivative(count(*)) FROM logs`, err: `invalid derivative(count(*)), expected GROUP BY histogram() or date_histogram() at 1:8`},
+		{s: `SELECT moving_avg(count(*)) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `invalid number of arguments for moving_avg, expected 2, got 1 at 1:8`},
+		{s: `SELECT moving_avg(bytes, 5) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `expected metric aggregation argument in moving_avg() at 1:19`},
+		{s: `SELECT moving_avg(percentile(bytes, 95), 5) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `expected metric aggregation argument in moving_avg() at 1:19`},
+		{s: `SELECT moving_avg(count(*), 0) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `invalid window 0 in moving_avg(), expected a positive integer at 1:29`},
+		{s: `SELECT max(bytes) AS m, derivative(total) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `expected metric aggregation argument in derivative() at 1:36`},
+		{s: `SELECT host AS h, derivative(h) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `invalid derivative(h), h is not a metric aggregation at 1:30`},
+		{s: `SELECT percentile(bytes, 95) AS p, derivative(p) FROM logs GROUP BY date_histogram(ts, '1h')`, err: `invalid derivative(p), p is not a metric aggregation at 1:47`},
+		{s: `SELECT script('a', 'b', 'c', 'd', 'e') FROM logs`, err: `invalid number of arguments for script, expected 1 to 4, got 5 at 1:8`},
+		{s: `SELECT script(bytes) FROM logs`, err: `invalid script bytes in script(), expected a string at 1:15`},
+		{s: `SELECT COUNT(5) FROM logs`, err: `invalid count(5), expected count(*), count(1) or count(field) at 1:14`},
+		{s: `SELECT COUNT(1.5) FROM logs`, err: `invalid count(1.5), expected count(*), count(1) or count(field) at 1:14`},
+		{s: `SELECT COUNT(DISTINCT host, 0) FROM logs`, err: `invalid precision_threshold 0 in count(), expected an integer from 1 to 40000 at 1:29`},
+		{s: `SELECT COUNT(DISTINCT host, 40001) FROM logs`, err: `invalid precision_threshold 40001 in count(), expected an integer from 1 to 40000 at 1:29`},
+		{s: `SELECT COUNT(host, 100) FROM logs`, err: `invalid number of arguments for count, expected 1, got 2 at 1:8`},
+		{s: `SELECT cardinality(host, 1.5) FROM logs`, err: `invalid precision_threshold 1.5 in cardinality(), expected an integer from 1 to 40000 at 1:26`},
+		{s: `SELECT cardinality(host, 100, 200) FROM logs`, err: `invalid number of arguments for cardinality, expected 1 or 2, got 3 at 1:8`},
+		{s: `SELECT AVG(latency, bytes) FROM logs`, err: `invalid number of arguments for avg, expected 1, got 2 at 1:8`},
+		{s: `SELECT MIN(*) FROM logs`, err: `expected field argument in min() at 1:12`},
+		{s: `SELECT MAX(count(bytes)) FROM logs`, err: `expected field argument in max() at 1:12`},
+		{s: `SELECT SUM('bytes') FROM logs`, err: `expected field argument in sum() at 1:11`},
+		{s: `SELECT AVG("") FROM logs`, err: `invalid empty field name in avg() at 1:11`},
+		{s: `SELECT COUNT(DISTINCT "") FROM logs`, err: `invalid empty field name in distinct() at 1:22`},
+		{s: `SELECT VALUE_COUNT(*) FROM logs`, err: `expected field argument in value_count() at 1:20`},
+		{s: `SELECT /*+ track_total_hits, full_scan */ * FROM logs`, err: `unknown hint full_scan at 1:1`},
+		{s: `SELECT CASE status WHEN 500 THEN 'err' END FROM logs`, err: `found status, expected WHEN at 1:13`},
+		{s: `SELECT CASE WHEN status >= 500 'err' END FROM logs`, err: `found err, expected THEN at 1:31`},
+		{s: `SELECT CASE WHEN status >= 500 THEN 'err' ELSE 'ok' FROM logs`, err: `found FROM, expected END at 1:53`},
+		{s: `SELECT CASE WHEN status THEN 'err' END FROM logs`, err: `invalid CASE condition status at 1:18`},
+		{s: `SELECT count(*) FROM logs GROUP BY CASE WHEN path =~ /api/ THEN 'api' END`, err: `invalid CASE condition path =~ /api/ at 1:46`},
+		{s: `SELECT count(*) FROM people GROUP BY range(age)`, err: `invalid number of arguments for range, expected at least 2, got 1 at 1:38`},
+		{s: `SELECT count(*) FROM people GROUP BY range(18, 65)`, err: `expected field argument in range() at 1:44`},
+		{s: `SELECT count(*) FROM people GROUP BY range(age, 0, 'adult')`, err: `invalid range breakpoint 'adult', expected a number at 1:51`},
+		{s: `SELECT count(*) FROM people GROUP BY range(age, 0, 65, 18)`, err: `invalid range breakpoints, 18 must be greater than 65 at 1:56`},
+		{s: `SELECT count(*) FROM people GROUP BY range(age, 0, 0)`, err: `invalid range breakpoints, 0 must be greater than 0 at 1:52`},
+		{s: `SELECT count(*) FROM logs GROUP BY date_range(ts, 'now-1d')`, err: `invalid number of arguments for date_range, expected at least 3, got 2 at 1:36`},
+		{s: `SELECT count(*) FROM logs GROUP BY date_range(ts, 'now-1d', 1600000000)`, err: `invalid date_range bound 1600000000, expected a string at 1:61`},
+		{s: `SELECT top(0) FROM logs GROUP BY host`, err: `invalid size 0 in top(), expected a positive integer at 1:12`},
+		{s: `SELECT top(1.5) FROM logs GROUP BY host`, err: `invalid size 1.5 in top(), expected a positive integer at 1:12`},
+		{s: `SELECT top(3, ts) FROM logs GROUP BY host`, err: `invalid sort ts in top(), expected ORDER BY at 1:15`},
+		{s: `SELECT top(3, ORDER ts) FROM logs`, err: `found ts, expected BY at 1:21`},
+		{s: `SELECT top(3, 1, ORDER BY ts) FROM logs`, err: `invalid number of arguments for top, expected 1 or 2, got 3 at 1:8`},
+		{s: `SELECT sum(amount) FILTER WHERE status = 'paid' FROM orders`, err: `found WHERE, expected ( at 1:27`},
+		{s: `SELECT sum(amount) FILTER (status = 'paid') FROM orders`, err: `found status, expected WHERE at 1:28`},
+		{s: `SELECT sum(amount) FILTER (WHERE status = 'paid' FROM orders`, err: `found FROM, expected ) at 1:50`},
+		{s: `SELECT amount FILTER (WHERE status = 'paid') FROM orders`, err: `invalid FILTER on amount, expected a metric aggregation at 1:8`},
+		{s: `SELECT sum(amount) FILTER (WHERE now()) FROM orders`, err: `invalid filter, now() must be compared with a time field at 1:34`},
+		{s: `SELECT host, count(*) FROM logs`, err: `invalid field host mixed with aggregates, expected GROUP BY at 1:8`},
+		{s: `SELECT max(bytes), bytes / 8 AS b FROM logs`, err: `invalid field bytes / 8 mixed with aggregates, expected GROUP BY at 1:20`},
+		{s: `SELECT * FROM logs WHERE referer > NULL`, err: `invalid filter, unsupport op > for NULL at 1:36`},
+		{s: `SELECT * FROM logs WHERE 1 = NULL`, err: `invalid filter, = NULL requires a field at 1:26`},
+		{s: `SELECT * FROM logs WHERE NULL`, err: `invalid filter, NULL must be compared with a field at 1:26`},
+		{s: `SELECT DISTINCT country, count(*) FROM logs`, err: `invalid DISTINCT with aggregate function count(*) at 1:26`},
+		{s: `SELECT DISTINCT * FROM logs`, err: `invalid DISTINCT field *, only support fields at 1:17`},
+		{s: `SELECT DISTINCT country FROM logs GROUP BY country`, err: `invalid DISTINCT with GROUP BY at 1:44`},
+		{s: `SELECT count(*) FROM logs GROUP BY date_histogram(timestamp, '1x')`, err: `invalid date_histogram interval 1x, expected units s, m, h, d, w, M, y at 1:61`},
+		{s: `SELECT count(*) FROM logs GROUP BY date_histogram(timestamp, '2M')`, err: `invalid date_histogram interval 2M, calendar units only support 1M at 1:61`},
+		{s: `SELECT count(*) FROM logs GROUP BY date_histogram(timestamp, 1)`, err: `expected interval string argument in date_histogram() at 1:62`},
+		{s: `SELECT count(*) FROM logs GROUP BY date_histogram(timestamp)`, err: `invalid number of arguments for date_histogram, expected 2, got 1 at 1:36`},
+		{s: `SELECT count(*) FROM logs GROUP BY histogram(response_ms, 0)`, err: `invalid histogram interval 0, expected a positive number at 1:59`},
+		{s: `SELECT count(*) FROM logs GROUP BY histogram(response_ms, -10)`, err: `invalid histogram interval -10, expected a positive number at 1:59`},
+		{s: `SELECT count(*) FROM logs GROUP BY histogram(response_ms, 'x')`, err: `invalid histogram interval 'x', expected a positive number at 1:58`},
+		{s: `SELECT count(*) FROM logs GROUP BY histogram(response_ms, 10, 1.5)`, err: `invalid histogram min_doc_count 1.5, expected a non-negative integer at 1:63`},
+		{s: `SELECT count(*) FROM logs GROUP BY histogram(response_ms)`, err: `invalid number of arguments for histogram, expected 2 or 3, got 1 at 1:36`},
+		{s: `SELECT count(*) FROM logs GROUP BY geohash(location)`, err: `invalid number of arguments for geohash, expected 2, got 1 at 1:36`},
+		{s: `SELECT count(*) FROM logs GROUP BY geohash('location', 5)`, err: `expected geo_point field argument in geohash() at 1:43`},
+		{s: `SELECT count(*) FROM logs GROUP BY geohash(location, 0)`, err: `invalid geohash precision 0, expected an integer from 1 to 12 at 1:54`},
+		{s: `SELECT count(*) FROM logs GROUP BY geohash(location, 13)`, err: `invalid geohash precision 13, expected an integer from 1 to 12 at 1:54`},
+		{s: `SELECT count(*) FROM logs GROUP BY terms(host)`, err: `invalid number of arguments for terms, expected 2 to 4, got 1 at 1:36`},
+		{s: `SELECT count(*) FROM logs GROUP BY terms('host', 20)`, err: `expected field argument in terms() at 1:41`},
+		{s: `SELECT count(*) FROM logs GROUP BY terms(host, 0)`, err: `invalid terms size 0, expected a positive integer at 1:48`},
+		{s: `SELECT count(*) FROM logs GROUP BY terms(host, 20, avg(latency) DESC)`, err: `invalid terms order avg(latency), expected a metric of the select at 1:52`},
+		{s: `SELECT count(*) FROM logs GROUP BY terms(host, 20, missing(0))`, err: `invalid missing label 0, expected a string at 1:60`},
+		{s: `SELECT count(*) FROM logs GROUP BY terms(host, 20, missing('a'), 'b')`, err: `invalid terms argument 'b', expected missing() at 1:65`},
+		{s: `SELECT count(*) FROM logs GROUP BY host, missing('unknown')`, err: `invalid missing() outside terms() at 1:42`},
+		{s: `SELECT DISTINCT host FROM logs GROUP BY terms(host, 20, missing('unknown'))`, err: `invalid DISTINCT with GROUP BY at 1:41`},
+		{s: `SELECT percentile(latency) FROM logs`, err: `invalid number of arguments for percentile, expected at least 2, got 1 at 1:8`},
+		{s: `SELECT percentile(latency, 95, 101) FROM logs`, err: `invalid percentile 101 in percentile(), expected a number between 0 and 100 at 1:32`},
+		{s: `SELECT percentiles(latency, 50, 101) FROM logs`, err: `invalid percentile 101 in percentiles(), expected a number between 0 and 100 at 1:33`},
+		{s: `SELECT percentile(latency, 'p95') FROM logs`, err: `invalid percentile 'p95' in percentile(), expected a number between 0 and 100 at 1:27`},
+		{s: `SELECT percentile_rank(latency) FROM logs`, err: `invalid number of arguments for percentile_rank, expected at least 2, got 1 at 1:31`},
+		{s: `SELECT percentile_rank(latency, 500, 'slow') FROM logs`, err: `invalid value 'slow' in percentile_rank(), expected a number at 1:37`},
+		{s: `SELECT stats(amount, tax) FROM orders`, err: `invalid number of arguments for stats, expected 1, got 2 at 1:8`},
+		{s: `SELECT COUNT(*) FROM logs HAVING COUNT(*) > 100`, err: `invalid HAVING, expected GROUP BY at 1:34`},
+		{s: `SELECT count(*) FROM logs GROUP BY host ORDER BY latency`, err: `invalid ORDER BY latency, expected a dimension or metric at 1:50`},
+		{s: `SELECT nested(items) FROM orders`, err: `invalid number of arguments for nested, expected 2, got 1 at 1:8`},
+		{s: `SELECT nested(items, items.price) FROM orders`, err: `expected metric aggregation argument in nested() at 1:22`},
+		{s: `SELECT nested(items, avg(price)) FROM orders`, err: `invalid nested field price, expected prefix items. at 1:22`},
+		{s: `SELECT shop, nested(items, avg(items.price)) FROM orders GROUP BY shop HAVING nested(items, avg(items.price)) > 3`, err: `invalid nested() in HAVING, expected a field alias at 1:79`},
+		{s: `SELECT sum(bytes) AS total FROM logs GROUP BY host HAVING totl > 10`, err: `invalid HAVING, unknown alias totl at 1:59`},
+		{s: `SELECT host AS h, sum(bytes) AS total FROM logs GROUP BY host HAVING total > 10 AND h > 1`, err: `invalid HAVING, unknown alias h at 1:85`},
+		{s: `SELECT nested(items, avg(items.price)) * 2 FROM orders`, err: `invalid nested() in SELECT expression nested(items, avg(items.price)) * 2 at 1:8`},
+		{s: `SELECT * FROM`, err: `invalid FROM, expected at least one source at 1:15`},
+		{s: `SELECT * FROM WHERE a = 1`, err: `invalid FROM, expected at least one source at 1:15`},
+		{s: `SELECT * FROM orders JOIN users ON orders.uid = users.id`, err: `JOIN is not supported by Elasticsearch; denormalize your data at 1:22`},
+		{s: `SELECT * FROM orders AS o LEFT OUTER JOIN users AS u ON o.uid = u.id`, err: `JOIN is not supported by Elasticsearch; denormalize your data at 1:27`},
+		{s: `SELECT * FROM a, b INNER JOIN c ON a.id = c.id`, err: `JOIN is not supported by Elasticsearch; denormalize your data at 1:20`},
+		{s: `SELECT * FROM a UNION SELECT * FROM b`, err: `UNION is only supported by a multi-search at 1:17`},
+		{s: `SELECT * FROM products POST_FILTER(WHERE color = 'red')`, err: `invalid POST_FILTER without aggregations, use WHERE at 1:42`},
+		{s: `SELECT max(price) FROM products POST_FILTER(color = 'red')`, err: `found color, expected WHERE at 1:45`},
+		{s: `SELECT max(price) FROM products POST_FILTER WHERE color = 'red'`, err: `found WHERE, expected ( at 1:45`},
+		{s: `SELECT max(price) FROM products POST_FILTER(WHERE color = 'red'`, err: `found EOF, expected ) at 1:64`},
+		{s: `SELECT max(price) FROM products POST_FILTER(WHERE ts = now())`, err: `invalid filter, unsupport op = for now() at 1:51`},
+		{s: `SELECT host, count(*) FROM logs GROUP BY host AFTER('a')`, err: `invalid AFTER without GROUP BY COMPOSITE at 1:52`},
+		{s: `SELECT host, count(*) FROM logs GROUP BY COMPOSITE host AFTER('a', 1)`, err: `invalid AFTER, expected 1 keys, got 2 at 1:62`},
+		{s: `SELECT host, count(*) FROM logs GROUP BY COMPOSITE host AFTER(next)`, err: `invalid AFTER key next, expected a literal at 1:63`},
+		{s: `SELECT host, count(*) FROM logs GROUP BY COMPOSITE host AFTER 'a'`, err: `found a, expected ( at 1:62`},
+		{s: `SELECT count(*) FROM logs GROUP BY COMPOSITE range(bytes, 10, 20)`, err: `invalid GROUP BY COMPOSITE, range(bytes, 10, 20) is not a composite source at 1:46`},
+		{s: `SELECT host, count(*) AS c FROM logs GROUP BY COMPOSITE host ORDER BY c DESC`, err: `invalid ORDER BY c, GROUP BY COMPOSITE only sorts by dimensions at 1:71`},
+		{s: `SELECT * FROM logs COLLAPSE session_id`, err: `found session_id, expected ( at 1:29`},
+		{s: `SELECT * FROM logs COLLAPSE(a + b)`, err: `invalid COLLAPSE(a + b), expected a field name at 1:29`},
+		{s: `SELECT * FROM logs COLLAPSE('a')`, err: `invalid COLLAPSE('a'), expected a field name at 1:28`},
+		{s: `SELECT * FROM logs COLLAPSE(a, b)`, err: `found b, expected integer at 1:32`},
+		{s: `SELECT * FROM logs COLLAPSE(a, 101)`, err: `invalid value 101: must be 1 <= n <= 100 at 1:32`},
+		{s: `SELECT * FROM logs COLLAPSE(a, 2`, err: `found EOF, expected ) at 1:33`},
+		{s: `SELECT host, count(*) FROM logs COLLAPSE(a) GROUP BY host`, err: `invalid COLLAPSE with aggregations at 1:33`},
+		{s: `SELECT DISTINCT a FROM logs COLLAPSE(a)`, err: `invalid COLLAPSE with aggregations at 1:29`},
+		{s: `SELECT * FROM logs WHERE match(msg, 'x') HIGHLIGHT msg`, err: `found msg, expected ( at 1:52`},
+		{s: `SELECT * FROM logs WHERE match(msg, 'x') HIGHLIGHT()`, err: `invalid HIGHLIGHT, expected at least one field at 1:52`},
+		{s: `SELECT * FROM logs WHERE match(msg, 'x') HIGHLIGHT(10)`, err: `invalid HIGHLIGHT(10), expected a field name at 1:52`},
+		{s: `SELECT * FROM logs WHERE match(msg, 'x') HIGHLIGHT(msg, 'title')`, err: `invalid HIGHLIGHT('title'), expected a field name at 1:56`},
+		{s: `SELECT * FROM logs WHERE match(msg, 'x') HIGHLIGHT(msg, 0)`, err: `invalid value 0: must be 1 <= n <= 2147483647 at 1:57`},
+		{s: `SELECT * FROM logs WHERE match(msg, 'x') HIGHLIGHT(msg, 100, 2, 1)`, err: `found ,, expected ) at 1:63`},
+		{s: `SELECT * FROM logs WHERE status = 500 HIGHLIGHT(msg)`, err: `invalid HIGHLIGHT without a full-text query in WHERE at 1:39`},
+		{s: `SELECT * FROM logs HIGHLIGHT(msg)`, err: `invalid HIGHLIGHT without a full-text query in WHERE at 1:20`},
+		{s: `SELECT count(*) FROM logs WHERE match(msg, 'x') HIGHLIGHT(msg)`, err: `invalid HIGHLIGHT with aggregations at 1:49`},
+		{s: `SELECT * FROM logs ORDER BY random('a')`, err: `invalid random('a'), expected an integer seed at 1:29`},
+		{s: `SELECT * FROM logs ORDER BY random(1, 2)`, err: `invalid number of arguments for random, expected 0 or 1, got 2 at 1:29`},
+		{s: `SELECT host, count(*) FROM logs GROUP BY host ORDER BY random()`, err: `invalid ORDER BY random(), expected a dimension or metric at 1:56`},
+		{s: `SELECT * FROM logs,`, err: `found EOF, expected identifier at 1:20`},
+		{s: `SELECT * FROM logs AS l, metrics AS l`, err: `duplicate source alias l at 1:26`},
+		{s: `SELECT * FROM logs AS`, err: `found EOF, expected identifier at 1:23`},
+		{s: `SELECT * FROM t WHERE a > 1 /* never closed`, err: `found /* never closed, expected EOF at 1:29`},
+		{s: `SELECT * FROM t WHERE a > 1__0`, err: `found 1__0, expected identifier, string, number, bool at 1:27`},
+		{s: `SELECT * FROM t WHERE a > 0x`, err: `found 0x, expected identifier, string, number, bool at 1:27`},
+		{s: `SELECT value > 2 FROM cpu`, err: `invalid operator > in SELECT field, only support +-*/%&|^ and || at 1:8`},
+		{s: `SELECT value = 2 FROM cpu`, err: `invalid operator = in SELECT field, only support +-*/%&|^ and || at 1:8`},
+		{s: `SELECT concat(host) FROM logs`, err: `invalid number of arguments for concat, expected at least 2, got 1 at 1:8`},
+		{s: `SELECT concat() AS l FROM logs`, err: `invalid number of arguments for concat, expected at least 2, got 0 at 1:8`},
+		{s: `SELECT host FROM logs WHERE concat(host, 'x') = 'ax'`, err: `invalid filter, unsupport function concat(host, 'x') at 1:29`},
+		{s: `SELECT foo(x) FROM t`, err: `invalid aggregate, unsupport function foo(x) at 1:8`},
+		{s: `SELECT avg(x) / foo(y) FROM t`, err: `invalid aggregate, unsupport function foo(y) at 1:17`},
+		{s: `SELECT nested(p, foo(p.x)) FROM t`, err: `expected metric aggregation argument in nested() at 1:18`},
+		{s: `SELECT a + b AS x FROM t ORDER BY x`, err: `invalid ORDER BY x, script fields can't sort the hits at 1:35`},
+		{s: `SELECT * FROM t ORDER BY foo(x) DESC`, err: `invalid ORDER BY, unsupport function foo(x) at 1:26`},
+		{s: `SELECT * FROM t ORDER BY a, avg(b)`, err: `invalid ORDER BY, unsupport function avg(b) at 1:29`},
+		{s: `SELECT count(*) FROM t GROUP BY foo()`, err: `invalid GROUP BY, unsupport function foo() at 1:33`},
+		{s: `SELECT count(*) FROM t GROUP BY floor()`, err: `invalid number of arguments for floor, expected at least 1, got 0 at 1:33`},
+	}
+
+	for i, tt := range tests {
+		if tt.skip {
+			continue
+		}
+		p := sp.NewParser(strings.NewReader(tt.s))
+
+		stmt, err := p.ParseStatement()
+
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		} else if tt.err == "" {
+			sp.ClearPos(stmt)
 			if !reflect.DeepEqual(tt.stmt, stmt) {
 				t.Logf("\n# %s\nexp=%s\ngot=%s\n", tt.s, mustMarshalJSON(tt.stmt), mustMarshalJSON(stmt))
 				t.Logf("\nSQL exp=%s\nSQL got=%s\n", tt.stmt.String(), stmt.String())
@@ -398,6 +1106,9 @@ func TestParser_ParseStatement(t *testing.T) {
 			} else {
 
 				stmt2, err := sp.ParseStatement(stmt.String())
+				if err == nil {
+					sp.ClearPos(stmt2)
+				}
 				if err != nil {
 					t.Errorf("%d. %q: unable to parse statement string: %s", i, stmt.String(), err)
 				} else if !reflect.DeepEqual(tt.stmt, stmt2) {
@@ -428,7 +1139,7 @@ func TestParseGroupBy(t *testing.T) {
 			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
 		}
 
-		d := stmt.(*sp.SelectStatement).Dimensions.String()
+		d := stmt.Dimensions.String()
 
 		if d != tt.d {
 			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.d, d)
@@ -445,9 +1156,11 @@ func TestParseStatement(t *testing.T) {
 		err  string
 	}{
 		// Errors
-		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
-		{s: `CREATE`, err: `found CREATE, expected SELECT at line 1, char 1`},
+		{s: ``, err: `found EOF, expected SELECT at 1:1`},
+		{s: `CREATE`, err: `found CREATE, expected SELECT at 1:1`},
 		{s: `SELECT sum(x) FROM Packetbeat`, err: ``},
+		{s: "/* note */ SELECT a FROM t -- get a", err: ``},
+		{s: "SELECT a, -- first\n b /* second */ FROM t WHERE a > 1 -- filter\nLIMIT 10", err: ``},
 	}
 	for i, tt := range tests {
 		p := sp.NewParser(strings.NewReader(tt.s))
@@ -459,6 +1172,299 @@ func TestParseStatement(t *testing.T) {
 	}
 }
 
+// Ensure a reset parser doesn't keep any state of the previous query.
+func TestParser_Reset(t *testing.T) {
+	p := sp.NewParser(strings.NewReader(`SELECT /*+ track_total_hits */ a FROM t WHERE ((b = `))
+	if _, err := p.ParseStatement(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	p.Reset(strings.NewReader(`SELECT a FROM t WHERE b = 1`))
+	sel, err := p.ParseStatement()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp := `SELECT a FROM t WHERE b = 1`; sel.String() != exp {
+		t.Errorf("statement mismatch:\n  exp=%s\n  got=%s", exp, sel.String())
+	}
+	if sel.Hints != nil {
+		t.Errorf("unexpected hints: %q", sel.Hints)
+	}
+	if pos := sel.Condition.Pos(); pos != (sp.Pos{Line: 0, Char: 22, Offset: 22}) {
+		t.Errorf("unexpected position: %#v", pos)
+	}
+}
+
+// Ensure pooled parsers can be shared by concurrent goroutines.
+func TestParserPool(t *testing.T) {
+	var pool sp.ParserPool
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				q := fmt.Sprintf(`SELECT a FROM t WHERE b = %d`, i*100+j)
+				p := pool.Get(strings.NewReader(q))
+				stmt, err := p.ParseStatement()
+				pool.Put(p)
+				if err != nil {
+					errs <- err
+					return
+				} else if stmt.String() != q {
+					errs <- fmt.Errorf("statement mismatch: exp=%s got=%s", q, stmt.String())
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	p := pool.Get(strings.NewReader(`SELECT a FROM t`))
+	if p.MaxDepth != sp.DefaultMaxDepth {
+		t.Errorf("expected the default max depth, got %d", p.MaxDepth)
+	}
+}
+
+// Ensure parsing and translating from concurrent goroutines doesn't share
+// any state, run with -race.
+func TestParserPool_Translate(t *testing.T) {
+	queries := []string{
+		`SELECT * FROM logs WHERE a = 1 AND b = 2 OR c = 3`,
+		`SELECT host, count(*) AS n FROM logs GROUP BY host HAVING n > 1 AND n < 10`,
+		`SELECT host, count(*) FILTER (WHERE status = 500) AS errors FROM logs GROUP BY host`,
+		`SELECT host, count(*) FROM logs WHERE a = 1 POST_FILTER(WHERE b = 2 AND c = 3) GROUP BY host`,
+	}
+	exp := make([]string, len(queries))
+	for i, q := range queries {
+		dsl, err := sp.Translate(q, nil)
+		if err != nil {
+			t.Fatalf("%d. %s: unexpected error: %s", i, q, err)
+		}
+		exp[i] = dsl
+	}
+
+	var pool sp.ParserPool
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				i := j % len(queries)
+				p := pool.Get(strings.NewReader(queries[i]))
+				stmt, err := p.ParseStatement()
+				pool.Put(p)
+				if err != nil {
+					errs <- err
+					return
+				} else if _, err := sp.ParseStatement(stmt.String()); err != nil {
+					errs <- fmt.Errorf("unable to parse %s: %s", stmt.String(), err)
+					return
+				}
+				if dsl, err := sp.Translate(queries[i], nil); err != nil {
+					errs <- err
+					return
+				} else if dsl != exp[i] {
+					errs <- fmt.Errorf("%s: dsl mismatch: exp=%s got=%s", queries[i], exp[i], dsl)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// Ensure syntax errors are returned as a ParseError with the found token.
+func TestParser_ParseStatement_ParseError(t *testing.T) {
+	_, err := sp.NewParser(strings.NewReader(`SELECT value FROM cpu WHERE value = `)).ParseStatement()
+	perr, ok := err.(*sp.ParseError)
+	if !ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+	if perr.Found != "EOF" {
+		t.Errorf("unexpected found token: %s", perr.Found)
+	}
+	if perr.Pos.Line != 0 || perr.Pos.Char != 37 {
+		t.Errorf("unexpected position: %#v", perr.Pos)
+	}
+	if exp := `found EOF, expected identifier, string, number, bool at 1:38`; perr.Error() != exp {
+		t.Errorf("error mismatch:\n  exp=%s\n  got=%s", exp, perr.Error())
+	}
+}
+
+// Ensure statements changed after parsing can be validated again.
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		s      string
+		change func(stmt *sp.SelectStatement)
+		err    string
+	}{
+		{s: `SELECT host, count(*) FROM logs GROUP BY host`, change: func(stmt *sp.SelectStatement) {}},
+		{
+			s:      `SELECT host, count(*) FROM logs GROUP BY host`,
+			change: func(stmt *sp.SelectStatement) { stmt.Dimensions = nil },
+			err:    `invalid field host mixed with aggregates, expected GROUP BY at 1:8`,
+		},
+		{
+			s:      `SELECT count(*) FROM logs GROUP BY host HAVING count(*) > 10`,
+			change: func(stmt *sp.SelectStatement) { stmt.Dimensions = nil },
+			err:    `invalid HAVING, expected GROUP BY at 1:48`,
+		},
+		{
+			s:      `SELECT count(*) AS n FROM logs GROUP BY host ORDER BY n DESC`,
+			change: func(stmt *sp.SelectStatement) { stmt.Fields[0].Alias = "" },
+			err:    `invalid ORDER BY n, expected a dimension or metric at 1:55`,
+		},
+		{
+			s:      `SELECT * FROM logs WHERE status IN (500, 502)`,
+			change: func(stmt *sp.SelectStatement) { stmt.Condition.(*sp.BinaryExpr).RHS = &sp.IntegerLiteral{Val: 500} },
+			err:    `invalid filter, IN requires a list at 1:1`,
+		},
+	}
+	for i, tt := range tests {
+		stmt, err := sp.ParseSelectStatement(tt.s)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		tt.change(stmt)
+		if err := sp.Validate(stmt); errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		}
+	}
+}
+
+// Ensure a select statement can be parsed and inspected without type assertion.
+func TestParseSelectStatement(t *testing.T) {
+	var tests = []struct {
+		s        string
+		fields   string
+		sources  []string
+		index    string
+		limit    int
+		count    bool
+		warnings []string
+		names    []string
+		err      string
+	}{
+		{s: `SELECT host, count(*) FROM logs WHERE status = 500 GROUP BY host LIMIT 10`, fields: `host, count(*)`, sources: []string{"logs"}, index: "logs", limit: 10, names: []string{"host", "status"}},
+		{s: `SELECT * FROM symbol`, fields: `*`, sources: []string{"symbol"}, index: "symbol"},
+		{s: "SELECT * FROM `from`, logs_2024", fields: `*`, sources: []string{"from", "logs_2024"}, index: "from,logs_2024"},
+		{s: `SELECT a - b FROM logs-2024.*, *-metrics WHERE a - 1 > 2`, fields: `a - b`, sources: []string{"logs-2024.*", "*-metrics"}, index: "logs-2024.*,*-metrics", names: []string{"a", "b"}},
+		{s: `SELECT count(*) FROM logs WHERE status = 500`, fields: `count(*)`, sources: []string{"logs"}, index: "logs", count: true, names: []string{"status"}},
+		{s: `SELECT count(*) FILTER (WHERE status = 500) FROM logs`, fields: `count(*) FILTER (WHERE status = 500)`, sources: []string{"logs"}, index: "logs", names: []string{"status"}},
+		{s: `SELECT count(status) FROM logs`, fields: `count(status)`, sources: []string{"logs"}, index: "logs", names: []string{"status"}},
+		{s: `SELECT max(bytes) AS m, top(1, ORDER BY ts DESC) FROM logs WHERE match(msg, 'err') GROUP BY host.name AS h HAVING m > 10 ORDER BY m DESC, h ASC`, fields: `max(bytes) AS m, top(1, ORDER BY ts DESC)`, sources: []string{"logs"}, index: "logs", names: []string{"bytes", "ts", "host.name", "msg"}},
+		{s: `SELECT ts, msg FROM logs ORDER BY ts DESC, _score ASC`, fields: `ts, msg`, sources: []string{"logs"}, index: "logs", names: []string{"ts", "msg"}},
+		{s: `SELECT * FROM logs WHERE referer = NULL OR NULL != agent`, fields: `*`, sources: []string{"logs"}, index: "logs", names: []string{"referer", "agent"}, warnings: []string{
+			"comparison of referer with NULL translated as referer IS NULL",
+			"comparison of agent with NULL translated as agent IS NOT NULL",
+		}},
+		{s: `SELECT host, count(*) FROM logs POST_FILTER(WHERE referer = NULL) GROUP BY host`, fields: `host, count(*)`, sources: []string{"logs"}, index: "logs", names: []string{"host", "referer"}, warnings: []string{
+			"comparison of referer with NULL translated as referer IS NULL",
+		}},
+		{s: `SELECT count(*) FILTER (WHERE agent != NULL) AS n FROM logs`, fields: `count(*) FILTER (WHERE agent != NULL) AS n`, sources: []string{"logs"}, index: "logs", names: []string{"agent"}, warnings: []string{
+			"comparison of agent with NULL translated as agent IS NOT NULL",
+		}},
+		{s: `CREATE`, err: `found CREATE, expected SELECT at 1:1`},
+	}
+	for i, tt := range tests {
+		stmt, err := sp.ParseSelectStatement(tt.s)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+			continue
+		} else if tt.err != "" {
+			continue
+		}
+
+		if fields := stmt.Fields.String(); fields != tt.fields {
+			t.Errorf("%d. %q: fields mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.fields, fields)
+		}
+		if sources := stmt.Sources.Names(); !reflect.DeepEqual(sources, tt.sources) {
+			t.Errorf("%d. %q: sources mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.s, tt.sources, sources)
+		}
+		if index := stmt.Index(); index != tt.index {
+			t.Errorf("%d. %q: index mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.index, index)
+		}
+		if count := stmt.IsCountQuery(); count != tt.count {
+			t.Errorf("%d. %q: count mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.s, tt.count, count)
+		}
+		if warnings := stmt.Diagnostics(); !reflect.DeepEqual(warnings, tt.warnings) {
+			t.Errorf("%d. %q: warnings mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.s, tt.warnings, warnings)
+		}
+		if names := stmt.NamesInStatement(); !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%d. %q: names mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.s, tt.names, names)
+		}
+		if stmt.Limit != tt.limit {
+			t.Errorf("%d. %q: limit mismatch:\n  exp=%d\n  got=%d\n\n", i, tt.s, tt.limit, stmt.Limit)
+		}
+		if stmt.String() != tt.s {
+			t.Errorf("%d. %q: string mismatch:\n  got=%s\n\n", i, tt.s, stmt.String())
+		}
+	}
+}
+
+// Ensure the statements combined with UNION are parsed in query order.
+func TestParseUnion(t *testing.T) {
+	var tests = []struct {
+		s     string
+		stmts []string
+		err   string
+	}{
+		{s: `SELECT * FROM logs`, stmts: []string{`SELECT * FROM logs`}},
+		{s: `SELECT a FROM logs WHERE a > 1 UNION SELECT b FROM metrics LIMIT 5`, stmts: []string{`SELECT a FROM logs WHERE a > 1`, `SELECT b FROM metrics LIMIT 5`}},
+		{s: `SELECT a FROM x UNION SELECT a FROM y UNION SELECT a FROM z`, stmts: []string{`SELECT a FROM x`, `SELECT a FROM y`, `SELECT a FROM z`}},
+		{s: `SELECT a FROM x UNION`, err: `found EOF, expected SELECT at 1:23`},
+		{s: `SELECT a FROM x UNION a FROM y`, err: `found a, expected SELECT at 1:23`},
+		{s: `SELECT a FROM x UNION SELECT FROM y`, err: `found FROM, expected identifier, string, number, bool at 1:30`},
+	}
+	for i, tt := range tests {
+		stmts, err := sp.ParseUnion(tt.s)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+			continue
+		}
+		var got []string
+		for _, stmt := range stmts {
+			got = append(got, stmt.String())
+		}
+		if !reflect.DeepEqual(got, tt.stmts) {
+			t.Errorf("%d. %q: statements mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.s, tt.stmts, got)
+		}
+	}
+}
+
+// Ensure the sources of a composite aggregation are named in order.
+func TestSelectStatement_CompositeSources(t *testing.T) {
+	var tests = []struct {
+		s       string
+		sources []string
+	}{
+		{s: `SELECT count(*) FROM logs GROUP BY host, status`},
+		{s: `SELECT count(*) FROM logs GROUP BY COMPOSITE host.name, date_histogram(ts, '1d') AS day`, sources: []string{"host.name", "day"}},
+		{s: `SELECT count(*) FROM logs GROUP BY COMPOSITE status, histogram(bytes, 100) AS size`, sources: []string{"status", "size"}},
+	}
+	for i, tt := range tests {
+		stmt, err := sp.ParseSelectStatement(tt.s)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		if sources := stmt.CompositeSources(); !reflect.DeepEqual(sources, tt.sources) {
+			t.Errorf("%d. %q: sources mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.s, tt.sources, sources)
+		}
+	}
+}
+
 // Ensure the parser can parse expressions into an AST.
 func TestParser_ParseExpr(t *testing.T) {
 	var tests = []struct {
@@ -473,6 +1479,128 @@ func TestParser_ParseExpr(t *testing.T) {
 		{s: `true`, expr: &sp.BooleanLiteral{Val: true}},
 		{s: `false`, expr: &sp.BooleanLiteral{Val: false}},
 		{s: `my_ident`, expr: &sp.VarRef{Val: "my_ident", Segments: []string{"my_ident"}}},
+		{s: `a.b.c`, expr: &sp.VarRef{Val: "a.b.c", Segments: []string{"a", "b", "c"}}},
+		{s: `a."b c".d`, expr: &sp.VarRef{Val: "a.b c.d", Segments: []string{"a", "b c", "d"}}},
+		{s: `a."select"`, expr: &sp.VarRef{Val: "a.select", Segments: []string{"a", "select"}}},
+		{s: "`my field`", expr: &sp.VarRef{Val: "my field", Segments: []string{"my field"}}},
+		{s: "`from`.`b``c`", expr: &sp.VarRef{Val: "from.b`c", Segments: []string{"from", "b`c"}}},
+		{s: `-5`, expr: &sp.IntegerLiteral{Val: -5}},
+		{s: `- 1.5`, expr: &sp.NumberLiteral{Val: -1.5}},
+		{s: `0xFF`, expr: &sp.IntegerLiteral{Val: 255}},
+		{s: `-0x10`, expr: &sp.IntegerLiteral{Val: -16}},
+		{s: `1_000_000`, expr: &sp.IntegerLiteral{Val: 1000000}},
+		{s: `100ms`, expr: &sp.DurationLiteral{Val: 100 * time.Millisecond}},
+		{s: `1h30m`, expr: &sp.DurationLiteral{Val: 90 * time.Minute}},
+		{s: `-2w`, expr: &sp.DurationLiteral{Val: -14 * 24 * time.Hour}},
+		// Unary minus binds to its operand only
+		{
+			s: `-a * 2`,
+			expr: &sp.BinaryExpr{
+				Op: sp.MUL,
+				LHS: &sp.ParenExpr{Expr: &sp.BinaryExpr{
+					Op:  sp.SUB,
+					LHS: &sp.IntegerLiteral{Val: 0},
+					RHS: &sp.VarRef{Val: "a", Segments: []string{"a"}},
+				}},
+				RHS: &sp.IntegerLiteral{Val: 2},
+			},
+		},
+		// Modulo binds like multiplication
+		{
+			s: `a % b + c`,
+			expr: &sp.BinaryExpr{
+				Op: sp.ADD,
+				LHS: &sp.BinaryExpr{
+					Op:  sp.MOD,
+					LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}},
+					RHS: &sp.VarRef{Val: "b", Segments: []string{"b"}},
+				},
+				RHS: &sp.VarRef{Val: "c", Segments: []string{"c"}},
+			},
+		},
+		{
+			s: `a + b % 2 * c`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.ADD,
+				LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}},
+				RHS: &sp.BinaryExpr{
+					Op: sp.MUL,
+					LHS: &sp.BinaryExpr{
+						Op:  sp.MOD,
+						LHS: &sp.VarRef{Val: "b", Segments: []string{"b"}},
+						RHS: &sp.IntegerLiteral{Val: 2},
+					},
+					RHS: &sp.VarRef{Val: "c", Segments: []string{"c"}},
+				},
+			},
+		},
+		// Bitwise operators bind below comparisons: & before ^ before |
+		{
+			s: `a | b ^ c & 4`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.BITOR,
+				LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}},
+				RHS: &sp.BinaryExpr{
+					Op:  sp.BITXOR,
+					LHS: &sp.VarRef{Val: "b", Segments: []string{"b"}},
+					RHS: &sp.BinaryExpr{
+						Op:  sp.BITAND,
+						LHS: &sp.VarRef{Val: "c", Segments: []string{"c"}},
+						RHS: &sp.IntegerLiteral{Val: 4},
+					},
+				},
+			},
+		},
+		{
+			s: `flags & 1 + 2 > 0`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.BITAND,
+				LHS: &sp.VarRef{Val: "flags", Segments: []string{"flags"}},
+				RHS: &sp.BinaryExpr{
+					Op: sp.GT,
+					LHS: &sp.BinaryExpr{
+						Op:  sp.ADD,
+						LHS: &sp.IntegerLiteral{Val: 1},
+						RHS: &sp.IntegerLiteral{Val: 2},
+					},
+					RHS: &sp.IntegerLiteral{Val: 0},
+				},
+			},
+		},
+		// Concatenation binds below arithmetic
+		{
+			s: `a || '-' || b + 1`,
+			expr: &sp.BinaryExpr{
+				Op: sp.CONCAT,
+				LHS: &sp.BinaryExpr{
+					Op:  sp.CONCAT,
+					LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}},
+					RHS: &sp.StringLiteral{Val: "-"},
+				},
+				RHS: &sp.BinaryExpr{
+					Op:  sp.ADD,
+					LHS: &sp.VarRef{Val: "b", Segments: []string{"b"}},
+					RHS: &sp.IntegerLiteral{Val: 1},
+				},
+			},
+		},
+		// Minus between operands is a subtraction
+		{
+			s: `a -5`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.SUB,
+				LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}},
+				RHS: &sp.IntegerLiteral{Val: 5},
+			},
+		},
+		{
+			s: `temp > -5`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.GT,
+				LHS: &sp.VarRef{Val: "temp", Segments: []string{"temp"}},
+				RHS: &sp.IntegerLiteral{Val: -5},
+			},
+		},
 		// Simple binary expression
 		{
 			s: `1 + 2`,
@@ -587,6 +1715,117 @@ func TestParser_ParseExpr(t *testing.T) {
 			},
 		},
 
+		// Binary expression with LIKE.
+		{
+			s: `name LIKE 'AAP%'`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.LIKE,
+				LHS: &sp.VarRef{Val: "name", Segments: []string{"name"}},
+				RHS: &sp.StringLiteral{Val: "AAP%"},
+			},
+		},
+
+		// Binary expression with NOT LIKE.
+		{
+			s: `name not like 'AAP_'`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.NLIKE,
+				LHS: &sp.VarRef{Val: "name", Segments: []string{"name"}},
+				RHS: &sp.StringLiteral{Val: "AAP_"},
+			},
+		},
+		{s: `name NOT 'AAP%'`, err: `found AAP%, expected LIKE, IN, BETWEEN at 1:9`},
+
+		// NOT negates the whole comparison and binds tighter than AND.
+		{
+			s: `NOT a = 1 AND b + 1 > 2`,
+			expr: &sp.BinaryExpr{
+				Op: sp.AND,
+				LHS: &sp.NotExpr{
+					Expr: &sp.BinaryExpr{Op: sp.EQ, LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}}, RHS: &sp.IntegerLiteral{Val: 1}},
+				},
+				RHS: &sp.BinaryExpr{
+					Op:  sp.GT,
+					LHS: &sp.BinaryExpr{Op: sp.ADD, LHS: &sp.VarRef{Val: "b", Segments: []string{"b"}}, RHS: &sp.IntegerLiteral{Val: 1}},
+					RHS: &sp.IntegerLiteral{Val: 2},
+				},
+			},
+		},
+		{
+			s: `a = 1 OR NOT b LIKE 'x%'`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.OR,
+				LHS: &sp.BinaryExpr{Op: sp.EQ, LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}}, RHS: &sp.IntegerLiteral{Val: 1}},
+				RHS: &sp.NotExpr{
+					Expr: &sp.BinaryExpr{Op: sp.LIKE, LHS: &sp.VarRef{Val: "b", Segments: []string{"b"}}, RHS: &sp.StringLiteral{Val: "x%"}},
+				},
+			},
+		},
+
+		// BETWEEN range predicate binds tighter than AND.
+		{
+			s: `price BETWEEN 10 AND 20.5 AND day NOT BETWEEN '2017-01-01' AND '2017-02-01'`,
+			expr: &sp.BinaryExpr{
+				Op: sp.AND,
+				LHS: &sp.BetweenExpr{
+					Expr:  &sp.VarRef{Val: "price", Segments: []string{"price"}},
+					Lower: &sp.IntegerLiteral{Val: 10},
+					Upper: &sp.NumberLiteral{Val: 20.5},
+				},
+				RHS: &sp.BetweenExpr{
+					Expr:  &sp.VarRef{Val: "day", Segments: []string{"day"}},
+					Lower: &sp.StringLiteral{Val: "2017-01-01"},
+					Upper: &sp.StringLiteral{Val: "2017-02-01"},
+					Not:   true,
+				},
+			},
+		},
+		{s: `price BETWEEN 10 20`, err: `found 20, expected AND at 1:18`},
+
+		// IS NULL and IS NOT NULL predicates.
+		{
+			s: `host IS NULL OR host is not null`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.OR,
+				LHS: &sp.IsNullExpr{Expr: &sp.VarRef{Val: "host", Segments: []string{"host"}}},
+				RHS: &sp.IsNullExpr{Expr: &sp.VarRef{Val: "host", Segments: []string{"host"}}, Not: true},
+			},
+		},
+		{s: `host IS 1`, err: `found 1, expected NULL, NOT NULL at 1:9`},
+		{s: `host IS NOT 1`, err: `found 1, expected NULL at 1:13`},
+
+		// Comparison with the NULL literal.
+		{
+			s: `host = NULL`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.EQ,
+				LHS: &sp.VarRef{Val: "host", Segments: []string{"host"}},
+				RHS: &sp.NullLiteral{},
+			},
+		},
+
+		// Binary expression with IN list.
+		{
+			s: `status IN (200, 404, 500)`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.IN,
+				LHS: &sp.VarRef{Val: "status", Segments: []string{"status"}},
+				RHS: &sp.ListLiteral{Vals: []interface{}{int64(200), int64(404), int64(500)}},
+			},
+		},
+
+		// Binary expression with NOT IN list.
+		{
+			s: `host NOT IN ('a', 'b')`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.NI,
+				LHS: &sp.VarRef{Val: "host", Segments: []string{"host"}},
+				RHS: &sp.ListLiteral{Vals: []interface{}{"a", "b"}},
+			},
+		},
+		{s: `status IN (200, 404`, err: `found EOF, expected ) at 1:20`},
+		{s: `status IN 200`, err: `found 200, expected (, [ at 1:11`},
+
 		// Function call (empty)
 		{
 			s: `my_func()`,
@@ -614,6 +1853,9 @@ func TestParser_ParseExpr(t *testing.T) {
 
 	for i, tt := range tests {
 		expr, err := sp.NewParser(strings.NewReader(tt.s)).ParseExpr()
+		if err == nil {
+			sp.ClearPos(expr)
+		}
 		if !reflect.DeepEqual(tt.err, errstring(err)) {
 			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
 		} else if tt.err == "" && !reflect.DeepEqual(tt.expr, expr) {
@@ -622,6 +1864,193 @@ func TestParser_ParseExpr(t *testing.T) {
 	}
 }
 
+// Ensure deeply nested expressions are rejected instead of exhausting the stack.
+func TestParser_MaxDepth(t *testing.T) {
+	nested := func(depth int) string {
+		return `SELECT * FROM logs WHERE ` + strings.Repeat("(", depth) + `a = 1` + strings.Repeat(")", depth)
+	}
+
+	_, err := sp.NewParser(strings.NewReader(nested(1000))).ParseStatement()
+	if exp := `invalid expression, exceeded max depth of 100 at 1:126`; err == nil || err.Error() != exp {
+		t.Errorf("error mismatch:\n  exp=%s\n  got=%v", exp, err)
+	}
+
+	if _, err := sp.NewParser(strings.NewReader(nested(99))).ParseStatement(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	p := sp.NewParser(strings.NewReader(nested(1000)))
+	p.MaxDepth = 0
+	if _, err := p.ParseStatement(); err != nil {
+		t.Errorf("unexpected error without a max depth: %s", err)
+	}
+
+	p = sp.NewParser(strings.NewReader(nested(3)))
+	p.MaxDepth = 3
+	if _, err := p.ParseStatement(); err == nil {
+		t.Errorf("expected an error above a max depth of 3")
+	}
+}
+
+// Ensure the parser records where each expression starts.
+func TestParser_ParseExpr_Pos(t *testing.T) {
+	expr, err := sp.ParseExpr("a > 1 AND\n  b IN (1, 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	and := expr.(*sp.BinaryExpr)
+	gt := and.LHS.(*sp.BinaryExpr)
+	in := and.RHS.(*sp.BinaryExpr)
+
+	for i, tt := range []struct {
+		expr sp.Expr
+		pos  sp.Pos
+	}{
+		{expr: and, pos: sp.Pos{Line: 0, Char: 0}},
+		{expr: gt.LHS, pos: sp.Pos{Line: 0, Char: 0}},
+		{expr: gt.RHS, pos: sp.Pos{Line: 0, Char: 4, Offset: 4}},
+		{expr: in, pos: sp.Pos{Line: 1, Char: 2, Offset: 12}},
+		{expr: in.RHS, pos: sp.Pos{Line: 1, Char: 7, Offset: 17}},
+	} {
+		if pos := tt.expr.Pos(); pos != tt.pos {
+			t.Errorf("%d. %s: pos mismatch: exp=%v got=%v", i, tt.expr, tt.pos, pos)
+		}
+	}
+}
+
+// Ensure a standalone expression string can be parsed.
+func TestParseExpr(t *testing.T) {
+	var tests = []struct {
+		s    string
+		expr sp.Expr
+		err  string
+	}{
+		{
+			s: `a = 1 AND b > 2`,
+			expr: &sp.BinaryExpr{
+				Op:  sp.AND,
+				LHS: &sp.BinaryExpr{Op: sp.EQ, LHS: &sp.VarRef{Val: "a", Segments: []string{"a"}}, RHS: &sp.IntegerLiteral{Val: 1}},
+				RHS: &sp.BinaryExpr{Op: sp.GT, LHS: &sp.VarRef{Val: "b", Segments: []string{"b"}}, RHS: &sp.IntegerLiteral{Val: 2}},
+			},
+		},
+		{s: `host IN ('a', 'b')`, expr: &sp.BinaryExpr{Op: sp.IN, LHS: &sp.VarRef{Val: "host", Segments: []string{"host"}}, RHS: &sp.ListLiteral{Vals: []interface{}{"a", "b"}}}},
+		{s: `a = 1 b`, err: `found b, expected EOF at 1:7`},
+		{s: `a =`, err: `found EOF, expected identifier, string, number, bool at 1:4`},
+	}
+	for i, tt := range tests {
+		expr, err := sp.ParseExpr(tt.s)
+		if err == nil {
+			sp.ClearPos(expr)
+		}
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		} else if tt.err == "" && !reflect.DeepEqual(tt.expr, expr) {
+			t.Errorf("%d. %q\n\nexpr mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.s, tt.expr, expr)
+		}
+	}
+}
+
+// Ensure expressions are formatted as canonical SQL which parses back to the
+// same tree.
+func TestParseExpr_String(t *testing.T) {
+	var tests = []struct {
+		s   string
+		str string
+	}{
+		{s: `a = 1 AND (b > 2 OR c < 3)`, str: `a = 1 AND (b > 2 OR c < 3)`},
+		{s: `a + b * c - 1`, str: `a + b * c - 1`},
+		{s: `x = 1.23456789`, str: `x = 1.23456789`},
+		{s: `x >= 2.0`, str: `x >= 2.0`},
+		{s: `x < 1e-7`, str: `x < 1e-07`},
+		{s: `x NOT IN (1.5, 2)`, str: `x NOT IN (1.5, 2)`},
+		{s: `host IN ("a", 'b')`, str: `host IN ('a', 'b')`},
+		{s: `msg = 'it\'s'`, str: `msg = 'it\'s'`},
+		{s: `msg = 'a\tb\rc\fd\be\0f\ng'`, str: `msg = 'a\tb\rc\fd\be\0f\ng'`},
+		{s: `msg = "\t"`, str: `msg = '\t'`},
+		{s: `ok = TRUE`, str: `ok = true`},
+		{s: `ts > now() - 1h`, str: `ts > now() - 1h`},
+		{s: `sum(bytes) / count(DISTINCT host)`, str: `sum(bytes) / count(distinct(host))`},
+		{s: `match(msg, 'err')`, str: `match(msg, 'err')`},
+		{s: `host.name =~ /^web\d+/`, str: `host.name =~ /^web\d+/`},
+		{s: "`my field` = 1 AND `select`.`a``b` = 2", str: "`my field` = 1 AND `select`.`a``b` = 2"},
+		{s: `a NOT BETWEEN 1 AND 5`, str: `a NOT BETWEEN 1 AND 5`},
+		{s: `a IS NOT NULL`, str: `a IS NOT NULL`},
+		{s: `NOT (a = 1)`, str: `NOT (a = 1)`},
+		{s: `CASE WHEN a > 1 THEN 'x' ELSE 'y' END`, str: `CASE WHEN a > 1 THEN 'x' ELSE 'y' END`},
+	}
+	for i, tt := range tests {
+		expr := MustParseExpr(tt.s)
+		if str := expr.String(); str != tt.str {
+			t.Errorf("%d. %q: string mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.str, str)
+			continue
+		}
+		other := MustParseExpr(expr.String())
+		sp.ClearPos(expr)
+		sp.ClearPos(other)
+		if !reflect.DeepEqual(expr, other) {
+			t.Errorf("%d. %q\n\nexpr mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.s, expr, other)
+		}
+	}
+}
+
+// Ensure a time duration can be parsed.
+func TestParseDuration(t *testing.T) {
+	var tests = []struct {
+		s   string
+		d   time.Duration
+		err string
+	}{
+		{s: `10ns`, d: 10},
+		{s: `10us`, d: 10 * time.Microsecond},
+		{s: `15ms`, d: 15 * time.Millisecond},
+		{s: `100s`, d: 100 * time.Second},
+		{s: `2m`, d: 2 * time.Minute},
+		{s: `2h`, d: 2 * time.Hour},
+		{s: `2d`, d: 2 * 24 * time.Hour},
+		{s: `2w`, d: 2 * 7 * 24 * time.Hour},
+		{s: `1h30m`, d: 90 * time.Minute},
+
+		{s: ``, err: "invalid duration"},
+		{s: `3`, err: "invalid duration"},
+		{s: `h`, err: "invalid duration"},
+		{s: `1h30`, err: "invalid duration"},
+		{s: `3.2m`, err: "invalid duration"},
+	}
+
+	for i, tt := range tests {
+		d, err := sp.ParseDuration(tt.s)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		} else if d != tt.d {
+			t.Errorf("%d. %q\n\nduration mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.s, tt.d, d)
+		}
+	}
+}
+
+// Ensure a time duration can be formatted.
+func TestFormatDuration(t *testing.T) {
+	var tests = []struct {
+		d time.Duration
+		s string
+	}{
+		{d: 3 * time.Microsecond, s: `3us`},
+		{d: 1001 * time.Microsecond, s: `1001us`},
+		{d: 15 * time.Millisecond, s: `15ms`},
+		{d: 100 * time.Second, s: `100s`},
+		{d: 90 * time.Minute, s: `90m`},
+		{d: 2 * time.Hour, s: `2h`},
+		{d: 2 * 24 * time.Hour, s: `2d`},
+		{d: 2 * 7 * 24 * time.Hour, s: `2w`},
+	}
+
+	for i, tt := range tests {
+		s := sp.FormatDuration(tt.d)
+		if s != tt.s {
+			t.Errorf("%d. %v: mismatch: %s != %s", i, tt.d, tt.s, s)
+		}
+	}
+}
+
 // Ensure a string can be quoted.
 func TestQuote(t *testing.T) {
 	for i, tt := range []struct {
@@ -668,7 +2097,7 @@ func MustParseSelectStatement(s string) *sp.SelectStatement {
 	if err != nil {
 		panic(err)
 	}
-	return stmt.(*sp.SelectStatement)
+	return stmt
 }
 
 // MustParseExpr parses an expression. Panic on error.
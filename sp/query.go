@@ -0,0 +1,396 @@
+package sp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// fullTextQueries are the functions ranking the documents by relevance.
+var fullTextQueries = map[string]bool{
+	"match":        true,
+	"match_phrase": true,
+	"multi_match":  true,
+	"query_string": true,
+}
+
+// isFullText reports if expr holds a full-text query.
+func isFullText(expr Expr) bool {
+	var found bool
+	WalkFunc(expr, func(n Node) {
+		if c, ok := n.(*Call); ok && fullTextQueries[c.Name] {
+			found = true
+		}
+	})
+	return found
+}
+
+// whereQuery translates the WHERE condition into the clauses of the top bool
+// query. The predicates of the condition holding a full-text query are scored
+// under must, the others are cached under filter. force puts every predicate
+// under filter, full-text queries then match without computing a score.
+// filters are appended to the filter clauses of the condition, cond may be nil.
+func whereQuery(cond Expr, force bool, filters ...map[string]interface{}) (map[string]interface{}, error) {
+	var must, filter []map[string]interface{}
+	var operands []Expr
+	if cond != nil {
+		operands = logicalOperands(AND, cond)
+	}
+	for _, operand := range operands {
+		q, err := conditionQuery(operand)
+		if err != nil {
+			return nil, err
+		}
+		if !force && isFullText(operand) {
+			must = append(must, q)
+		} else {
+			filter = append(filter, q)
+		}
+	}
+	filter = append(filter, filters...)
+
+	clauses := make(map[string]interface{})
+	if len(must) > 0 {
+		clauses["must"] = must
+	}
+	switch len(filter) {
+	case 0:
+	case 1:
+		clauses["filter"] = filter[0]
+	default:
+		clauses["filter"] = boolQuery("must", filter...)
+	}
+	return clauses, nil
+}
+
+// conditionQuery translates a WHERE expression into an es query clause.
+// Logical operators are translated into bool queries following the grouping
+// of the expression, comparisons without a structured es equivalent fall back
+// to a script filter.
+func conditionQuery(expr Expr) (map[string]interface{}, error) {
+	switch expr := expr.(type) {
+	case *ParenExpr:
+		return conditionQuery(expr.Expr)
+	case *BinaryExpr:
+		switch expr.Op {
+		case AND, OR:
+			operands := logicalOperands(expr.Op, expr)
+			clauses := make([]map[string]interface{}, 0, len(operands))
+			for _, operand := range operands {
+				q, err := conditionQuery(operand)
+				if err != nil {
+					return nil, err
+				}
+				clauses = append(clauses, q)
+			}
+			if expr.Op == OR {
+				q := boolQuery("should", clauses...)
+				q["bool"].(map[string]interface{})["minimum_should_match"] = 1
+				return q, nil
+			}
+			return boolQuery("must", clauses...), nil
+		case EQ, NEQ:
+			// field = NULL is never true in SQL, read it as field IS NULL
+			if field := nullComparison(expr); field != nil {
+				return conditionQuery(&IsNullExpr{Expr: field, Not: expr.Op == NEQ})
+			}
+		case GT, GTE, LT, LTE:
+			if q, ok := rangeQuery(expr); ok {
+				return q, nil
+			}
+		case LIKE, NLIKE:
+			q := map[string]interface{}{
+				"wildcard": map[string]interface{}{
+					fieldName(expr.LHS): likeToWildcard(expr.RHS.(*StringLiteral).Val),
+				},
+			}
+			if expr.Op == NLIKE {
+				return boolQuery("must_not", q), nil
+			}
+			return q, nil
+		case IN, NI:
+			list := expr.RHS.(*ListLiteral)
+			if len(list.Vals) == 0 {
+				msg := fmt.Sprintf("invalid filter, empty list for %s", expr.Op.String())
+				return nil, &ParseError{Message: msg, Pos: list.Pos()}
+			}
+			q := map[string]interface{}{
+				"terms": map[string]interface{}{
+					fieldName(expr.LHS): list.Vals,
+				},
+			}
+			if expr.Op == NI {
+				return boolQuery("must_not", q), nil
+			}
+			return q, nil
+		}
+	case *Call:
+		return callQuery(expr), nil
+	case *NotExpr:
+		q, err := conditionQuery(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return boolQuery("must_not", q), nil
+	case *BetweenExpr:
+		q := map[string]interface{}{
+			"range": map[string]interface{}{
+				fieldName(expr.Expr): map[string]interface{}{
+					"gte": literalValue(expr.Lower),
+					"lte": literalValue(expr.Upper),
+				},
+			},
+		}
+		if expr.Not {
+			return boolQuery("must_not", q), nil
+		}
+		return q, nil
+	case *IsNullExpr:
+		q := map[string]interface{}{
+			"exists": map[string]interface{}{"field": fieldName(expr.Expr)},
+		}
+		if !expr.Not {
+			return boolQuery("must_not", q), nil
+		}
+		return q, nil
+	}
+	return scriptQuery(expr), nil
+}
+
+// rangeOperators maps comparison operators to their range query parameter.
+var rangeOperators = map[Token]string{GT: "gt", GTE: "gte", LT: "lt", LTE: "lte"}
+
+// flippedOperators maps comparison operators to the operator obtained by
+// swapping their operands.
+var flippedOperators = map[Token]Token{GT: LT, GTE: LTE, LT: GT, LTE: GTE}
+
+// rangeQuery translates a comparison between a field and a literal into a
+// range query, the literal may be on either side of the operator.
+// It returns false when the comparison has no range equivalent.
+func rangeQuery(expr *BinaryExpr) (map[string]interface{}, bool) {
+	op, lhs, rhs := expr.Op, expr.LHS, expr.RHS
+	if _, ok := lhs.(*VarRef); !ok {
+		op, lhs, rhs = flippedOperators[op], rhs, lhs
+	}
+	if _, ok := lhs.(*VarRef); !ok {
+		return nil, false
+	}
+	var value interface{}
+	switch rhs.(type) {
+	case *IntegerLiteral, *NumberLiteral, *StringLiteral:
+		value = literalValue(rhs)
+	default:
+		if !isTimeExpr(rhs) {
+			return nil, false
+		}
+		value = dateMath(rhs)
+	}
+	return map[string]interface{}{
+		"range": map[string]interface{}{
+			fieldName(lhs): map[string]interface{}{
+				rangeOperators[op]: value,
+			},
+		},
+	}, true
+}
+
+// dateMath returns the es date math of a time expression, e.g. "now-24h".
+// Durations are written in fixed hours, minutes or seconds since es rounds
+// calendar units such as days to the time zone.
+func dateMath(expr Expr) string {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		d := expr.RHS.(*DurationLiteral).Val
+		unit := "s"
+		switch {
+		case d%time.Hour == 0:
+			d, unit = d/time.Hour, "h"
+		case d%time.Minute == 0:
+			d, unit = d/time.Minute, "m"
+		default:
+			d /= time.Second
+		}
+		op := "+"
+		if (expr.Op == SUB) != (d < 0) {
+			op = "-"
+		}
+		if d < 0 {
+			d = -d
+		}
+		return fmt.Sprintf("%s%s%d%s", dateMath(expr.LHS), op, d, unit)
+	}
+	return "now"
+}
+
+// callQuery returns the full text query of a query function call,
+// the arguments are checked by validateQueryArgs.
+func callQuery(c *Call) map[string]interface{} {
+	switch c.Name {
+	case "prefix":
+		params := map[string]interface{}{"value": c.Args[1].(*StringLiteral).Val}
+		if len(c.Args) == 3 {
+			params["case_insensitive"] = c.Args[2].(*BooleanLiteral).Val
+		}
+		return map[string]interface{}{
+			"prefix": map[string]interface{}{fieldName(c.Args[0]): params},
+		}
+	case "fuzzy":
+		params := map[string]interface{}{"value": c.Args[1].(*StringLiteral).Val, "fuzziness": "AUTO"}
+		if len(c.Args) == 3 {
+			params["fuzziness"] = fuzziness(c.Args[2])
+		}
+		return map[string]interface{}{
+			"fuzzy": map[string]interface{}{fieldName(c.Args[0]): params},
+		}
+	case "terms_lookup":
+		lookup := map[string]interface{}{
+			"index": c.Args[1].(*StringLiteral).Val,
+			"id":    fmt.Sprint(literalValue(c.Args[2])),
+			"path":  c.Args[3].(*StringLiteral).Val,
+		}
+		return map[string]interface{}{
+			"terms": map[string]interface{}{fieldName(c.Args[0]): lookup},
+		}
+	case "exists":
+		return map[string]interface{}{
+			"exists": map[string]interface{}{"field": fieldName(c.Args[0])},
+		}
+	case "query_string":
+		return map[string]interface{}{
+			"query_string": map[string]interface{}{"query": c.Args[0].(*StringLiteral).Val},
+		}
+	case "multi_match":
+		params := map[string]interface{}{"query": c.Args[0].(*StringLiteral).Val}
+		fields := make([]string, 0, len(c.Args)-1)
+		for _, arg := range c.Args[1:] {
+			if typ, ok := arg.(*StringLiteral); ok {
+				params["type"] = typ.Val
+				continue
+			}
+			fields = append(fields, fieldName(arg))
+		}
+		params["fields"] = fields
+		return map[string]interface{}{"multi_match": params}
+	case "match":
+		params := map[string]interface{}{"query": c.Args[1].(*StringLiteral).Val}
+		if len(c.Args) == 3 {
+			params["operator"] = strings.ToLower(c.Args[2].(*StringLiteral).Val)
+		}
+		return map[string]interface{}{
+			"match": map[string]interface{}{fieldName(c.Args[0]): params},
+		}
+	case "geo_distance":
+		return map[string]interface{}{
+			"geo_distance": map[string]interface{}{
+				"distance": c.Args[3].(*StringLiteral).Val,
+				fieldName(c.Args[0]): map[string]interface{}{
+					"lat": literalValue(c.Args[1]),
+					"lon": literalValue(c.Args[2]),
+				},
+			},
+		}
+	case "geo_bounding_box":
+		return map[string]interface{}{
+			"geo_bounding_box": map[string]interface{}{
+				fieldName(c.Args[0]): map[string]interface{}{
+					"top_left":     map[string]interface{}{"lat": literalValue(c.Args[1]), "lon": literalValue(c.Args[2])},
+					"bottom_right": map[string]interface{}{"lat": literalValue(c.Args[3]), "lon": literalValue(c.Args[4])},
+				},
+			},
+		}
+	case "match_phrase":
+		params := map[string]interface{}{"query": c.Args[1].(*StringLiteral).Val}
+		if len(c.Args) == 3 {
+			params["slop"] = c.Args[2].(*IntegerLiteral).Val
+		}
+		return map[string]interface{}{
+			"match_phrase": map[string]interface{}{fieldName(c.Args[0]): params},
+		}
+	}
+	return scriptQuery(c)
+}
+
+// logicalOperands returns the operands of a chain of the logical operator op,
+// so `a AND (b AND c)` is translated into a single bool query.
+func logicalOperands(op Token, expr Expr) []Expr {
+	switch expr := expr.(type) {
+	case *ParenExpr:
+		return logicalOperands(op, expr.Expr)
+	case *BinaryExpr:
+		if expr.Op == op {
+			return append(logicalOperands(op, expr.LHS), logicalOperands(op, expr.RHS)...)
+		}
+	}
+	return []Expr{expr}
+}
+
+// scriptQuery returns a script filter evaluating expr.
+func scriptQuery(expr Expr) map[string]interface{} {
+	return map[string]interface{}{
+		"script": map[string]interface{}{"script": painlessString(expr)},
+	}
+}
+
+// boolQuery returns a bool query holding clauses under the given occurrence type.
+func boolQuery(occur string, clauses ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"bool": map[string]interface{}{occur: clauses},
+	}
+}
+
+// literalValue returns the go value held by a literal expression.
+func literalValue(expr Expr) interface{} {
+	switch expr := expr.(type) {
+	case *IntegerLiteral:
+		return expr.Val
+	case *NumberLiteral:
+		return expr.Val
+	case *StringLiteral:
+		return expr.Val
+	case *BooleanLiteral:
+		return expr.Val
+	}
+	return expr.String()
+}
+
+// likeToWildcard converts a LIKE pattern into an es wildcard pattern.
+// '%' matches any sequence and '_' a single character, unless escaped with a
+// backslash. Wildcard metacharacters in the pattern are matched literally.
+func likeToWildcard(pattern string) string {
+	var buf bytes.Buffer
+	escaped := false
+	for _, ch := range pattern {
+		if escaped {
+			escaped = false
+			switch ch {
+			case '%', '_':
+				_, _ = buf.WriteRune(ch)
+				continue
+			case '\\':
+				_, _ = buf.WriteString(`\\`)
+				continue
+			}
+			// A lone backslash is matched literally.
+			_, _ = buf.WriteString(`\\`)
+		}
+
+		switch ch {
+		case '\\':
+			escaped = true
+		case '%':
+			_, _ = buf.WriteRune('*')
+		case '_':
+			_, _ = buf.WriteRune('?')
+		case '*', '?':
+			_, _ = buf.WriteRune('\\')
+			_, _ = buf.WriteRune(ch)
+		default:
+			_, _ = buf.WriteRune(ch)
+		}
+	}
+	if escaped {
+		_, _ = buf.WriteString(`\\`)
+	}
+	return buf.String()
+}
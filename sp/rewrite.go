@@ -1,6 +1,9 @@
 package sp
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // GroovyWrapped ...
 func (tok Token) GroovyWrapped() string {
@@ -24,6 +27,21 @@ func (r *VarRef) GroovyWrapped() string {
 	return fmt.Sprintf("doc['%s'].value", qsReplacer.Replace(r.Val))
 }
 
+// painlessString returns expr as written in a script, the logical and
+// equality operators are spelled the painless way. String is left as sql so
+// the rewritten statement can still be printed and parsed again.
+func painlessString(expr Expr) string {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		return fmt.Sprintf("%s %s %s", painlessString(expr.LHS), expr.Op.GroovyWrapped(), painlessString(expr.RHS))
+	case *ParenExpr:
+		return fmt.Sprintf("(%s)", painlessString(expr.Expr))
+	case *NotExpr:
+		return fmt.Sprintf("NOT %s", painlessString(expr.Expr))
+	}
+	return expr.String()
+}
+
 //RewriteConditions ...
 func (s *SelectStatement) RewriteConditions() {
 
@@ -33,12 +51,14 @@ func (s *SelectStatement) RewriteConditions() {
 		switch expr := n.(type) {
 		case *VarRef:
 			expr.Val = expr.GroovyWrapped()
-		case *BinaryExpr:
-			tokens[expr.Op] = expr.Op.GroovyWrapped()
 		}
 		return
 	}
 	WalkFunc(s.Condition, rewrite)
+	WalkFunc(s.PostFilter, rewrite)
+	for _, f := range s.Fields {
+		WalkFunc(f.Filter, rewrite)
+	}
 }
 
 //RewriteMetricArgs ...
@@ -82,16 +102,51 @@ func (s *SelectStatement) RewriteDimensions() {
 	}
 }
 
-//RewriteHaving ...
-func (s *SelectStatement) RewriteHaving() {
-	// Rewrite all variable references in the fields with their types if one
-	// hasn't been specified.
-	rewrite := func(n Node) {
-		switch expr := n.(type) {
-		case *BinaryExpr:
-			tokens[expr.Op] = expr.Op.GroovyWrapped()
+// rewriteSourceAliases strips the source alias prefix of the variable
+// references and sort fields, es is not aware of the aliases.
+func (s *SelectStatement) rewriteSourceAliases() {
+	aliases := make(map[string]struct{})
+	for _, src := range s.Sources {
+		if m, ok := src.(*Measurement); ok && m.Alias != "" {
+			aliases[m.Alias] = struct{}{}
 		}
+	}
+	if len(aliases) == 0 {
 		return
 	}
+
+	rewrite := func(n Node) {
+		if ref, ok := n.(*VarRef); ok && len(ref.Segments) > 1 {
+			if _, ok := aliases[ref.Segments[0]]; ok {
+				ref.Segments = ref.Segments[1:]
+				ref.Val = strings.Join(ref.Segments, ".")
+			}
+		}
+	}
+	WalkFunc(s.Fields, rewrite)
+	WalkFunc(s.Dimensions, rewrite)
+	WalkFunc(s.Condition, rewrite)
+	WalkFunc(s.PostFilter, rewrite)
 	WalkFunc(s.Having, rewrite)
+
+	// The clauses naming fields by string are rewritten the same way.
+	unalias := func(name string) string {
+		if i := strings.Index(name, "."); i > 0 {
+			if _, ok := aliases[name[:i]]; ok {
+				return name[i+1:]
+			}
+		}
+		return name
+	}
+	for _, sf := range s.SortFields {
+		sf.Name = unalias(sf.Name)
+	}
+	if c := s.Collapse; c != nil {
+		c.Field = unalias(c.Field)
+	}
+	if h := s.Highlight; h != nil {
+		for i, f := range h.Fields {
+			h.Fields[i] = unalias(f)
+		}
+	}
 }
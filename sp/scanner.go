@@ -6,11 +6,55 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // Scanner represents a lexical scanner for InfluxQL.
 type Scanner struct {
 	r *reader
+
+	// SkipComments makes the scanner treat comments as whitespace, they are
+	// merged into the surrounding WS token instead of returned as COMMENT.
+	SkipComments bool
+
+	// IdentPaths makes the scanner read a dotted identifier path such as
+	// user.address.city or a."b c".d as a single IDENT, the literal joins the
+	// unquoted segments with dots. Otherwise each segment is its own token.
+	IdentPaths bool
+
+	// SourcePatterns makes the scanner read index patterns such as
+	// logs-2024.* as a single IDENT, identifiers may then start with '*' and
+	// contain '*', '-' and '.'. It is only meant for the FROM source position.
+	SourcePatterns bool
+
+	// RawWhitespace makes WS and COMMENT literals keep the line breaks as
+	// written, "\r\n" and "\r" are otherwise read as "\n". Positions count
+	// them as a single line break either way.
+	RawWhitespace bool
+
+	// Hints holds the lower cased names read from "/*+ ... */" hint comments,
+	// e.g. /*+ track_total_hits */.
+	Hints []string
+
+	// quote holds the quote character of the last scanned string.
+	quote rune
+
+	// lit is reused to build the literals of whitespace and identifiers,
+	// the most frequent tokens, so scanning doesn't allocate a new buffer
+	// for each of them.
+	lit bytes.Buffer
+
+	// last holds the last scanned token, it is returned again by Scan
+	// after a call to Unscan.
+	last struct {
+		tok Token
+		pos Pos
+		lit string
+	}
+	unscanned bool
 }
 
 // NewScanner returns a new instance of Scanner.
@@ -18,10 +62,96 @@ func NewScanner(r io.Reader) *Scanner {
 	return &Scanner{r: &reader{r: bufio.NewReader(r)}}
 }
 
+// Reset discards the state of the scanner and makes it read from r, the
+// position starts again at zero. The read buffer is reused so a scanner
+// can be pooled instead of allocated for each query. Options such as
+// SkipComments are kept, hints read so far are dropped.
+func (s *Scanner) Reset(r io.Reader) {
+	if s.r == nil {
+		s.r = &reader{}
+	}
+	br, ok := s.r.r.(*bufio.Reader)
+	if ok {
+		br.Reset(r)
+	} else {
+		br = bufio.NewReader(r)
+	}
+	*s.r = reader{r: br}
+
+	// Hints may have been handed over to a statement, don't reuse them.
+	s.Hints = nil
+	s.quote = 0
+	s.last.tok, s.last.pos, s.last.lit = ILLEGAL, Pos{}, ""
+	s.unscanned = false
+	s.lit.Reset()
+}
+
 // Scan returns the next token and position from the underlying reader.
 // Also returns the literal text read for strings, numbers, and duration tokens
 // since these token types can have different literal representations.
 func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
+	if s.unscanned {
+		s.unscanned = false
+		return s.last.tok, s.last.pos, s.last.lit
+	}
+
+	tok, pos, lit = s.scan()
+	s.last.tok, s.last.pos, s.last.lit = tok, pos, lit
+	return tok, pos, lit
+}
+
+// Unscan pushes the previously scanned token back so that the next call to
+// Scan returns it again. The scanner only has a one-token lookahead: calling
+// Unscan more than once before the next Scan still only pushes back the last
+// token. ScanRegex and ScanNumber read the underlying text directly and
+// ignore a pushed back token.
+func (s *Scanner) Unscan() { s.unscanned = true }
+
+// Peek returns the next token without advancing the scanner.
+// It uses the same one-token lookahead as Unscan.
+func (s *Scanner) Peek() (tok Token, pos Pos, lit string) {
+	tok, pos, lit = s.Scan()
+	s.Unscan()
+	return tok, pos, lit
+}
+
+// scan reads the next token from the underlying reader.
+func (s *Scanner) scan() (tok Token, pos Pos, lit string) {
+	tok, pos, lit = s.scanToken()
+	if !s.SkipComments || (tok != WS && tok != COMMENT) {
+		return tok, pos, lit
+	} else if !s.atBlank() {
+		return WS, pos, lit
+	}
+
+	// Merge the following whitespace and comments into a single WS token.
+	var buf bytes.Buffer
+	_, _ = buf.WriteString(lit)
+	for s.atBlank() {
+		t, p, l := s.scanToken()
+		if t == BADCOMMENT {
+			return t, p, l
+		}
+		_, _ = buf.WriteString(l)
+	}
+	return WS, pos, buf.String()
+}
+
+// atBlank returns true if the next runes start whitespace or a comment.
+func (s *Scanner) atBlank() bool {
+	ch0, _ := s.r.read()
+	if isWhitespace(ch0) {
+		s.r.unread()
+		return true
+	}
+	ch1, _ := s.r.read()
+	s.r.unread()
+	s.r.unread()
+	return (ch0 == '-' && ch1 == '-') || (ch0 == '/' && ch1 == '*')
+}
+
+// scanToken reads the next token, comments included, from the underlying reader.
+func (s *Scanner) scanToken() (tok Token, pos Pos, lit string) {
 	// Read next code point.
 	ch0, pos := s.r.read()
 
@@ -30,7 +160,7 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	// as an ident or reserved word.
 	if isWhitespace(ch0) {
 		return s.scanWhitespace()
-	} else if isLetter(ch0) || ch0 == '_' || ch0 == '@' {
+	} else if isLetter(ch0) || ch0 == '_' || ch0 == '@' || (s.SourcePatterns && ch0 == '*') {
 		s.r.unread()
 		return s.scanIdent(true)
 	} else if isDigit(ch0) {
@@ -46,18 +176,43 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 		return s.scanString()
 	case '\'':
 		return s.scanString()
+	case '`':
+		return s.scanQuotedIdent(pos)
 	case '.':
 		return DOT, pos, ""
 	case '-':
+		if ch1, _ := s.r.read(); ch1 == '-' {
+			return s.scanLineComment(pos)
+		}
+		s.r.unread()
 		return SUB, pos, ""
 	case '+':
 		return ADD, pos, ""
 	case '*':
 		return MUL, pos, ""
 	case '/':
+		if ch1, _ := s.r.read(); ch1 == '*' {
+			return s.scanBlockComment(pos)
+		}
+		s.r.unread()
 		return DIV, pos, ""
 	case '%':
 		return MOD, pos, ""
+	case '&':
+		// && is not an operator, it is not read as two bitwise ANDs.
+		if ch1, _ := s.r.read(); ch1 == '&' {
+			return ILLEGAL, pos, "&&"
+		}
+		s.r.unread()
+		return BITAND, pos, ""
+	case '|':
+		if ch1, _ := s.r.read(); ch1 == '|' {
+			return CONCAT, pos, ""
+		}
+		s.r.unread()
+		return BITOR, pos, ""
+	case '^':
+		return BITXOR, pos, ""
 	case '=':
 		if ch1, _ := s.r.read(); ch1 == '~' {
 			return EQREGEX, pos, ""
@@ -100,24 +255,80 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	return ILLEGAL, pos, string(ch0)
 }
 
-// scanWhitespace consumes the current rune and all contiguous whitespace.
-func (s *Scanner) scanWhitespace() (tok Token, pos Pos, lit string) {
-	// Create a buffer and read the current character into it.
+// scanLineComment consumes a "--" comment up to the end of the line.
+// This function assumes the "--" has already been consumed.
+func (s *Scanner) scanLineComment(pos Pos) (Token, Pos, string) {
 	var buf bytes.Buffer
-	ch, pos := s.r.curr()
+	_, _ = buf.WriteString("--")
+	for {
+		ch, _ := s.r.read()
+		if ch == eof {
+			break
+		} else if ch == '\n' {
+			s.r.unread()
+			break
+		}
+		_, _ = buf.WriteRune(ch)
+	}
+	return COMMENT, pos, buf.String()
+}
+
+// scanBlockComment consumes a "/* */" comment, comments do not nest so the
+// first "*/" ends it. An unterminated comment returns BADCOMMENT.
+// This function assumes the "/*" has already been consumed.
+func (s *Scanner) scanBlockComment(pos Pos) (Token, Pos, string) {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("/*")
+	for {
+		ch, _ := s.r.read()
+		if ch == eof {
+			return BADCOMMENT, pos, buf.String()
+		}
+		s.writeCurr(&buf)
+		if ch == '*' {
+			if ch1, _ := s.r.read(); ch1 == '/' {
+				_, _ = buf.WriteRune(ch1)
+				lit := buf.String()
+				if strings.HasPrefix(lit, "/*+") {
+					s.Hints = append(s.Hints, strings.FieldsFunc(strings.ToLower(lit[3:len(lit)-2]), isHintSeparator)...)
+				}
+				return COMMENT, pos, lit
+			}
+			s.r.unread()
+		}
+	}
+}
+
+// writeCurr writes the last read rune to buf, as written in the input if
+// RawWhitespace is set.
+func (s *Scanner) writeCurr(buf *bytes.Buffer) {
+	if s.RawWhitespace {
+		_, _ = buf.WriteString(s.r.currRaw())
+		return
+	}
+	ch, _ := s.r.curr()
 	_, _ = buf.WriteRune(ch)
+}
+
+// scanWhitespace consumes the current rune and all contiguous whitespace.
+func (s *Scanner) scanWhitespace() (tok Token, pos Pos, lit string) {
+	// Reset the literal buffer and read the current character into it.
+	buf := &s.lit
+	buf.Reset()
+	_, pos = s.r.curr()
+	s.writeCurr(buf)
 
 	// Read every subsequent whitespace character into the buffer.
 	// Non-whitespace characters and EOF will cause the loop to exit.
 	for {
-		ch, _ = s.r.read()
+		ch, _ := s.r.read()
 		if ch == eof {
 			break
 		} else if !isWhitespace(ch) {
 			s.r.unread()
 			break
 		} else {
-			_, _ = buf.WriteRune(ch)
+			s.writeCurr(buf)
 		}
 	}
 
@@ -129,7 +340,8 @@ func (s *Scanner) scanIdent(lookup bool) (tok Token, pos Pos, lit string) {
 	_, pos = s.r.read()
 	s.r.unread()
 
-	var buf bytes.Buffer
+	buf := &s.lit
+	buf.Reset()
 	for {
 		if ch, _ := s.r.read(); ch == eof {
 			break
@@ -141,7 +353,9 @@ func (s *Scanner) scanIdent(lookup bool) (tok Token, pos Pos, lit string) {
 			return IDENT, pos, lit0
 		} else if isIdentChar(ch) {
 			s.r.unread()
-			buf.WriteString(ScanBareIdent(s.r))
+			scanBareIdent(s.r, buf)
+		} else if s.SourcePatterns && isSourcePatternChar(ch) && !s.atLineComment(ch) {
+			_, _ = buf.WriteRune(ch)
 		} else {
 			s.r.unread()
 			break
@@ -149,18 +363,95 @@ func (s *Scanner) scanIdent(lookup bool) (tok Token, pos Pos, lit string) {
 	}
 	lit = buf.String()
 
+	if s.IdentPaths {
+		segments, err := s.scanIdentPath()
+		if err != nil {
+			return BADSTRING, pos, lit + "." + strings.Join(segments, ".")
+		}
+		if len(segments) > 0 {
+			return IDENT, pos, lit + "." + strings.Join(segments, ".")
+		}
+	}
+
 	// If the literal matches a keyword then return that keyword.
 	if lookup {
-		if tok = Lookup(lit); tok != IDENT {
+		if tok, ok := Lookup(lit); ok {
 			return tok, pos, ""
 		}
 	}
 	return IDENT, pos, lit
 }
 
+// atLineComment returns true if ch and the next rune start a line comment.
+func (s *Scanner) atLineComment(ch rune) bool {
+	if ch != '-' {
+		return false
+	}
+	ch1, _ := s.r.read()
+	s.r.unread()
+	return ch1 == '-'
+}
+
+// scanIdentPath consumes the segments following an identifier, each one is
+// a dot followed by a bare identifier or a double quoted string.
+func (s *Scanner) scanIdentPath() ([]string, error) {
+	var segments []string
+	for {
+		if ch0, _ := s.r.read(); ch0 != '.' {
+			s.r.unread()
+			return segments, nil
+		}
+		ch1, _ := s.r.read()
+		switch {
+		case isIdentFirstChar(ch1):
+			s.r.unread()
+			segments = append(segments, ScanBareIdent(s.r))
+		case ch1 == '`':
+			tok, _, segment := s.scanQuotedIdent(Pos{})
+			segments = append(segments, segment)
+			if tok != IDENT {
+				return segments, errBadString
+			}
+		case ch1 == '"':
+			s.r.unread()
+			segment, err := ScanString(s.r)
+			segments = append(segments, segment)
+			if err != nil {
+				return segments, err
+			}
+		default:
+			s.r.unread()
+			s.r.unread()
+			return segments, nil
+		}
+	}
+}
+
+// scanQuotedIdent consumes an identifier quoted with backticks, a backtick
+// inside is escaped by doubling it. Keywords are not looked up so quoted
+// identifiers can be reserved words. An unterminated identifier returns
+// BADSTRING.
+// This function assumes the opening backtick has already been consumed.
+func (s *Scanner) scanQuotedIdent(pos Pos) (Token, Pos, string) {
+	var buf bytes.Buffer
+	for {
+		ch, _ := s.r.read()
+		if ch == eof || ch == '\n' {
+			return BADSTRING, pos, buf.String()
+		} else if ch == '`' {
+			if ch1, _ := s.r.read(); ch1 != '`' {
+				s.r.unread()
+				return IDENT, pos, buf.String()
+			}
+		}
+		_, _ = buf.WriteRune(ch)
+	}
+}
+
 // scanString consumes a contiguous string of non-quote characters.
 // Quote characters can be consumed if they're first escaped with a backslash.
 func (s *Scanner) scanString() (tok Token, pos Pos, lit string) {
+	s.quote, _ = s.r.curr()
 	s.r.unread()
 	_, pos = s.r.curr()
 
@@ -202,7 +493,23 @@ func (s *Scanner) ScanRegex() (tok Token, pos Pos, lit string) {
 func (s *Scanner) ScanNumber() (tok Token, pos Pos, lit string) {
 	var buf bytes.Buffer
 	//get first digit pos
-	_, pos = s.r.read()
+	ch, pos := s.r.read()
+
+	// A leading "0x" introduces a hexadecimal integer.
+	if ch == '0' {
+		if ch1, _ := s.r.read(); ch1 == 'x' || ch1 == 'X' {
+			_, _ = buf.WriteRune(ch)
+			_, _ = buf.WriteRune(ch1)
+			_, _ = buf.WriteString(s.scanDigitsFunc(isHexDigit))
+			lit = buf.String()
+			if len(lit) == 2 || !validSeparators(lit, isHexDigit) {
+				return ILLEGAL, pos, lit
+			}
+			return INTEGER, pos, strings.Replace(lit, "_", "", -1)
+		}
+		s.r.unread()
+	}
+
 	// push first digit back, then read as many digits as possible.
 	s.r.unread()
 	_, _ = buf.WriteString(s.scanDigits())
@@ -222,19 +529,83 @@ func (s *Scanner) ScanNumber() (tok Token, pos Pos, lit string) {
 		s.r.unread()
 	}
 
+	// If next code points are an exponent marker, an optional sign and a digit
+	// then consume them. Otherwise push them back so the number ends before
+	// the 'e' and it is scanned as the start of the next token.
+	if ch0, _ := s.r.read(); ch0 == 'e' || ch0 == 'E' {
+		ch1, _ := s.r.read()
+		if ch1 == '+' || ch1 == '-' {
+			if ch2, _ := s.r.read(); isDigit(ch2) {
+				isDecimal = true
+				_, _ = buf.WriteRune(ch0)
+				_, _ = buf.WriteRune(ch1)
+				_, _ = buf.WriteRune(ch2)
+				_, _ = buf.WriteString(s.scanDigits())
+			} else {
+				s.r.unread()
+				s.r.unread()
+				s.r.unread()
+			}
+		} else if isDigit(ch1) {
+			isDecimal = true
+			_, _ = buf.WriteRune(ch0)
+			_, _ = buf.WriteRune(ch1)
+			_, _ = buf.WriteString(s.scanDigits())
+		} else {
+			s.r.unread()
+			s.r.unread()
+		}
+	} else {
+		s.r.unread()
+	}
+
+	// Underscores only separate digits and are dropped from the literal.
+	lit = buf.String()
+	if !validSeparators(lit, isDigit) {
+		return ILLEGAL, pos, lit
+	}
+	lit = strings.Replace(lit, "_", "", -1)
+
 	// Read as a duration or integer if it doesn't have a fractional part.
 	if !isDecimal {
-		return INTEGER, pos, buf.String()
+		// If the next rune starts a unit then this is a duration token, the
+		// digits and letters that follow are part of it, e.g. "1h30m".
+		if ch0, _ := s.r.read(); isDurationUnitFirstChar(ch0) {
+			buf.Reset()
+			_, _ = buf.WriteString(lit)
+			_, _ = buf.WriteRune(ch0)
+			for {
+				ch1, _ := s.r.read()
+				if !isLetter(ch1) && !isDigit(ch1) {
+					s.r.unread()
+					break
+				}
+				_, _ = buf.WriteRune(ch1)
+			}
+			lit = buf.String()
+			if _, err := ParseDuration(lit); err != nil {
+				return ILLEGAL, pos, lit
+			}
+			return DURATION, pos, lit
+		}
+		s.r.unread()
+		return INTEGER, pos, lit
 	}
-	return NUMBER, pos, buf.String()
+	return NUMBER, pos, lit
 }
 
-// scanDigits consume a contiguous series of digits.
+// scanDigits consume a contiguous series of digits and underscore separators.
 func (s *Scanner) scanDigits() string {
+	return s.scanDigitsFunc(isDigit)
+}
+
+// scanDigitsFunc consume a contiguous series of runes satisfying fn and
+// underscore separators.
+func (s *Scanner) scanDigitsFunc(fn func(rune) bool) string {
 	var buf bytes.Buffer
 	for {
 		ch, _ := s.r.read()
-		if !isDigit(ch) {
+		if !fn(ch) && ch != '_' {
 			s.r.unread()
 			break
 		}
@@ -243,18 +614,50 @@ func (s *Scanner) scanDigits() string {
 	return buf.String()
 }
 
+// validSeparators returns true if every underscore in lit sits between two
+// runes satisfying fn.
+func validSeparators(lit string, fn func(rune) bool) bool {
+	runes := []rune(lit)
+	for i, ch := range runes {
+		if ch != '_' {
+			continue
+		}
+		if i == 0 || i == len(runes)-1 || !fn(runes[i-1]) || !fn(runes[i+1]) {
+			return false
+		}
+	}
+	return true
+}
+
 // isWhitespace returns true if the rune is a space, tab, or newline.
 func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' }
 
-// isLetter returns true if the rune is a letter.
-func isLetter(ch rune) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
+// isLetter returns true if the rune is a letter, including non-ASCII letters.
+func isLetter(ch rune) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= utf8.RuneSelf && unicode.IsLetter(ch))
+}
 
 // isDigit returns true if the rune is a digit.
 func isDigit(ch rune) bool { return (ch >= '0' && ch <= '9') }
 
+// isDurationUnitFirstChar returns true if the rune can start a duration unit.
+func isDurationUnitFirstChar(ch rune) bool { return strings.ContainsRune("numshdw", ch) }
+
+// isHexDigit returns true if the rune is a hexadecimal digit.
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
 // isIdentChar returns true if the rune can be used in an unquoted identifier.
 func isIdentChar(ch rune) bool { return isLetter(ch) || isDigit(ch) || ch == '_' || ch == '@' }
 
+// isHintSeparator returns true if the rune separates the names of a hint comment.
+func isHintSeparator(ch rune) bool { return isWhitespace(ch) || ch == ',' }
+
+// isSourcePatternChar returns true if the rune can be used in an index pattern
+// in addition to the identifier characters.
+func isSourcePatternChar(ch rune) bool { return ch == '*' || ch == '-' || ch == '.' }
+
 // isIdentFirstChar returns true if the rune can be used as the first char in an unquoted identifer.
 func isIdentFirstChar(ch rune) bool { return isLetter(ch) || ch == '_' }
 
@@ -264,16 +667,20 @@ type bufScanner struct {
 	s   *Scanner
 	i   int // buffer index
 	n   int // buffer size
-	buf [3]struct {
-		tok Token
-		pos Pos
-		lit string
+	buf [ringSize]struct {
+		tok   Token
+		pos   Pos
+		lit   string
+		quote rune // quote character of a STRING token
 	}
 }
 
 // newBufScanner returns a new buffered scanner for a reader.
+// Comments are skipped as whitespace since the parser never needs them.
 func newBufScanner(r io.Reader) *bufScanner {
-	return &bufScanner{s: NewScanner(r)}
+	s := NewScanner(r)
+	s.SkipComments = true
+	return &bufScanner{s: s}
 }
 
 // Scan reads the next token from the scanner.
@@ -295,9 +702,13 @@ func (s *bufScanner) scanFunc(scan func() (Token, Pos, string)) (tok Token, pos
 	}
 
 	// Move buffer position forward and save the token.
-	s.i = (s.i + 1) % len(s.buf)
+	s.i = (s.i + 1) & ringMask
 	buf := &s.buf[s.i]
 	buf.tok, buf.pos, buf.lit = scan()
+	buf.quote = 0
+	if buf.tok == STRING {
+		buf.quote = s.s.quote
+	}
 
 	return s.curr()
 }
@@ -305,12 +716,26 @@ func (s *bufScanner) scanFunc(scan func() (Token, Pos, string)) (tok Token, pos
 // Unscan pushes the previously token back onto the buffer.
 func (s *bufScanner) Unscan() { s.n++ }
 
+// quote returns the quote character of the last read token if it is a
+// STRING, or 0.
+func (s *bufScanner) quote() rune {
+	return s.buf[(s.i-s.n)&ringMask].quote
+}
+
 // curr returns the last read token.
 func (s *bufScanner) curr() (tok Token, pos Pos, lit string) {
-	buf := &s.buf[(s.i-s.n+len(s.buf))%len(s.buf)]
+	buf := &s.buf[(s.i-s.n)&ringMask]
 	return buf.tok, buf.pos, buf.lit
 }
 
+// ringSize is the length of the circular buffers of the scanner, it bounds
+// how many tokens or runes can be unread. It is a power of two so the
+// buffer index wraps around with a mask instead of a division.
+const (
+	ringSize = 4
+	ringMask = ringSize - 1
+)
+
 // reader represents a buffered rune reader used by the scanner.
 // It provides a fixed-length circular buffer that can be unread.
 type reader struct {
@@ -318,9 +743,10 @@ type reader struct {
 	i   int // buffer index
 	n   int // buffer char count
 	pos Pos // last read rune position
-	buf [3]struct {
+	buf [ringSize]struct {
 		ch  rune
 		pos Pos
+		raw string // original text of a line break read as '\n'
 	}
 	eof bool // true if reader has ever seen eof.
 }
@@ -353,22 +779,26 @@ func (r *reader) read() (ch rune, pos Pos) {
 
 	// Read next rune from underlying reader.
 	// Any error (including io.EOF) should return as EOF.
-	ch, _, err := r.r.ReadRune()
+	ch, size, err := r.r.ReadRune()
+	raw := ""
 	if err != nil {
 		ch = eof
 	} else if ch == '\r' {
-		if _ch, _, err := r.r.ReadRune(); err != nil {
+		raw = "\r"
+		if _ch, _size, err := r.r.ReadRune(); err != nil {
 			// nop
 		} else if _ch != '\n' {
 			_ = r.r.UnreadRune()
+		} else {
+			raw, size = "\r\n", size+_size
 		}
 		ch = '\n'
 	}
 
 	// Save character and position to the buffer.
-	r.i = (r.i + 1) % len(r.buf)
+	r.i = (r.i + 1) & ringMask
 	buf := &r.buf[r.i]
-	buf.ch, buf.pos = ch, r.pos
+	buf.ch, buf.pos, buf.raw = ch, r.pos, raw
 
 	// Update position.
 	// Only count EOF once.
@@ -378,6 +808,7 @@ func (r *reader) read() (ch rune, pos Pos) {
 	} else if !r.eof {
 		r.pos.Char++
 	}
+	r.pos.Offset += size
 
 	// Mark the reader as EOF.
 	// This is used so we don't double count EOF characters.
@@ -395,11 +826,19 @@ func (r *reader) unread() {
 
 // curr returns the last read character and position.
 func (r *reader) curr() (ch rune, pos Pos) {
-	i := (r.i - r.n + len(r.buf)) % len(r.buf)
-	buf := &r.buf[i]
+	buf := &r.buf[(r.i-r.n)&ringMask]
 	return buf.ch, buf.pos
 }
 
+// currRaw returns the original text of the last read character.
+func (r *reader) currRaw() string {
+	buf := &r.buf[(r.i-r.n)&ringMask]
+	if buf.raw != "" {
+		return buf.raw
+	}
+	return string(buf.ch)
+}
+
 // eof is a marker code point to signify that the reader can't read any more.
 const eof = rune(0)
 
@@ -452,7 +891,25 @@ func ScanDelimited(r io.RuneScanner, start, end rune, escapes map[rune]rune, esc
 	}
 }
 
+// stringEscapes maps the characters following a backslash in a string to
+// the character they escape.
+var stringEscapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'f':  '\f',
+	'b':  '\b',
+	'0':  0,
+	'\\': '\\',
+	'/':  '/',
+	'"':  '"',
+	'\'': '\'',
+}
+
 // ScanString reads a quoted string from a rune reader.
+// The supported escapes are \n, \t, \r, \f, \b, \0, \\, \/, \" and \', \uXXXX
+// for a unicode code point, a surrogate pair for code points above U+FFFF.
+// The LIKE wildcard escapes \% and \_ are kept as is.
 func ScanString(r io.RuneScanner) (string, error) {
 	ending, _, err := r.ReadRune()
 	if err != nil {
@@ -470,14 +927,18 @@ func ScanString(r io.RuneScanner) (string, error) {
 			// If the next character is an escape then write the escaped char.
 			// If it's not a valid escape then return an error.
 			ch1, _, _ := r.ReadRune()
-			if ch1 == 'n' {
-				_, _ = buf.WriteRune('\n')
-			} else if ch1 == '\\' {
-				_, _ = buf.WriteRune('\\')
-			} else if ch1 == '"' {
-				_, _ = buf.WriteRune('"')
-			} else if ch1 == '\'' {
-				_, _ = buf.WriteRune('\'')
+			if ch, ok := stringEscapes[ch1]; ok {
+				_, _ = buf.WriteRune(ch)
+			} else if ch1 == '%' || ch1 == '_' {
+				// Keep LIKE wildcard escapes so the pattern can be translated later.
+				_, _ = buf.WriteRune(ch0)
+				_, _ = buf.WriteRune(ch1)
+			} else if ch1 == 'u' {
+				ch, lit, err := scanUnicodeEscape(r)
+				if err != nil {
+					return lit, err
+				}
+				_, _ = buf.WriteRune(ch)
 			} else {
 				return string(ch0) + string(ch1), errBadEscape
 			}
@@ -487,15 +948,83 @@ func ScanString(r io.RuneScanner) (string, error) {
 	}
 }
 
+// scanUnicodeEscape reads the four hex digits of a \uXXXX escape and returns
+// the rune. A high surrogate must be followed by the \uXXXX escape of a low
+// surrogate, the pair is decoded into a single rune.
+// This function assumes the \u has already been consumed.
+func scanUnicodeEscape(r io.RuneScanner) (rune, string, error) {
+	lit := `\u`
+	ch, hex, ok := scanHex4(r)
+	lit += hex
+	if !ok {
+		return 0, lit, errBadEscape
+	}
+	if !utf16.IsSurrogate(ch) {
+		return ch, lit, nil
+	} else if ch >= 0xDC00 {
+		// a low surrogate without its high surrogate
+		return 0, lit, errBadEscape
+	}
+
+	for _, want := range `\u` {
+		next, _, err := r.ReadRune()
+		if err != nil || next != want {
+			if err == nil {
+				lit += string(next)
+			}
+			return 0, lit, errBadEscape
+		}
+		lit += string(next)
+	}
+	low, hex, ok := scanHex4(r)
+	lit += hex
+	if !ok {
+		return 0, lit, errBadEscape
+	}
+	if ch = utf16.DecodeRune(ch, low); ch == unicode.ReplacementChar {
+		return 0, lit, errBadEscape
+	}
+	return ch, lit, nil
+}
+
+// scanHex4 reads four hex digits and returns their value and the characters
+// read, the last of which is not a hex digit when ok is false.
+func scanHex4(r io.RuneScanner) (v rune, lit string, ok bool) {
+	for i := 0; i < 4; i++ {
+		ch, _, err := r.ReadRune()
+		if err != nil {
+			return 0, lit, false
+		}
+		lit += string(ch)
+		switch {
+		case ch >= '0' && ch <= '9':
+			v = v<<4 | (ch - '0')
+		case ch >= 'a' && ch <= 'f':
+			v = v<<4 | (ch - 'a' + 10)
+		case ch >= 'A' && ch <= 'F':
+			v = v<<4 | (ch - 'A' + 10)
+		default:
+			return 0, lit, false
+		}
+	}
+	return v, lit, true
+}
+
 var errBadString = errors.New("bad string")
 var errBadEscape = errors.New("bad escape")
 var errBadRegex = errors.New("bad regex")
 
 // ScanBareIdent reads bare identifier from a rune reader.
 func ScanBareIdent(r io.RuneScanner) string {
+	var buf bytes.Buffer
+	scanBareIdent(r, &buf)
+	return buf.String()
+}
+
+// scanBareIdent reads a bare identifier from a rune reader into buf.
+func scanBareIdent(r io.RuneScanner, buf *bytes.Buffer) {
 	// Read every ident character into the buffer.
 	// Non-ident characters and EOF will cause the loop to exit.
-	var buf bytes.Buffer
 	for {
 		ch, _, err := r.ReadRune()
 		if err != nil {
@@ -507,7 +1036,6 @@ func ScanBareIdent(r io.RuneScanner) string {
 			_, _ = buf.WriteRune(ch)
 		}
 	}
-	return buf.String()
 }
 
 var errInvalidIdentifier = errors.New("invalid identifier")
@@ -522,6 +1050,11 @@ func IsListOp(t Token) bool {
 	return (t == IN || t == NI)
 }
 
+// IsLikeOp returns true if the operator accepts a LIKE pattern operand.
+func IsLikeOp(t Token) bool {
+	return (t == LIKE || t == NLIKE)
+}
+
 // assert will panic with a given formatted message if the given condition is false.
 func assert(condition bool, msg string, v ...interface{}) {
 	if !condition {
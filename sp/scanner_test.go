@@ -1,9 +1,12 @@
 package sp_test
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/chenyoufu/esql/sp"
 )
@@ -42,12 +45,22 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `-`, tok: sp.SUB},
 		{s: `*`, tok: sp.MUL},
 		{s: `/`, tok: sp.DIV},
+		{s: `%`, tok: sp.MOD},
+		{s: `&`, tok: sp.BITAND},
+		{s: `|`, tok: sp.BITOR},
+		{s: `^`, tok: sp.BITXOR},
+		{s: `&&`, tok: sp.ILLEGAL, lit: `&&`},
+		{s: `||`, tok: sp.CONCAT},
 
 		// Logical operators
 		{s: `AND`, tok: sp.AND},
 		{s: `and`, tok: sp.AND},
 		{s: `OR`, tok: sp.OR},
 		{s: `or`, tok: sp.OR},
+		{s: `LIKE`, tok: sp.LIKE},
+		{s: `like`, tok: sp.LIKE},
+		{s: `BETWEEN`, tok: sp.BETWEEN},
+		{s: `IS`, tok: sp.IS},
 
 		{s: `=`, tok: sp.EQ},
 		{s: `<>`, tok: sp.NEQ},
@@ -75,11 +88,13 @@ func TestScanner_Scan(t *testing.T) {
 
 		{s: `true`, tok: sp.TRUE},
 		{s: `false`, tok: sp.FALSE},
+		{s: `NULL`, tok: sp.NULL},
+		{s: `null`, tok: sp.NULL},
 
 		// Strings
 		{s: `"foo"`, tok: sp.STRING, lit: `foo`},
 		{s: `"foo\\bar"`, tok: sp.STRING, lit: `foo\bar`},
-		{s: `"foo\bar"`, tok: sp.BADESCAPE, lit: `\b`, pos: sp.Pos{Line: 0, Char: 5}},
+		{s: `"foo\bar"`, tok: sp.STRING, lit: "foo\bar"},
 		{s: `"foo\"bar\""`, tok: sp.STRING, lit: `foo"bar"`},
 		{s: `'testing 123!'`, tok: sp.STRING, lit: `testing 123!`},
 		{s: `'foo\nbar'`, tok: sp.STRING, lit: "foo\nbar"},
@@ -88,6 +103,14 @@ func TestScanner_Scan(t *testing.T) {
 		{s: "'test\nfoo", tok: sp.BADSTRING, lit: `test`},
 		{s: `'test\g'`, tok: sp.BADESCAPE, lit: `\g`, pos: sp.Pos{Line: 0, Char: 6}},
 
+		// Quoted identifiers
+		{s: "`my field`", tok: sp.IDENT, lit: `my field`},
+		{s: "`select`", tok: sp.IDENT, lit: `select`},
+		{s: "``", tok: sp.IDENT, lit: ``},
+		{s: "`a``b`", tok: sp.IDENT, lit: "a`b"},
+		{s: "`test", tok: sp.BADSTRING, lit: `test`},
+		{s: "`test\nfoo`", tok: sp.BADSTRING, lit: `test`},
+
 		// Numbers
 		{s: `100`, tok: sp.INTEGER, lit: `100`},
 		{s: `10.3`, tok: sp.NUMBER, lit: `10.3`},
@@ -96,10 +119,17 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `ASC`, tok: sp.ASC},
 		{s: `BY`, tok: sp.BY},
 		{s: `DESC`, tok: sp.DESC},
+		{s: `DISTINCT`, tok: sp.DISTINCT},
 		{s: `FROM`, tok: sp.FROM},
 		{s: `GROUP`, tok: sp.GROUP},
 		{s: `HAVING`, tok: sp.HAVING},
 		{s: `LIMIT`, tok: sp.LIMIT},
+		{s: `NOT`, tok: sp.NOT},
+		{s: `NULLS`, tok: sp.NULLS},
+		{s: `nulls`, tok: sp.NULLS},
+		{s: `FIRST`, tok: sp.FIRST},
+		{s: `last`, tok: sp.LAST},
+		{s: `OFFSET`, tok: sp.OFFSET},
 		{s: `ORDER`, tok: sp.ORDER},
 		{s: `SELECT`, tok: sp.SELECT},
 		{s: `WHERE`, tok: sp.WHERE},
@@ -127,22 +157,22 @@ func TestScanner_Scan_Multi(t *testing.T) {
 		lit string
 	}
 	exp := []result{
-		{tok: sp.SELECT, pos: sp.Pos{Line: 0, Char: 0}, lit: ""},
-		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 6}, lit: " "},
-		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 7}, lit: "value"},
-		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 12}, lit: " "},
-		{tok: sp.FROM, pos: sp.Pos{Line: 0, Char: 13}, lit: ""},
-		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 17}, lit: " "},
-		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 18}, lit: "myseries"},
-		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 26}, lit: " "},
-		{tok: sp.WHERE, pos: sp.Pos{Line: 0, Char: 27}, lit: ""},
-		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 32}, lit: " "},
-		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 33}, lit: "a"},
-		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 34}, lit: " "},
-		{tok: sp.EQ, pos: sp.Pos{Line: 0, Char: 35}, lit: ""},
-		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 36}, lit: " "},
-		{tok: sp.STRING, pos: sp.Pos{Line: 0, Char: 36}, lit: "b"},
-		{tok: sp.EOF, pos: sp.Pos{Line: 0, Char: 40}, lit: ""},
+		{tok: sp.SELECT, pos: sp.Pos{Line: 0, Char: 0, Offset: 0}, lit: ""},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 6, Offset: 6}, lit: " "},
+		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 7, Offset: 7}, lit: "value"},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 12, Offset: 12}, lit: " "},
+		{tok: sp.FROM, pos: sp.Pos{Line: 0, Char: 13, Offset: 13}, lit: ""},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 17, Offset: 17}, lit: " "},
+		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 18, Offset: 18}, lit: "myseries"},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 26, Offset: 26}, lit: " "},
+		{tok: sp.WHERE, pos: sp.Pos{Line: 0, Char: 27, Offset: 27}, lit: ""},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 32, Offset: 32}, lit: " "},
+		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 33, Offset: 33}, lit: "a"},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 34, Offset: 34}, lit: " "},
+		{tok: sp.EQ, pos: sp.Pos{Line: 0, Char: 35, Offset: 35}, lit: ""},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 36, Offset: 36}, lit: " "},
+		{tok: sp.STRING, pos: sp.Pos{Line: 0, Char: 36, Offset: 36}, lit: "b"},
+		{tok: sp.EOF, pos: sp.Pos{Line: 0, Char: 40, Offset: 40}, lit: ""},
 	}
 
 	// Create a scanner.
@@ -172,6 +202,301 @@ func TestScanner_Scan_Multi(t *testing.T) {
 	}
 }
 
+// Ensure the scanner tracks the byte offset of multi-byte runes and line breaks.
+func TestScanner_Scan_Offset(t *testing.T) {
+	var exp = []struct {
+		tok sp.Token
+		pos sp.Pos
+	}{
+		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 0, Offset: 0}},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 1, Offset: 1}},
+		{tok: sp.EQ, pos: sp.Pos{Line: 0, Char: 2, Offset: 2}},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 3, Offset: 3}},
+		{tok: sp.STRING, pos: sp.Pos{Line: 0, Char: 3, Offset: 3}},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 9, Offset: 15}},
+		{tok: sp.AND, pos: sp.Pos{Line: 1, Char: 0, Offset: 18}},
+		{tok: sp.EOF, pos: sp.Pos{Line: 1, Char: 4, Offset: 21}},
+	}
+
+	s := sp.NewScanner(strings.NewReader("a = '日本語' \r\nand"))
+	for i, tt := range exp {
+		tok, pos, _ := s.Scan()
+		if tok != tt.tok || pos != tt.pos {
+			t.Errorf("%d. token mismatch: exp=%s %#v got=%s %#v", i, tt.tok, tt.pos, tok, pos)
+		}
+	}
+}
+
+// Ensure positions count runes after a multi-byte identifier.
+func TestScanner_Scan_UnicodeIdent(t *testing.T) {
+	var exp = []struct {
+		tok sp.Token
+		pos sp.Pos
+		lit string
+	}{
+		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 0, Offset: 0}, lit: "café_count"},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 10, Offset: 11}, lit: " "},
+		{tok: sp.FROM, pos: sp.Pos{Line: 0, Char: 11, Offset: 12}},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 15, Offset: 16}, lit: " "},
+		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 16, Offset: 17}, lit: "日志"},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 18, Offset: 23}, lit: " "},
+		{tok: sp.WHERE, pos: sp.Pos{Line: 0, Char: 19, Offset: 24}},
+	}
+
+	s := sp.NewScanner(strings.NewReader("café_count FROM 日志 WHERE"))
+	for i, tt := range exp {
+		tok, pos, lit := s.Scan()
+		if tok != tt.tok || pos != tt.pos || lit != tt.lit {
+			t.Errorf("%d. token mismatch: exp=%s %#v <%q> got=%s %#v <%q>", i, tt.tok, tt.pos, tt.lit, tok, pos, lit)
+		}
+	}
+}
+
+// Ensure the scanner can scan comments, or skip them as whitespace.
+func TestScanner_Scan_Comments(t *testing.T) {
+	type result struct {
+		tok sp.Token
+		lit string
+	}
+	var tests = []struct {
+		s    string
+		skip bool
+		raw  bool
+		exp  []result
+	}{
+		{
+			s:   "a -- get a\nb",
+			exp: []result{{sp.IDENT, "a"}, {sp.WS, " "}, {sp.COMMENT, "-- get a"}, {sp.WS, "\n"}, {sp.IDENT, "b"}},
+		},
+		{
+			s:   "a - b",
+			exp: []result{{sp.IDENT, "a"}, {sp.WS, " "}, {sp.SUB, ""}, {sp.WS, " "}, {sp.IDENT, "b"}},
+		},
+		{
+			s:   "/* note */a/b",
+			exp: []result{{sp.COMMENT, "/* note */"}, {sp.IDENT, "a"}, {sp.DIV, ""}, {sp.IDENT, "b"}},
+		},
+		{
+			s:   "/* a /* b */ c */",
+			exp: []result{{sp.COMMENT, "/* a /* b */"}, {sp.WS, " "}, {sp.IDENT, "c"}, {sp.WS, " "}, {sp.MUL, ""}, {sp.DIV, ""}},
+		},
+		{
+			s:   "/**/-- end",
+			exp: []result{{sp.COMMENT, "/**/"}, {sp.COMMENT, "-- end"}},
+		},
+		{
+			s:   "a /* note",
+			exp: []result{{sp.IDENT, "a"}, {sp.WS, " "}, {sp.BADCOMMENT, "/* note"}},
+		},
+		{
+			s:    "a /* x */ -- y\n b",
+			skip: true,
+			exp:  []result{{sp.IDENT, "a"}, {sp.WS, " /* x */ -- y\n "}, {sp.IDENT, "b"}},
+		},
+		{
+			s:    "a/**/b",
+			skip: true,
+			exp:  []result{{sp.IDENT, "a"}, {sp.WS, "/**/"}, {sp.IDENT, "b"}},
+		},
+		{
+			s:    "a /* x",
+			skip: true,
+			exp:  []result{{sp.IDENT, "a"}, {sp.BADCOMMENT, "/* x"}},
+		},
+		{
+			s:   "a \r\n\tb\rc",
+			exp: []result{{sp.IDENT, "a"}, {sp.WS, " \n\t"}, {sp.IDENT, "b"}, {sp.WS, "\n"}, {sp.IDENT, "c"}},
+		},
+		{
+			s:   "a \r\n\tb\rc",
+			raw: true,
+			exp: []result{{sp.IDENT, "a"}, {sp.WS, " \r\n\t"}, {sp.IDENT, "b"}, {sp.WS, "\r"}, {sp.IDENT, "c"}},
+		},
+		{
+			s:   "/* x\r\ny */-- z\r\n",
+			raw: true,
+			exp: []result{{sp.COMMENT, "/* x\r\ny */"}, {sp.COMMENT, "-- z"}, {sp.WS, "\r\n"}},
+		},
+		{
+			s:    "a\r\n/* x */\r\nb",
+			skip: true,
+			raw:  true,
+			exp:  []result{{sp.IDENT, "a"}, {sp.WS, "\r\n/* x */\r\n"}, {sp.IDENT, "b"}},
+		},
+	}
+
+	for i, tt := range tests {
+		s := sp.NewScanner(strings.NewReader(tt.s))
+		s.SkipComments = tt.skip
+		s.RawWhitespace = tt.raw
+
+		var act []result
+		for {
+			tok, _, lit := s.Scan()
+			if tok == sp.EOF {
+				break
+			}
+			act = append(act, result{tok, lit})
+		}
+		if !reflect.DeepEqual(tt.exp, act) {
+			t.Errorf("%d. %q: token mismatch:\n\nexp=%v\n\ngot=%v", i, tt.s, tt.exp, act)
+		}
+	}
+}
+
+// Ensure the scanner can read dotted identifier paths as a single token.
+func TestScanner_Scan_IdentPaths(t *testing.T) {
+	var tests = []struct {
+		s     string
+		paths bool
+		tok   sp.Token
+		lit   string
+	}{
+		{s: `a.b.c`, tok: sp.IDENT, lit: `a`},
+		{s: `a.b.c`, paths: true, tok: sp.IDENT, lit: `a.b.c`},
+		{s: `user.address.city = 1`, paths: true, tok: sp.IDENT, lit: `user.address.city`},
+		{s: `a."b c".d`, paths: true, tok: sp.IDENT, lit: `a.b c.d`},
+		{s: `a."b\"c"`, paths: true, tok: sp.IDENT, lit: `a.b"c`},
+		{s: `a.1`, paths: true, tok: sp.IDENT, lit: `a`},
+		{s: `a."b c`, paths: true, tok: sp.BADSTRING, lit: `a.b c`},
+		{s: "a.`b c`.d", paths: true, tok: sp.IDENT, lit: `a.b c.d`},
+		{s: `select.from`, paths: true, tok: sp.IDENT, lit: `select.from`},
+	}
+
+	for i, tt := range tests {
+		s := sp.NewScanner(strings.NewReader(tt.s))
+		s.IdentPaths = tt.paths
+		tok, _, lit := s.Scan()
+		if tt.tok != tok {
+			t.Errorf("%d. %q token mismatch: exp=%q got=%q <%q>", i, tt.s, tt.tok, tok, lit)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s, tt.lit, lit)
+		}
+	}
+}
+
+// Ensure the scanner can read index patterns as a single token.
+func TestScanner_Scan_SourcePatterns(t *testing.T) {
+	var tests = []struct {
+		s        string
+		patterns bool
+		tok      sp.Token
+		lit      string
+	}{
+		{s: `logs-2024.*`, tok: sp.IDENT, lit: `logs`},
+		{s: `*`, tok: sp.MUL},
+		{s: `logs-2024.*`, patterns: true, tok: sp.IDENT, lit: `logs-2024.*`},
+		{s: `logs-* WHERE`, patterns: true, tok: sp.IDENT, lit: `logs-*`},
+		{s: `*-metrics`, patterns: true, tok: sp.IDENT, lit: `*-metrics`},
+		{s: `logs,metrics`, patterns: true, tok: sp.IDENT, lit: `logs`},
+		{s: `logs-- comment`, patterns: true, tok: sp.IDENT, lit: `logs`},
+		{s: `from`, patterns: true, tok: sp.FROM},
+	}
+
+	for i, tt := range tests {
+		s := sp.NewScanner(strings.NewReader(tt.s))
+		s.SourcePatterns = tt.patterns
+		tok, _, lit := s.Scan()
+		if tt.tok != tok {
+			t.Errorf("%d. %q token mismatch: exp=%q got=%q <%q>", i, tt.s, tt.tok, tok, lit)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s, tt.lit, lit)
+		}
+	}
+}
+
+// Ensure the scanner collects the names of hint comments.
+func TestScanner_Scan_Hints(t *testing.T) {
+	var tests = []struct {
+		s     string
+		hints []string
+	}{
+		{s: `SELECT /* track_total_hits */ *`},
+		{s: `SELECT /*+ track_total_hits */ *`, hints: []string{"track_total_hits"}},
+		{s: `SELECT /*+ Track_Total_Hits,foo  bar*/ * -- /*+ baz */`, hints: []string{"track_total_hits", "foo", "bar"}},
+		{s: `SELECT /*+ a */ * /*+ b */`, hints: []string{"a", "b"}},
+	}
+
+	for i, tt := range tests {
+		s := sp.NewScanner(strings.NewReader(tt.s))
+		for {
+			if tok, _, _ := s.Scan(); tok == sp.EOF {
+				break
+			}
+		}
+		if !reflect.DeepEqual(tt.hints, s.Hints) {
+			t.Errorf("%d. %q hints mismatch: exp=%q got=%q", i, tt.s, tt.hints, s.Hints)
+		}
+	}
+}
+
+// Ensure a reset scanner reads the new input from the start.
+func TestScanner_Reset(t *testing.T) {
+	s := sp.NewScanner(strings.NewReader("SELECT /*+ a */ x\nFROM"))
+	s.SkipComments = true
+	for i := 0; i < 5; i++ {
+		s.Scan()
+	}
+	s.Unscan()
+
+	s.Reset(strings.NewReader("b = 1"))
+	var exp = []struct {
+		tok sp.Token
+		pos sp.Pos
+		lit string
+	}{
+		{tok: sp.IDENT, pos: sp.Pos{Line: 0, Char: 0, Offset: 0}, lit: "b"},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 1, Offset: 1}, lit: " "},
+		{tok: sp.EQ, pos: sp.Pos{Line: 0, Char: 2, Offset: 2}},
+		{tok: sp.WS, pos: sp.Pos{Line: 0, Char: 3, Offset: 3}, lit: " "},
+		{tok: sp.INTEGER, pos: sp.Pos{Line: 0, Char: 4, Offset: 4}, lit: "1"},
+		{tok: sp.EOF, pos: sp.Pos{Line: 0, Char: 5, Offset: 5}},
+	}
+	for i, tt := range exp {
+		tok, pos, lit := s.Scan()
+		if tok != tt.tok || pos != tt.pos || lit != tt.lit {
+			t.Errorf("%d. token mismatch: exp=%s %#v %q got=%s %#v %q", i, tt.tok, tt.pos, tt.lit, tok, pos, lit)
+		}
+	}
+	if s.Hints != nil {
+		t.Errorf("unexpected hints after reset: %q", s.Hints)
+	}
+	if !s.SkipComments {
+		t.Errorf("expected SkipComments to be kept after reset")
+	}
+}
+
+// Ensure the scanner can push back and peek at the next token.
+func TestScanner_Unscan_Peek(t *testing.T) {
+	s := sp.NewScanner(strings.NewReader(`a = 1`))
+
+	if tok, pos, lit := s.Peek(); tok != sp.IDENT || pos != (sp.Pos{Line: 0, Char: 0}) || lit != "a" {
+		t.Fatalf("unexpected peek: %s %v %q", tok, pos, lit)
+	}
+	if tok, _, lit := s.Scan(); tok != sp.IDENT || lit != "a" {
+		t.Fatalf("unexpected scan after peek: %s %q", tok, lit)
+	}
+
+	// Only the last token is pushed back, however many times Unscan is called.
+	s.Unscan()
+	s.Unscan()
+	if tok, _, lit := s.Scan(); tok != sp.IDENT || lit != "a" {
+		t.Fatalf("unexpected scan after unscan: %s %q", tok, lit)
+	}
+	if tok, _, _ := s.Scan(); tok != sp.WS {
+		t.Fatalf("unexpected token: %s", tok)
+	}
+	if tok, _, _ := s.Peek(); tok != sp.EQ {
+		t.Fatalf("unexpected peek: %s", tok)
+	}
+	if tok, _, _ := s.Peek(); tok != sp.EQ {
+		t.Fatalf("unexpected repeated peek: %s", tok)
+	}
+	if tok, pos, _ := s.Scan(); tok != sp.EQ || pos != (sp.Pos{Line: 0, Char: 2, Offset: 2}) {
+		t.Fatalf("unexpected scan: %s %v", tok, pos)
+	}
+}
+
 // Ensure the library can correctly scan strings.
 func TestScanString(t *testing.T) {
 	var tests = []struct {
@@ -186,10 +511,25 @@ func TestScanString(t *testing.T) {
 		{in: `"foo\\bar"`, out: `foo\bar`},
 		{in: `"foo\"bar"`, out: `foo"bar`},
 		{in: `'foo\'bar'`, out: `foo'bar`},
+		{in: `'100\%'`, out: `100\%`},
+		{in: `'foo\_bar'`, out: `foo\_bar`},
+		{in: `"a\tb\rc\fd\be\0f"`, out: "a\tb\rc\fd\be\x00f"},
+		{in: `'http:\/\/host'`, out: `http://host`},
 
 		{in: `"foo` + "\n", out: `foo`, err: "bad string"}, // newline in string
 		{in: `"foo`, out: `foo`, err: "bad string"},        // unclosed quotes
 		{in: `"foo\xbar"`, out: `\x`, err: "bad escape"},   // invalid escape
+
+		// unicode escapes
+		{in: `"caf\u00e9"`, out: `café`},
+		{in: `'\u4E2D\u6587'`, out: `中文`},
+		{in: `"\ud83d\ude00!"`, out: "\U0001F600!"},                    // surrogate pair
+		{in: `"caf\u00e"`, out: `\u00e"`, err: "bad escape"},           // fewer than four hex digits
+		{in: `"\u12g4"`, out: `\u12g`, err: "bad escape"},              // invalid hex digit
+		{in: `"\u`, out: `\u`, err: "bad escape"},                      // unclosed escape
+		{in: `"\ud83d"`, out: `\ud83d"`, err: "bad escape"},            // high surrogate alone
+		{in: `"\ud83d\u0041"`, out: `\ud83d\u0041`, err: "bad escape"}, // high surrogate without low surrogate
+		{in: `"\ude00"`, out: `\ude00`, err: "bad escape"},             // low surrogate alone
 	}
 
 	for i, tt := range tests {
@@ -214,6 +554,29 @@ func TestScanNumber(t *testing.T) {
 		{s: `000.0000`, tok: sp.NUMBER, lit: `000.0000`},
 		{s: `100`, tok: sp.INTEGER, lit: `100`},
 		{s: `10.3`, tok: sp.NUMBER, lit: `10.3`},
+		{s: `1e5`, tok: sp.NUMBER, lit: `1e5`},
+		{s: `1.2e+3`, tok: sp.NUMBER, lit: `1.2e+3`},
+		{s: `3E-4`, tok: sp.NUMBER, lit: `3E-4`},
+		{s: `1e`, tok: sp.INTEGER, lit: `1`},
+		{s: `1.5e-`, tok: sp.NUMBER, lit: `1.5`},
+		{s: `2ex`, tok: sp.INTEGER, lit: `2`},
+		{s: `1_000_000`, tok: sp.INTEGER, lit: `1000000`},
+		{s: `1_000.000_1`, tok: sp.NUMBER, lit: `1000.0001`},
+		{s: `0xFF`, tok: sp.INTEGER, lit: `0xFF`},
+		{s: `0Xdead_beef`, tok: sp.INTEGER, lit: `0Xdeadbeef`},
+		{s: `1__0`, tok: sp.ILLEGAL, lit: `1__0`},
+		{s: `1_`, tok: sp.ILLEGAL, lit: `1_`},
+		{s: `0x`, tok: sp.ILLEGAL, lit: `0x`},
+		{s: `0x_1`, tok: sp.ILLEGAL, lit: `0x_1`},
+
+		// Durations
+		{s: `100ms`, tok: sp.DURATION, lit: `100ms`},
+		{s: `5m`, tok: sp.DURATION, lit: `5m`},
+		{s: `7d`, tok: sp.DURATION, lit: `7d`},
+		{s: `1h30m`, tok: sp.DURATION, lit: `1h30m`},
+		{s: `1h30`, tok: sp.ILLEGAL, lit: `1h30`},
+		{s: `10sec`, tok: sp.ILLEGAL, lit: `10sec`},
+		{s: `ms`, tok: sp.IDENT, lit: `ms`},
 	}
 
 	for i, tt := range tests {
@@ -227,6 +590,28 @@ func TestScanNumber(t *testing.T) {
 	}
 }
 
+// Ensure an exponent marker without digits is left for the next token.
+func TestScanNumber_ExponentBoundary(t *testing.T) {
+	exp := []struct {
+		tok sp.Token
+		lit string
+	}{
+		{tok: sp.INTEGER, lit: "1"},
+		{tok: sp.IDENT, lit: "e"},
+		{tok: sp.ADD, lit: ""},
+		{tok: sp.IDENT, lit: "x"},
+		{tok: sp.EOF, lit: ""},
+	}
+
+	s := sp.NewScanner(strings.NewReader(`1e+x`))
+	for i, tt := range exp {
+		tok, _, lit := s.Scan()
+		if tt.tok != tok || tt.lit != lit {
+			t.Fatalf("%d. token mismatch: exp=%s <%q> got=%s <%q>", i, tt.tok, tt.lit, tok, lit)
+		}
+	}
+}
+
 // Test scanning regex
 func TestScanRegex(t *testing.T) {
 	var tests = []struct {
@@ -253,3 +638,173 @@ func TestScanRegex(t *testing.T) {
 		}
 	}
 }
+
+// Test classifying tokens
+func TestToken_Classification(t *testing.T) {
+	var tests = []struct {
+		tok      sp.Token
+		operator bool
+		keyword  bool
+		literal  bool
+		prec     int
+	}{
+		{tok: sp.EOF},
+		{tok: sp.IDENT, literal: true},
+		{tok: sp.STRING, literal: true},
+		{tok: sp.NULL, literal: true},
+		{tok: sp.OR, operator: true, prec: 1},
+		{tok: sp.AND, operator: true, prec: 2},
+		{tok: sp.IN, operator: true, prec: 7},
+		{tok: sp.BITOR, operator: true, prec: 4},
+		{tok: sp.BITXOR, operator: true, prec: 5},
+		{tok: sp.BITAND, operator: true, prec: 6},
+		{tok: sp.LIKE, operator: true, prec: 7},
+		{tok: sp.GTE, operator: true, prec: 7},
+		{tok: sp.CONCAT, operator: true, prec: 8},
+		{tok: sp.SUB, operator: true, prec: 9},
+		{tok: sp.MOD, operator: true, prec: 10},
+		{tok: sp.LPAREN},
+		{tok: sp.SELECT, keyword: true},
+		{tok: sp.WHERE, keyword: true},
+	}
+
+	for i, tt := range tests {
+		if got := tt.tok.IsOperator(); got != tt.operator {
+			t.Errorf("%d. %s: IsOperator mismatch: exp=%v got=%v", i, tt.tok, tt.operator, got)
+		}
+		if got := tt.tok.IsKeyword(); got != tt.keyword {
+			t.Errorf("%d. %s: IsKeyword mismatch: exp=%v got=%v", i, tt.tok, tt.keyword, got)
+		}
+		if got := tt.tok.IsLiteral(); got != tt.literal {
+			t.Errorf("%d. %s: IsLiteral mismatch: exp=%v got=%v", i, tt.tok, tt.literal, got)
+		}
+		if got := tt.tok.Precedence(); got != tt.prec {
+			t.Errorf("%d. %s: Precedence mismatch: exp=%d got=%d", i, tt.tok, tt.prec, got)
+		}
+	}
+}
+
+// Test looking up keywords
+func TestLookup(t *testing.T) {
+	var tests = []struct {
+		ident   string
+		tok     sp.Token
+		keyword bool
+	}{
+		{ident: `select`, tok: sp.SELECT, keyword: true},
+		{ident: `Where`, tok: sp.WHERE, keyword: true},
+		{ident: `AND`, tok: sp.AND, keyword: true},
+		{ident: `null`, tok: sp.NULL, keyword: true},
+		{ident: `host`, tok: sp.IDENT},
+		{ident: `count`, tok: sp.IDENT},
+	}
+
+	for i, tt := range tests {
+		tok, ok := sp.Lookup(tt.ident)
+		if tok != tt.tok || ok != tt.keyword {
+			t.Errorf("%d. %s: lookup mismatch: exp=%s %v got=%s %v", i, tt.ident, tt.tok, tt.keyword, tok, ok)
+		}
+	}
+}
+
+// Test listing keywords
+func TestKeywords(t *testing.T) {
+	keywords := sp.Keywords()
+	if !sort.StringsAreSorted(keywords) {
+		t.Errorf("keywords not sorted: %v", keywords)
+	}
+	for _, kw := range keywords {
+		if _, ok := sp.Lookup(kw); !ok {
+			t.Errorf("%s: expected a keyword", kw)
+		}
+	}
+	for _, exp := range []string{"AND", "BY", "FALSE", "FROM", "GROUP", "NULL", "SELECT", "WHERE"} {
+		if i := sort.SearchStrings(keywords, exp); i == len(keywords) || keywords[i] != exp {
+			t.Errorf("%s: missing keyword", exp)
+		}
+	}
+}
+
+// largeQuery returns a generated query of about n bytes.
+func largeQuery(n int) string {
+	var buf strings.Builder
+	_, _ = buf.WriteString(`SELECT * FROM logs WHERE `)
+	for i := 0; buf.Len() < n; i++ {
+		if i > 0 {
+			_, _ = buf.WriteString(` OR `)
+		}
+		_, _ = fmt.Fprintf(&buf, `(host = 'web-%d' AND status IN (500, 502) /* 5xx */ AND latency > 1.5)`, i)
+	}
+	return buf.String()
+}
+
+// Ensure scanning a large generated query takes time linear in its size.
+func TestScanner_Scan_LargeInput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large input in short mode")
+	}
+	q := largeQuery(5 << 20)
+
+	start := time.Now()
+	s := sp.NewScanner(strings.NewReader(q))
+	n := 0
+	for {
+		tok, _, lit := s.Scan()
+		if tok == sp.EOF {
+			break
+		} else if tok == sp.ILLEGAL || tok == sp.BADSTRING {
+			t.Fatalf("unexpected token %s %q after %d tokens", tok, lit, n)
+		}
+		n++
+	}
+	if n < len(q)/10 {
+		t.Errorf("expected at least %d tokens, got %d", len(q)/10, n)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("scanning %d bytes took %s", len(q), elapsed)
+	}
+}
+
+func BenchmarkScanner_New(b *testing.B) {
+	q := `SELECT host, count(*) FROM logs WHERE status >= 500 GROUP BY host`
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := sp.NewScanner(strings.NewReader(q))
+		for {
+			if tok, _, _ := s.Scan(); tok == sp.EOF {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkScanner_Reset(b *testing.B) {
+	q := `SELECT host, count(*) FROM logs WHERE status >= 500 GROUP BY host`
+	r := strings.NewReader(q)
+	s := sp.NewScanner(r)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(q)
+		s.Reset(r)
+		for {
+			if tok, _, _ := s.Scan(); tok == sp.EOF {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkScanner_Scan(b *testing.B) {
+	q := largeQuery(1 << 20)
+	b.SetBytes(int64(len(q)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := sp.NewScanner(strings.NewReader(q))
+		for {
+			if tok, _, _ := s.Scan(); tok == sp.EOF {
+				break
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package sp
 
 import (
+	"sort"
 	"strings"
 )
 
@@ -16,16 +17,20 @@ const (
 
 	literalBeg
 	// IDENT and the following are InfluxQL literal tokens.
-	IDENT     // main
-	NUMBER    // 12345.67
-	INTEGER   // 12345
-	STRING    // "abc"
-	BADSTRING // "abc
-	BADESCAPE // \q
-	TRUE      // true
-	FALSE     // false
-	REGEX     // Regular expressions
-	BADREGEX  // `.*
+	IDENT      // main
+	NUMBER     // 12345.67
+	INTEGER    // 12345
+	DURATION   // 13h
+	STRING     // "abc"
+	BADSTRING  // "abc
+	BADESCAPE  // \q
+	TRUE       // true
+	FALSE      // false
+	NULL       // null
+	REGEX      // Regular expressions
+	BADREGEX   // `.*
+	COMMENT    // -- comment or /* comment */
+	BADCOMMENT // /* comment
 	literalEnd
 
 	operatorBeg
@@ -36,11 +41,21 @@ const (
 	DIV // /
 	MOD // %
 
+	BITAND // &
+	BITOR  // |
+	BITXOR // ^
+	CONCAT // ||
+
 	AND // AND
 	OR  // OR
 	NI  // not in
 	IN
 
+	LIKE    // LIKE
+	NLIKE   // NOT LIKE
+	BETWEEN // BETWEEN
+	IS      // IS
+
 	EQ       // =
 	NEQ      // !=
 	EQREGEX  // =~
@@ -60,16 +75,35 @@ const (
 
 	keywordBeg
 	// ALL and the following are InfluxQL Keywords
+	AFTER
 	AS
 	ASC
 	BY
+	CASE
+	COLLAPSE
+	COMPOSITE
 	DESC
+	DISTINCT
+	ELSE
+	END
+	FILTER
+	FIRST
 	FROM
 	GROUP
 	HAVING
+	HIGHLIGHT
+	JOIN
+	LAST
 	LIMIT
+	NOT
+	NULLS
+	OFFSET
 	ORDER
+	POSTFILTER
 	SELECT
+	THEN
+	UNION
+	WHEN
 	WHERE
 	keywordEnd
 )
@@ -79,15 +113,19 @@ var tokens = [...]string{
 	EOF:     "EOF",
 	WS:      "WS",
 
-	IDENT:     "IDENT",
-	NUMBER:    "NUMBER",
-	INTEGER:   "INTEGER",
-	STRING:    "STRING",
-	BADSTRING: "BADSTRING",
-	BADESCAPE: "BADESCAPE",
-	TRUE:      "TRUE",
-	FALSE:     "FALSE",
-	REGEX:     "REGEX",
+	IDENT:      "IDENT",
+	NUMBER:     "NUMBER",
+	INTEGER:    "INTEGER",
+	DURATION:   "DURATION",
+	STRING:     "STRING",
+	BADSTRING:  "BADSTRING",
+	BADESCAPE:  "BADESCAPE",
+	TRUE:       "TRUE",
+	FALSE:      "FALSE",
+	NULL:       "NULL",
+	REGEX:      "REGEX",
+	COMMENT:    "COMMENT",
+	BADCOMMENT: "BADCOMMENT",
 
 	ADD: "+",
 	SUB: "-",
@@ -95,11 +133,21 @@ var tokens = [...]string{
 	DIV: "/",
 	MOD: "%",
 
+	BITAND: "&",
+	BITOR:  "|",
+	BITXOR: "^",
+	CONCAT: "||",
+
 	AND: "AND",
 	OR:  "OR",
-	NI:  "NI",
+	NI:  "NOT IN",
 	IN:  "IN",
 
+	LIKE:    "LIKE",
+	NLIKE:   "NOT LIKE",
+	BETWEEN: "BETWEEN",
+	IS:      "IS",
+
 	EQ:       "=",
 	NEQ:      "!=",
 	EQREGEX:  "=~",
@@ -116,17 +164,36 @@ var tokens = [...]string{
 	COMMA:    ",",
 	DOT:      ".",
 
-	AS:     "AS",
-	ASC:    "ASC",
-	BY:     "BY",
-	DESC:   "DESC",
-	FROM:   "FROM",
-	GROUP:  "GROUP",
-	HAVING: "HAVING",
-	LIMIT:  "LIMIT",
-	ORDER:  "ORDER",
-	SELECT: "SELECT",
-	WHERE:  "WHERE",
+	AFTER:      "AFTER",
+	AS:         "AS",
+	ASC:        "ASC",
+	BY:         "BY",
+	CASE:       "CASE",
+	COLLAPSE:   "COLLAPSE",
+	COMPOSITE:  "COMPOSITE",
+	DESC:       "DESC",
+	DISTINCT:   "DISTINCT",
+	ELSE:       "ELSE",
+	END:        "END",
+	FILTER:     "FILTER",
+	FIRST:      "FIRST",
+	FROM:       "FROM",
+	GROUP:      "GROUP",
+	HAVING:     "HAVING",
+	HIGHLIGHT:  "HIGHLIGHT",
+	JOIN:       "JOIN",
+	LAST:       "LAST",
+	LIMIT:      "LIMIT",
+	NOT:        "NOT",
+	NULLS:      "NULLS",
+	OFFSET:     "OFFSET",
+	ORDER:      "ORDER",
+	POSTFILTER: "POST_FILTER",
+	SELECT:     "SELECT",
+	THEN:       "THEN",
+	UNION:      "UNION",
+	WHEN:       "WHEN",
+	WHERE:      "WHERE",
 }
 
 var keywords map[string]Token
@@ -136,11 +203,13 @@ func init() {
 	for tok := keywordBeg + 1; tok < keywordEnd; tok++ {
 		keywords[strings.ToLower(tokens[tok])] = tok
 	}
-	for _, tok := range []Token{AND, OR, IN, NI} {
+	for _, tok := range []Token{AND, OR, IN, LIKE, BETWEEN, IS} {
 		keywords[strings.ToLower(tokens[tok])] = tok
 	}
+	keywords["ni"] = NI
 	keywords["true"] = TRUE
 	keywords["false"] = FALSE
+	keywords["null"] = NULL
 }
 
 // String returns the string representation of the token.
@@ -161,20 +230,33 @@ func (tok Token) Precedence() int {
 		return 1
 	case AND:
 		return 2
-	case IN, NI:
-		return 3
-	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE:
+	case BITOR:
 		return 4
-	case ADD, SUB:
+	case BITXOR:
 		return 5
-	case MUL, DIV, MOD:
+	case BITAND:
 		return 6
+	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE, LIKE, NLIKE, IN, NI, BETWEEN, IS:
+		return 7
+	case CONCAT:
+		return 8
+	case ADD, SUB:
+		return 9
+	case MUL, DIV, MOD:
+		return 10
 	}
 	return 0
 }
 
-// isOperator returns true for operator tokens.
-func (tok Token) isOperator() bool { return tok > operatorBeg && tok < operatorEnd }
+// IsOperator returns true for operator tokens.
+func (tok Token) IsOperator() bool { return tok > operatorBeg && tok < operatorEnd }
+
+// IsKeyword returns true for keyword tokens. Word operators such as AND and
+// LIKE are operators, not keywords.
+func (tok Token) IsKeyword() bool { return tok > keywordBeg && tok < keywordEnd }
+
+// IsLiteral returns true for literal tokens, including identifiers.
+func (tok Token) IsLiteral() bool { return tok > literalBeg && tok < literalEnd }
 
 // tokstr returns a literal if provided, otherwise returns the token string.
 func tokstr(tok Token, lit string) string {
@@ -184,17 +266,30 @@ func tokstr(tok Token, lit string) string {
 	return tok.String()
 }
 
-// Lookup returns the token associated with a given string.
-func Lookup(ident string) Token {
+// Lookup returns the token associated with a given string and whether the
+// string is a keyword. Other strings are identifiers.
+func Lookup(ident string) (Token, bool) {
 	if tok, ok := keywords[strings.ToLower(ident)]; ok {
-		return tok
+		return tok, true
+	}
+	return IDENT, false
+}
+
+// Keywords returns the sorted list of recognized keywords in upper case.
+func Keywords() []string {
+	a := make([]string, 0, len(keywords))
+	for k := range keywords {
+		a = append(a, strings.ToUpper(k))
 	}
-	return IDENT
+	sort.Strings(a)
+	return a
 }
 
 // Pos specifies the line and character position of a token.
-// The Char and Line are both zero-based indexes.
+// The Char and Line are both zero-based indexes, Char counts runes.
+// Offset is the zero-based byte offset from the start of the input.
 type Pos struct {
-	Line int
-	Char int
+	Line   int
+	Char   int
+	Offset int
 }
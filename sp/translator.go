@@ -1,6 +1,8 @@
 package sp
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -26,6 +28,7 @@ const (
 	Min
 	Percentiles
 	PercentileRanks
+	ScriptedMetric
 	Stats
 	Sum
 	Top
@@ -36,6 +39,7 @@ const (
 
 	bucketBegin
 	//bucket aggregations method
+	Composite
 	DateHistogram
 	DateRange
 	Filter
@@ -58,6 +62,9 @@ const (
 	pipelineBegin
 	BucketScript
 	BucketSelector
+	CumulativeSum
+	Derivative
+	MovingFn
 	pipelineEnd
 )
 
@@ -73,15 +80,17 @@ var aggs = [...]string{
 	Min:             "min",
 	Percentiles:     "percentiles",
 	PercentileRanks: "percentile_ranks",
+	ScriptedMetric:  "scripted_metric",
 	Stats:           "stats",
 	Sum:             "sum",
-	Top:             "top",
+	Top:             "top_hits",
 	ValueCount:      "value_count",
 	// StarCount:       "star_count",
 
+	Composite:        "composite",
 	DateHistogram:    "date_histogram",
 	DateRange:        "date_range",
-	Filter:           "date_range",
+	Filter:           "filter",
 	Filters:          "filters",
 	GeoDistance:      "geo_distance",
 	GeoHashGrid:      "geohash_grid",
@@ -98,6 +107,9 @@ var aggs = [...]string{
 
 	BucketScript:   "bucket_script",
 	BucketSelector: "bucket_selector",
+	CumulativeSum:  "cumulative_sum",
+	Derivative:     "derivative",
+	MovingFn:       "moving_fn",
 }
 
 // Agg .
@@ -105,6 +117,7 @@ type Agg struct {
 	name   string
 	typ    ESAgg
 	params map[string]interface{}
+	aggs   Aggs
 }
 
 //Aggs .
@@ -125,104 +138,332 @@ func (s *SelectStatement) isGroupBySort(f string) bool {
 	return false
 }
 
-func (s *SelectStatement) isStarCount(f string) bool {
+// sortMetric returns the metric field sorted by f, an alias or a call.
+func (s *SelectStatement) sortMetric(f string) *Field {
+	if field, ok := s.aliasTable()[f]; ok {
+		if _, ok := field.Expr.(*Call); ok {
+			return field
+		}
+		return nil
+	}
 	for _, field := range s.Fields {
-		fn, ok := field.Expr.(*Call)
-		if !ok {
-			continue
+		if fn, ok := field.Expr.(*Call); ok && fn.String() == f {
+			return field
 		}
-		switch fn.Name {
-		case "count":
-			if fn.Args[0].String() == "*" && field.Alias == f {
-				return true
-			}
+	}
+	return nil
+}
+
+// bucketsPath returns the path of f within a bucket, used as terms order key
+// and bucket_selector buckets_path: _key for a dimension, _count for count(*)
+// or else the metric aggregation name.
+func (s *SelectStatement) bucketsPath(f string) string {
+	if s.isGroupBySort(f) {
+		return "_key"
+	}
+	field := s.sortMetric(f)
+	if field == nil {
+		return f
+	}
+	return field.bucketsPath()
+}
+
+// bucketsPath returns the path of the metric aggregation computed by the
+// field within a bucket.
+func (f *Field) bucketsPath() string {
+	fn := f.Expr.(*Call)
+	switch {
+	case fn.Name == "nested":
+		agg := f.nestedAggregation()
+		if len(agg.aggs) == 0 {
+			return agg.name + ">_count"
+		}
+		return agg.name + ">" + agg.aggs[0].name
+	case f.Filter != nil:
+		if fn.metricAggType() == StarCount {
+			return "filtered_" + f.metricAggName() + ">_count"
 		}
+		return "filtered_" + f.metricAggName() + ">" + f.metricAggName()
+	case fn.metricAggType() == StarCount:
+		return "_count"
 	}
-	return false
+	return f.metricAggName()
 }
 
-func (s *SelectStatement) orders() []map[string]string {
+// orders returns the terms aggregation order of dimension dim, keeping the
+// order and direction of the sort fields. Other dimensions are skipped as
+// _key only sorts the buckets of dim.
+func (s *SelectStatement) orders(dim string) []map[string]string {
 	order := make([]map[string]string, 0, len(s.SortFields))
 	for _, sf := range s.SortFields {
-		if s.isGroupBySort(sf.Name) {
-			sf.Name = "_term"
-		}
-		if s.isStarCount(sf.Name) {
-			sf.Name = "_count"
+		if sf.Name != dim && s.isGroupBySort(sf.Name) {
+			continue
 		}
+		key := s.bucketsPath(sf.Name)
 		m := make(map[string]string)
 		if sf.Ascending {
-			m[sf.Name] = "asc"
+			m[key] = "asc"
 		} else {
-			m[sf.Name] = "desc"
+			m[key] = "desc"
 		}
 		order = append(order, m)
 	}
 	return order
 }
 
+// Options configures the translation of a query.
+type Options struct {
+	// Pretty indents the returned json.
+	Pretty bool
+
+	// DefaultSize is the number of hits returned by a query without LIMIT.
+	DefaultSize int
+
+	// TrackTotalHits counts all the hits, es caps the total at 10000 otherwise.
+	// It is also set by the /*+ track_total_hits */ hint, counting every hit
+	// may be slow on large indices.
+	TrackTotalHits bool
+
+	// ForceFilter puts every WHERE predicate in filter context, full-text
+	// queries such as MATCH() then only select the hits and don't score them.
+	ForceFilter bool
+}
+
 //EsDsl return dsl json string
 func EsDsl(sql string) (string, error) {
+	return Translate(sql, nil)
+}
+
+// Translate returns the dsl of sql as a json string, a nil opts uses the
+// zero Options.
+func Translate(sql string, opts *Options) (string, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	dsl, err := esDslMap(sql, opts)
+	if err != nil {
+		return "", err
+	}
 
-	stmt, err := ParseStatement(sql)
+	var _s []byte
+	if opts.Pretty {
+		_s, err = json.MarshalIndent(dsl, "", "  ")
+	} else {
+		_s, err = json.Marshal(dsl)
+	}
 	if err != nil {
 		return "", err
 	}
-	// fmt.Println(stmt)
-	s, ok := stmt.(*SelectStatement)
-	if !ok {
-		return "", fmt.Errorf("only support select")
+	return string(_s), nil
+}
+
+// EsDslMap returns the dsl of sql as a map, to be merged with other queries
+// before encoding.
+func EsDslMap(sql string) (map[string]interface{}, error) {
+	return esDslMap(sql, &Options{})
+}
+
+// DslMap returns the dsl of a parsed statement as a map, a nil opts uses the
+// zero Options. The translation rewrites the field names of the statement.
+func (s *SelectStatement) DslMap(opts *Options) (map[string]interface{}, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return s.dslMap(opts)
+}
+
+func esDslMap(sql string, opts *Options) (map[string]interface{}, error) {
+	s, err := ParseSelectStatement(sql)
+	if err != nil {
+		return nil, err
 	}
+	return s.dslMap(opts)
+}
+
+// MultiSearch returns the _msearch body of the SELECT statements of sql
+// combined with UNION: a header line naming the index followed by the dsl of
+// the search, for each statement. A nil opts uses the zero Options, the body
+// is never indented.
+func MultiSearch(sql string, opts *Options) (string, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	stmts, err := ParseUnion(sql)
+	if err != nil {
+		return "", err
+	}
+	if err := validateUnion(stmts); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, s := range stmts {
+		dsl, err := s.dslMap(opts)
+		if err != nil {
+			return "", err
+		}
+		header, err := json.Marshal(map[string]string{"index": s.Index()})
+		if err != nil {
+			return "", err
+		}
+		body, err := json.Marshal(dsl)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(header)
+		buf.WriteByte('\n')
+		buf.Write(body)
+		buf.WriteByte('\n')
+	}
+	return buf.String(), nil
+}
+
+// validateUnion ensures the results of the searches can be merged as the rows
+// of a single query: documents or aggregation buckets alike, with the same
+// number of columns.
+func validateUnion(stmts []*SelectStatement) error {
+	first := stmts[0]
+	for _, s := range stmts {
+		if s.IsCountQuery() {
+			return fmt.Errorf("invalid UNION, COUNT(*) without GROUP BY is sent to the _count endpoint")
+		}
+		if s.IsRawQuery != first.IsRawQuery {
+			return fmt.Errorf("invalid UNION, can't combine documents with aggregation buckets")
+		}
+		if s.hasWildcard() || first.hasWildcard() {
+			continue
+		}
+		if len(s.Fields) != len(first.Fields) {
+			return fmt.Errorf("invalid UNION, each SELECT must have the same number of columns")
+		}
+	}
+	return nil
+}
+
+// hasWildcard reports if the statement selects whole documents.
+func (s *SelectStatement) hasWildcard() bool {
+	for _, f := range s.Fields {
+		if _, ok := f.Expr.(*Wildcard); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dslMap returns the dsl of the statement as a map.
+func (s *SelectStatement) dslMap(opts *Options) (map[string]interface{}, error) {
 	s.RewriteConditions()
 
 	js := simplejson.New()
+	// the _count endpoint only takes the query
+	if s.IsCountQuery() {
+		if s.Condition != nil {
+			q, err := conditionQuery(s.Condition)
+			if err != nil {
+				return nil, err
+			}
+			js.SetPath([]string{"query", "bool", "filter"}, q)
+		}
+		return js.MustMap(), nil
+	}
+
+	if opts.TrackTotalHits || s.HasHint("track_total_hits") {
+		js.Set("track_total_hits", true)
+	}
 
-	if len(s.Dimensions) == 0 {
+	// Only the aggregations of a query without raw fields are read, it returns
+	// hits only if a LIMIT asks for them.
+	if len(s.Dimensions) == 0 && !s.Dedupe && (s.IsRawQuery || s.Limit > 0) {
 		//from and size
 		js.Set("from", s.Offset)
 		js.Set("size", s.Limit)
+		if !s.HasLimit && opts.DefaultSize > 0 {
+			js.Set("size", opts.DefaultSize)
+		}
 		//sort
-		sort := make([]map[string]string, 0, len(s.SortFields))
-		for _, sf := range s.SortFields {
-			m := make(map[string]string)
-			if sf.Ascending {
-				m[sf.Name] = "asc"
-			} else {
-				m[sf.Name] = "desc"
+		js.Set("sort", sortClauses(s.hitSortFields()))
+		//fields
+		if source := s.sourceFields(); len(source) > 0 {
+			js.Set("_source", source)
+		}
+		//scirpt fields
+		sf, err := s.scriptFields()
+		if err != nil {
+			return nil, err
+		}
+		if len(sf) > 0 {
+			js.Set("script_fields", sf)
+		}
+		//collapse
+		if c := s.Collapse; c != nil {
+			js.SetPath([]string{"collapse", "field"}, c.Field)
+			if c.Size > 0 {
+				js.SetPath([]string{"collapse", "inner_hits"}, map[string]interface{}{"name": c.Field, "size": c.Size})
+			}
+		}
+		//highlight
+		if h := s.Highlight; h != nil {
+			fields := make(map[string]interface{}, len(h.Fields))
+			for _, f := range h.Fields {
+				fields[f] = map[string]interface{}{}
+			}
+			js.SetPath([]string{"highlight", "fields"}, fields)
+			if h.FragmentSize > 0 {
+				js.SetPath([]string{"highlight", "fragment_size"}, h.FragmentSize)
+			}
+			if h.Fragments > 0 {
+				js.SetPath([]string{"highlight", "number_of_fragments"}, h.Fragments)
 			}
-			sort = append(sort, m)
 		}
-		js.Set("sort", sort)
 	} else {
 		js.Set("size", 0)
 	}
 
-	//fields
 	//scirpt fields
 
-	//query
-	if s.Condition != nil {
-		branch := []string{"query", "bool", "filter", "script", "script"}
-		js.SetPath(branch, s.Condition.String())
+	//query, the dimension fields must exist in the bucketed documents
+	var fieldFilters []map[string]interface{}
+	for _, f := range s.NamesInDimension() {
+		_js := simplejson.New()
+		existsBranch := []string{"exists", "field"}
+		_js.SetPath(existsBranch, f)
+		fieldFilters = append(fieldFilters, _js.MustMap())
 	}
-	fields := s.NamesInDimension()
-	// fmt.Println(fields)
-	if len(fields) > 0 {
-		fieldFilters := make([]map[string]interface{}, 0)
-		branch := []string{"query", "bool", "filter", "and"}
-		for _, f := range fields {
-			_js := simplejson.New()
-			existsBranch := []string{"exists", "field"}
-			_js.SetPath(existsBranch, f)
-			fieldFilters = append(fieldFilters, _js.MustMap())
+	if s.Condition != nil || len(fieldFilters) > 0 {
+		q, err := whereQuery(s.Condition, opts.ForceFilter, fieldFilters...)
+		if err != nil {
+			return nil, err
 		}
-		js.SetPath(branch, fieldFilters)
+		js.SetPath([]string{"query", "bool"}, q)
+	}
+	// the random score only orders hits, don't compute it without any
+	if q := s.randomScoreQuery(js.Get("query").Interface()); q != nil && js.Get("size").MustInt() > 0 {
+		js.Set("query", q)
+	}
+	//post filter, applied to the hits once the aggregations are computed
+	if s.PostFilter != nil {
+		q, err := conditionQuery(s.PostFilter)
+		if err != nil {
+			return nil, err
+		}
+		js.Set("post_filter", q)
 	}
 
 	// build Aggregations
 	path := []string{"aggs"}
+	//distinct values Aggregation
+	if s.Dedupe {
+		a := s.distinctAggregation()
+		js.SetPath(append(path, a.name, aggs[a.typ]), a.params)
+	}
 	//bucket Aggregations
 	baggs := s.bucketAggregations()
+	if s.Composite {
+		a := s.compositeAggregation(baggs)
+		js.SetPath(append(path, a.name, aggs[a.typ]), a.params)
+		path = append(path, a.name, "aggs")
+		baggs = nil
+	}
 	for _, a := range baggs {
 		_path := append(path, []string{a.name, aggs[a.typ]}...)
 		js.SetPath(_path, a.params)
@@ -232,25 +473,323 @@ func EsDsl(sql string) (string, error) {
 		// }
 		path = append(path, a.name, "aggs")
 	}
-	//metric Aggregations
-	maggs := s.metricAggs()
+	//metric Aggregations, nested under the innermost bucket aggregation
+	maggs, err := s.metricAggs()
+	if err != nil {
+		return nil, err
+	}
 	for _, a := range maggs {
 		if a.typ == StarCount {
-			js.SetPath(path, make(map[string]string, 0))
+			// count(*) is the bucket doc_count, keep sibling metrics already set.
+			if js.GetPath(path...).Interface() == nil {
+				js.SetPath(path, make(map[string]interface{}))
+			}
 			continue
 		}
 		_path := append(path, []string{a.name, aggs[a.typ]}...)
 		js.SetPath(_path, a.params)
+		for _, sub := range a.aggs {
+			js.SetPath(append(path, a.name, "aggs", sub.name, aggs[sub.typ]), sub.params)
+		}
 	}
 
-	_s, err := js.MarshalJSON()
-	if err != nil {
-		return "", err
+	return js.Map()
+}
+
+// hitSortFields returns the sort fields of the hits, an alias of the select
+// sorts by the field it names. Other names are sorted as fields of the index,
+// the hits may be sorted by a field which isn't selected.
+func (s *SelectStatement) hitSortFields() SortFields {
+	aliases := s.aliasTable()
+	fields := make(SortFields, 0, len(s.SortFields))
+	for _, sf := range s.SortFields {
+		if f, ok := aliases[sf.Name]; ok {
+			if ref, ok := f.Expr.(*VarRef); ok {
+				resolved := *sf
+				resolved.Name = ref.Val
+				sf = &resolved
+			}
+		}
+		fields = append(fields, sf)
 	}
-	// t, _ := json.MarshalIndent(js.MustMap(), "", "  ")
-	// fmt.Println(string(t))
+	return fields
+}
 
-	return string(_s), nil
+// sortClauses returns the es sort of the sort fields.
+func sortClauses(fields SortFields) []map[string]interface{} {
+	sort := make([]map[string]interface{}, 0, len(fields))
+	for _, sf := range fields {
+		order := "desc"
+		if sf.Ascending {
+			order = "asc"
+		}
+		// a random order sorts by the random score of the function_score query
+		name := sf.Name
+		if sf.Random != nil {
+			name = "_score"
+		}
+		m := make(map[string]interface{})
+		switch sf.Nulls {
+		case FIRST:
+			m[name] = map[string]string{"order": order, "missing": "_first"}
+		case LAST:
+			m[name] = map[string]string{"order": order, "missing": "_last"}
+		default:
+			m[name] = order
+		}
+		sort = append(sort, m)
+	}
+	return sort
+}
+
+// randomScoreQuery returns the function_score query wrapping query to score
+// the hits randomly for ORDER BY RANDOM([seed]), nil without a random order.
+// The random score replaces the score of query, a nil query matches all.
+func (s *SelectStatement) randomScoreQuery(query interface{}) map[string]interface{} {
+	var random *Call
+	for _, sf := range s.SortFields {
+		if sf.Random != nil {
+			random = sf.Random
+		}
+	}
+	if random == nil {
+		return nil
+	}
+
+	if query == nil {
+		query = map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+	score := make(map[string]interface{})
+	if len(random.Args) == 1 {
+		// es requires a field with unique values to seed the score
+		score["seed"] = random.Args[0].(*IntegerLiteral).Val
+		score["field"] = "_seq_no"
+	}
+	return map[string]interface{}{
+		"function_score": map[string]interface{}{
+			"query":        query,
+			"random_score": score,
+			"boost_mode":   "replace",
+		},
+	}
+}
+
+// sourceFields returns the fields selected by a query without aggregations,
+// used to filter the returned _source. A wildcard selects the whole document
+// and returns nil.
+func (s *SelectStatement) sourceFields() []string {
+	if len(s.FunctionCalls()) > 0 {
+		return nil
+	}
+	var a []string
+	seen := make(map[string]struct{})
+	for _, f := range s.Fields {
+		switch expr := f.Expr.(type) {
+		case *Wildcard:
+			return nil
+		case *VarRef:
+			if _, ok := seen[expr.Val]; !ok {
+				seen[expr.Val] = struct{}{}
+				a = append(a, expr.Val)
+			}
+		}
+	}
+	return a
+}
+
+// scriptFields returns the script_fields computing the arithmetic fields of a
+// query without aggregations, keyed by the field alias.
+func (s *SelectStatement) scriptFields() (map[string]interface{}, error) {
+	if len(s.FunctionCalls()) > 0 {
+		return nil, nil
+	}
+	m := make(map[string]interface{})
+	for _, f := range s.Fields {
+		switch f.Expr.(type) {
+		case *BinaryExpr, *ParenExpr, *CaseExpr:
+		default:
+			continue
+		}
+		inline, err := painlessScript(f.Expr)
+		if err != nil {
+			return nil, err
+		}
+		name := f.Alias
+		if name == "" {
+			name = f.Expr.String()
+		}
+		sm := make(map[string]string)
+		sm["lang"] = "painless"
+		sm["inline"] = inline
+		m[name] = map[string]interface{}{"script": sm}
+	}
+	return m, nil
+}
+
+// painlessScript returns the painless source of an arithmetic expression
+// between fields and numeric literals, or of a CASE expression.
+func painlessScript(expr Expr) (string, error) {
+	switch expr := expr.(type) {
+	case *VarRef:
+		return expr.GroovyWrapped(), nil
+	case *IntegerLiteral, *NumberLiteral:
+		return expr.String(), nil
+	case *CaseExpr:
+		return painlessCase(expr)
+	case *ParenExpr:
+		inner, err := painlessScript(expr.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "(" + inner + ")", nil
+	case *BinaryExpr:
+		switch expr.Op {
+		case CONCAT:
+			return painlessConcat(expr)
+		case ADD, SUB, MUL, DIV, MOD, BITAND, BITOR, BITXOR:
+		default:
+			return "", &ParseError{Message: fmt.Sprintf("invalid field, unsupport op %s in script field %s", expr.Op, expr), Pos: expr.Pos()}
+		}
+		lhs, err := painlessScript(expr.LHS)
+		if err != nil {
+			return "", err
+		}
+		rhs, err := painlessScript(expr.RHS)
+		if err != nil {
+			return "", err
+		}
+		return lhs + " " + expr.Op.String() + " " + rhs, nil
+	}
+	return "", &ParseError{Message: fmt.Sprintf("invalid field, unsupport expression %s in script field", expr), Pos: expr.Pos()}
+}
+
+// painlessConcat returns the painless source of a string concatenation
+// "a || '-' || b". The operands are added to an empty string, unless the
+// first is a string, so that numbers are not summed.
+func painlessConcat(expr *BinaryExpr) (string, error) {
+	exprs := concatOperands(expr)
+	var operands []string
+	if _, ok := exprs[0].(*StringLiteral); !ok {
+		operands = append(operands, "''")
+	}
+	for _, e := range exprs {
+		switch e := e.(type) {
+		case *StringLiteral:
+			operands = append(operands, QuoteString(e.Val))
+		case *VarRef, *IntegerLiteral, *NumberLiteral:
+			s, _ := painlessScript(e)
+			operands = append(operands, s)
+		default:
+			s, err := painlessScript(e)
+			if err != nil {
+				return "", err
+			}
+			operands = append(operands, "("+s+")")
+		}
+	}
+	return strings.Join(operands, " + "), nil
+}
+
+// concatOperands returns the operands of a chain of || concatenations.
+func concatOperands(expr Expr) []Expr {
+	if e, ok := expr.(*BinaryExpr); ok && e.Op == CONCAT {
+		return append(concatOperands(e.LHS), concatOperands(e.RHS)...)
+	}
+	return []Expr{expr}
+}
+
+// painlessOperators are the painless operators of the CASE conditions.
+var painlessOperators = map[Token]string{
+	AND: "&&",
+	OR:  "||",
+	EQ:  "==",
+	NEQ: "!=",
+	LT:  "<",
+	LTE: "<=",
+	GT:  ">",
+	GTE: ">=",
+}
+
+// painlessCase returns the painless source of a CASE expression as chained
+// conditional operators, a missing ELSE is null.
+func painlessCase(expr *CaseExpr) (string, error) {
+	script := "null"
+	if expr.Else != nil {
+		var err error
+		if script, err = painlessValue(expr.Else); err != nil {
+			return "", err
+		}
+	}
+	for i := len(expr.WhenClauses) - 1; i >= 0; i-- {
+		w := expr.WhenClauses[i]
+		cond, err := painlessCondition(w.Condition)
+		if err != nil {
+			return "", err
+		}
+		result, err := painlessValue(w.Result)
+		if err != nil {
+			return "", err
+		}
+		if i < len(expr.WhenClauses)-1 {
+			script = "(" + script + ")"
+		}
+		script = cond + " ? " + result + " : " + script
+	}
+	return script, nil
+}
+
+// painlessCondition returns the painless source of a CASE WHEN condition.
+func painlessCondition(expr Expr) (string, error) {
+	switch expr := expr.(type) {
+	case *BooleanLiteral:
+		return expr.String(), nil
+	case *ParenExpr:
+		inner, err := painlessCondition(expr.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "(" + inner + ")", nil
+	case *NotExpr:
+		inner, err := painlessCondition(expr.Expr)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := expr.Expr.(*ParenExpr); ok {
+			return "!" + inner, nil
+		}
+		return "!(" + inner + ")", nil
+	case *BinaryExpr:
+		op, ok := painlessOperators[expr.Op]
+		if !ok {
+			break
+		}
+		value := painlessValue
+		if expr.Op == AND || expr.Op == OR {
+			value = painlessCondition
+		}
+		lhs, err := value(expr.LHS)
+		if err != nil {
+			return "", err
+		}
+		rhs, err := value(expr.RHS)
+		if err != nil {
+			return "", err
+		}
+		return lhs + " " + op + " " + rhs, nil
+	}
+	return "", &ParseError{Message: fmt.Sprintf("invalid CASE condition %s", expr), Pos: expr.Pos()}
+}
+
+// painlessValue returns the painless source of a CASE result or compared
+// operand, a string or boolean literal or else an arithmetic expression.
+func painlessValue(expr Expr) (string, error) {
+	switch expr := expr.(type) {
+	case *StringLiteral:
+		return QuoteString(expr.Val), nil
+	case *BooleanLiteral:
+		return expr.String(), nil
+	}
+	return painlessScript(expr)
 }
 
 // replace all doc['xxx'].value to xxx
@@ -260,31 +799,117 @@ func cleanDocString(s string) string {
 	return l
 }
 
+// fieldName returns the es field name of a field argument, the unquoted path
+// of a field reference.
+func fieldName(expr Expr) string {
+	if ref, ok := expr.(*VarRef); ok {
+		return cleanDocString(ref.Val)
+	}
+	return cleanDocString(expr.String())
+}
+
+// BucketSelectorAggregation returns the bucket_selector pipeline aggregation
+// filtering buckets by the having clause.
 func (s *SelectStatement) BucketSelectorAggregation() *Agg {
 	if s.Having == nil {
 		return nil
 	}
-	s.RewriteHaving()
-	// fieldAsNames := s.Fields.AliasNames()
-	havingNames := s.NamesInHaving()
 	agg := &Agg{}
 	agg.name = "having"
 	agg.typ = BucketSelector
 	agg.params = make(map[string]interface{})
+	bm := make(map[string]string)
+	for _, ref := range havingRefs(s.Having) {
+		bm[ref.Val] = s.bucketsPath(ref.Val)
+	}
+	// metrics called in having are referenced by path variables in the script
+	inlineExpr := cleanDocString(painlessString(s.Having))
+	for i, fn := range walkFunctionCalls(s.Having) {
+		name := cleanDocString(fn.String())
+		if !strings.Contains(inlineExpr, name) {
+			continue
+		}
+		path := fmt.Sprintf("path%d", i)
+		if fn.metricAggType() == StarCount {
+			bm[path] = "_count"
+		} else if f := s.metricField(fn); f != nil {
+			bm[path] = f.bucketsPath()
+		} else {
+			bm[path] = (&Field{Expr: fn}).metricAggName()
+		}
+		inlineExpr = strings.Replace(inlineExpr, name, path, -1)
+	}
 	sm := make(map[string]string)
 	sm["lang"] = "expression"
-	sm["inline"] = cleanDocString(s.Having.String())
+	sm["inline"] = inlineExpr
 	agg.params["script"] = sm
-	bm := make(map[string]string)
-	for _, name := range havingNames {
-		if s.isStarCount(name) {
-			bm[name] = "_count"
+	agg.params["buckets_path"] = bm
+
+	return agg
+}
+
+// metricField returns the select field computing the metric call fn, or nil.
+func (s *SelectStatement) metricField(fn *Call) *Field {
+	for _, f := range s.Fields {
+		if c, ok := f.Expr.(*Call); ok && cleanDocString(c.String()) == cleanDocString(fn.String()) {
+			return f
+		}
+	}
+	return nil
+}
+
+// havingMetricAggs returns the metric aggregations called in the having
+// clause which are not computed by a select field.
+func (s *SelectStatement) havingMetricAggs() Aggs {
+	var aggs Aggs
+	if s.Having == nil {
+		return aggs
+	}
+	for _, fn := range walkFunctionCalls(s.Having) {
+		if fn.metricAggType() == StarCount || s.metricField(fn) != nil {
 			continue
 		}
-		bm[name] = name
+		agg := &Agg{}
+		agg.name = (&Field{Expr: fn}).metricAggName()
+		agg.typ = fn.metricAggType()
+		agg.params = fn.metricAggParams()
+
+		aggs = append(aggs, agg)
 	}
-	agg.params["buckets_path"] = bm
+	return aggs
+}
+
+// distinctSize is the bucket size used for SELECT DISTINCT without LIMIT.
+const distinctSize = 10000
 
+// distinctAggregation returns the aggregation listing distinct values of a
+// SELECT DISTINCT statement, a composite aggregation for multiple fields.
+func (s *SelectStatement) distinctAggregation() *Agg {
+	size := s.Limit
+	if size == 0 {
+		size = distinctSize
+	}
+
+	agg := &Agg{}
+	agg.params = make(map[string]interface{})
+	agg.params["size"] = size
+	if len(s.Fields) == 1 {
+		agg.name = s.Fields[0].Name()
+		agg.typ = Terms
+		agg.params["field"] = fieldName(s.Fields[0].Expr)
+		return agg
+	}
+
+	sources := make([]map[string]interface{}, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		terms := map[string]interface{}{"field": fieldName(f.Expr)}
+		sources = append(sources, map[string]interface{}{
+			f.Name(): map[string]interface{}{"terms": terms},
+		})
+	}
+	agg.name = "distinct"
+	agg.typ = Composite
+	agg.params["sources"] = sources
 	return agg
 }
 
@@ -294,11 +919,7 @@ func (s *SelectStatement) bucketAggregations() Aggs {
 	for _, dim := range s.Dimensions {
 		agg := &Agg{}
 		agg.params = make(map[string]interface{})
-		if dim.Alias == "" {
-			agg.name = cleanDocString(dim.String())
-		} else {
-			agg.name = cleanDocString(dim.Alias)
-		}
+		agg.name = dim.aggName()
 
 		switch expr := dim.Expr.(type) {
 		case *Call:
@@ -310,7 +931,7 @@ func (s *SelectStatement) bucketAggregations() Aggs {
 				case *BinaryExpr:
 					agg.params["script"] = arg0.String()
 				default:
-					agg.params["field"] = cleanDocString(arg0.String())
+					agg.params["field"] = fieldName(arg0)
 				}
 				agg.params["keyed"] = true
 				ranges := make([]map[string]string, 0, len(expr.Args))
@@ -328,28 +949,82 @@ func (s *SelectStatement) bucketAggregations() Aggs {
 				ranges = append(ranges, map[string]string{"from": args[len(args)-1].String()})
 				agg.params["ranges"] = ranges
 
+			case "date_range":
+				agg.typ = DateRange
+				agg.params["field"] = strings.Trim(fieldName(expr.Args[0]), "'")
+				agg.params["keyed"] = true
+				// each pair of consecutive bounds is a bucket keyed by from-to
+				args := expr.Args[1:]
+				ranges := make([]map[string]string, 0, len(args)-1)
+				for i := 1; i < len(args); i++ {
+					ranges = append(ranges, map[string]string{
+						"from": args[i-1].(*StringLiteral).Val,
+						"to":   args[i].(*StringLiteral).Val,
+					})
+				}
+				agg.params["ranges"] = ranges
 			case "histogram":
 
 				agg.typ = Histogram
-				agg.params["field"] = cleanDocString(expr.Args[0].String())
-				agg.params["interval"] = expr.Args[1].String()
+				agg.params["field"] = fieldName(expr.Args[0])
+				agg.params["interval"] = literalValue(expr.Args[1])
 				agg.params["min_doc_count"] = 0
+				if len(expr.Args) > 2 {
+					agg.params["min_doc_count"] = literalValue(expr.Args[2])
+				}
 				// agg.params["min"] = expr.Args[2].String()
 				// agg.params["max"] = expr.Args[3].String()
 			case "date_histogram":
 
 				agg.typ = DateHistogram
-				agg.params["field"] = strings.Trim(expr.Args[0].String(), "'")
-				//support `year`, `quarter`, `month`, `week`, `day`, `hour`, `minute`, `second`
-				agg.params["interval"] = strings.Trim(expr.Args[1].String(), "'")
+				agg.params["field"] = strings.Trim(fieldName(expr.Args[0]), "'")
+				interval := expr.Args[1].(*StringLiteral).Val
+				key, _ := dateHistogramInterval(interval)
+				agg.params[key] = interval
+			case "geohash":
+				// cells of the geo_point field, metrics nest under each cell
+				agg.typ = GeoHashGrid
+				agg.params["field"] = fieldName(expr.Args[0])
+				agg.params["precision"] = literalValue(expr.Args[1])
+			case "terms":
+				// explicit size and order, LIMIT and ORDER BY don't apply
+				agg.typ = Terms
+				agg.params["field"] = fieldName(expr.Args[0])
+				agg.params["size"] = literalValue(expr.Args[1])
+				// documents without the field are bucketed under the label
+				if label := termsMissing(expr); label != nil {
+					agg.params["missing"] = label.Val
+				}
+				for _, arg := range expr.Args[2:] {
+					if _, ok := arg.(*OrderByExpr); !ok {
+						continue
+					}
+					order := make([]map[string]string, 0)
+					for _, sf := range arg.(*OrderByExpr).SortFields {
+						key := sf.Name
+						if key == "count(*)" {
+							key = "_count"
+						} else {
+							key = s.bucketsPath(key)
+						}
+						if sf.Ascending {
+							order = append(order, map[string]string{key: "asc"})
+						} else {
+							order = append(order, map[string]string{key: "desc"})
+						}
+					}
+					agg.params["order"] = order
+				}
 			default:
 				// terms inline expression
 				agg.typ = Terms
 				//order
-				if len(s.SortFields) > 0 {
-					agg.params["order"] = s.orders()
+				if order := s.orders(agg.name); len(order) > 0 {
+					agg.params["order"] = order
+				}
+				if s.Limit > 0 {
+					agg.params["size"] = s.Limit
 				}
-				agg.params["size"] = s.Limit
 				m := make(map[string]string, 0)
 				m["lang"] = "expression"
 				m["inline"] = expr.String()
@@ -359,16 +1034,22 @@ func (s *SelectStatement) bucketAggregations() Aggs {
 		default:
 			agg.typ = Terms
 			switch term := expr.(type) {
+			case *CaseExpr:
+				// checked by validateDimensions
+				inline, _ := painlessScript(term)
+				agg.params["script"] = map[string]string{"lang": "painless", "inline": inline}
 			case *BinaryExpr:
 				agg.params["script"] = term.String()
 			default:
-				agg.params["field"] = cleanDocString(term.String())
+				agg.params["field"] = fieldName(term)
 			}
 			//order
-			if len(s.SortFields) > 0 {
-				agg.params["order"] = s.orders()
+			if order := s.orders(agg.name); len(order) > 0 {
+				agg.params["order"] = order
+			}
+			if s.Limit > 0 {
+				agg.params["size"] = s.Limit
 			}
-			agg.params["size"] = s.Limit
 		}
 		aggs = append(aggs, agg)
 	}
@@ -376,6 +1057,79 @@ func (s *SelectStatement) bucketAggregations() Aggs {
 	return aggs
 }
 
+// compositeSourceParams are the parameters of the bucket aggregations kept by
+// the sources of a composite aggregation.
+var compositeSourceParams = map[ESAgg][]string{
+	Terms:         {"field", "script"},
+	Histogram:     {"field", "interval"},
+	DateHistogram: {"field", "interval", "calendar_interval", "fixed_interval"},
+}
+
+// compositeAggregation returns the composite aggregation of GROUP BY
+// COMPOSITE, with a source for each of the bucket aggregations baggs of the
+// dimensions. LIMIT is the page size and AFTER the key the page starts after.
+func (s *SelectStatement) compositeAggregation(baggs Aggs) *Agg {
+	sources := make([]map[string]interface{}, 0, len(baggs))
+	after := make(map[string]interface{}, len(s.After))
+	for i, a := range baggs {
+		params := make(map[string]interface{})
+		for _, key := range compositeSourceParams[a.typ] {
+			if v, ok := a.params[key]; ok {
+				params[key] = v
+			}
+		}
+		for _, sf := range s.SortFields {
+			if sf.Name == a.name && sf.Ascending {
+				params["order"] = "asc"
+			} else if sf.Name == a.name {
+				params["order"] = "desc"
+			}
+		}
+		sources = append(sources, map[string]interface{}{
+			a.name: map[string]interface{}{aggs[a.typ]: params},
+		})
+		if i < len(s.After) {
+			after[a.name] = literalValue(s.After[i])
+		}
+	}
+
+	agg := &Agg{name: "composite", typ: Composite}
+	agg.params = map[string]interface{}{"sources": sources}
+	if s.Limit > 0 {
+		agg.params["size"] = s.Limit
+	}
+	if len(after) > 0 {
+		agg.params["after"] = after
+	}
+	return agg
+}
+
+var intervalRegexp = regexp.MustCompile(`^(\d+)([smhdwMy])$`)
+
+// dateHistogramInterval returns the date_histogram parameter that accepts interval,
+// calendar_interval for calendar units and fixed_interval for fixed durations.
+// Besides the `1y` unit form, `year`, `quarter`, `month`, `week`, `day`,
+// `hour`, `minute` and `second` are accepted as calendar intervals.
+func dateHistogramInterval(interval string) (string, error) {
+	switch interval {
+	case "year", "quarter", "month", "week", "day", "hour", "minute", "second":
+		return "calendar_interval", nil
+	}
+
+	m := intervalRegexp.FindStringSubmatch(interval)
+	if m == nil {
+		return "", fmt.Errorf("invalid date_histogram interval %s, expected units s, m, h, d, w, M, y", interval)
+	}
+	switch m[2] {
+	case "w", "M", "y":
+		if m[1] != "1" {
+			return "", fmt.Errorf("invalid date_histogram interval %s, calendar units only support 1%s", interval, m[2])
+		}
+		return "calendar_interval", nil
+	}
+	return "fixed_interval", nil
+}
+
 // bucketFunctionCalls walks the Field of function calls expr
 func bucketFunctionCalls(exp Expr) []*Call {
 	switch expr := exp.(type) {
@@ -404,6 +1158,10 @@ func (s *SelectStatement) bucketScriptAggs() Aggs {
 		}
 
 		calls := bucketFunctionCalls(f.Expr)
+		if len(calls) == 0 {
+			// arithmetic between fields only is a script field
+			continue
+		}
 		bucketsPath := make(map[string]string)
 		inlineExpr := cleanDocString(f.Expr.String())
 
@@ -412,12 +1170,12 @@ func (s *SelectStatement) bucketScriptAggs() Aggs {
 
 			agg.typ = fn.metricAggType()
 			agg.params = fn.metricAggParams()
-			agg.name = fmt.Sprintf(`%s(%s)`, fn.Name, cleanDocString(fn.Args[0].String()))
+			agg.name = (&Field{Expr: fn}).metricAggName()
 
 			path := fmt.Sprintf("path%d", i)
 			bucketsPath[path] = agg.name
 			//todo: ugly, should use walk tree method
-			inlineExpr = strings.Replace(inlineExpr, agg.name, path, -1)
+			inlineExpr = strings.Replace(inlineExpr, cleanDocString(fn.String()), path, -1)
 
 			aggs = append(aggs, agg)
 		}
@@ -442,31 +1200,113 @@ func (s *SelectStatement) bucketScriptAggs() Aggs {
 	return aggs
 }
 
+// defaultMetricScripts are the combine and reduce scripts of a scripted
+// metric when not given, returning the states of the shards as is.
+var defaultMetricScripts = []string{"return state", "return states"}
+
 func (c *Call) metricAggParams() map[string]interface{} {
 	params := make(map[string]interface{})
+	if c.Name == "top" {
+		// top(size[, ORDER BY sort]) are the top hits of the bucket
+		params["size"] = literalValue(c.Args[0])
+		if len(c.Args) > 1 {
+			params["sort"] = sortClauses(c.Args[1].(*OrderByExpr).SortFields)
+		}
+		return params
+	}
+	if c.Name == "script" {
+		// script([init, ]map[, combine[, reduce]]) are the painless scripts
+		// of the scripted metric, es requires combine and reduce.
+		scripts := make([]string, 0, len(c.Args))
+		for _, arg := range c.Args {
+			scripts = append(scripts, arg.(*StringLiteral).Val)
+		}
+		if len(scripts) == 1 {
+			scripts = append([]string{""}, scripts...)
+		}
+		scripts = append(scripts, defaultMetricScripts[len(scripts)-2:]...)
+		if scripts[0] != "" {
+			params["init_script"] = scripts[0]
+		}
+		params["map_script"] = scripts[1]
+		params["combine_script"] = scripts[2]
+		params["reduce_script"] = scripts[3]
+		return params
+	}
 	switch arg := c.Args[0].(type) {
 	case *VarRef:
-		params["field"] = arg.String()
+		params["field"] = fieldName(arg)
 	case *BinaryExpr:
 		c.RewriteMetricArgs()
 		params["script"] = c.Args[0].String()
 	case *Wildcard:
 		params["field"] = ""
+	case *Call:
+		if arg.Name != "distinct" {
+			panic(fmt.Errorf("not support metric argument"))
+		}
+		params["field"] = fieldName(arg.Args[0])
 	default:
 		panic(fmt.Errorf("not support metric argument"))
 	}
+
+	switch c.Name {
+	case "percentile", "percentiles":
+		if len(c.Args) < 2 {
+			break
+		}
+		percents := make([]interface{}, 0, len(c.Args)-1)
+		for _, arg := range c.Args[1:] {
+			percents = append(percents, literalValue(arg))
+		}
+		params["percents"] = percents
+	case "percentile_rank":
+		values := make([]interface{}, 0, len(c.Args)-1)
+		for _, arg := range c.Args[1:] {
+			values = append(values, literalValue(arg))
+		}
+		params["values"] = values
+	}
+	if c.metricAggType() == Cardinality && len(c.Args) > 1 {
+		params["precision_threshold"] = literalValue(c.Args[1])
+	}
 	return params
 }
 
 func (c *Call) metricAggType() ESAgg {
-	// sql use count(), es func is value_count()
+	// sql use count(), es func is value_count(): count(field) is shorthand
+	// for value_count(field) while count(*) is the doc count of the bucket,
+	// or the hits total without buckets.
 	if c.Name == "count" {
-		if _, ok := c.Args[0].(*Wildcard); ok {
+		switch arg := c.Args[0].(type) {
+		case *Wildcard:
 			return StarCount
+		case *Call:
+			// count(DISTINCT field) is approximated by es cardinality
+			if arg.Name == "distinct" {
+				return Cardinality
+			}
 		}
 		return ValueCount
 	}
 
+	switch c.Name {
+	case "percentile":
+		return Percentiles
+	case "percentile_rank":
+		return PercentileRanks
+	case "top":
+		return Top
+	case "script":
+		return ScriptedMetric
+	case "moving_avg":
+		return MovingFn
+	case "derivative":
+		return Derivative
+	case "cumulative_sum":
+		return CumulativeSum
+	}
+
 	for i := metricBegin; i < metricEnd; i++ {
 		if aggs[i] == c.Name {
 			return ESAgg(i)
@@ -480,24 +1320,105 @@ func (f *Field) metricAggName() string {
 		return f.Alias
 	}
 	fn, _ := f.Expr.(*Call)
-	return fmt.Sprintf(`%s(%s)`, fn.Name, fn.Args[0].String())
+	switch fn.Name {
+	case "percentile", "percentile_rank":
+		return fmt.Sprintf(`%s_%s`, fn.Name, fieldName(fn.Args[0]))
+	case "avg", "sum", "min", "max", "value_count":
+		// single value metrics of a field are named like max_price
+		if ref, ok := fn.Args[0].(*VarRef); ok {
+			return fmt.Sprintf(`%s_%s`, fn.Name, cleanDocString(ref.Val))
+		}
+	case "count":
+		// count(field) is the same aggregation as value_count(field)
+		if ref, ok := fn.Args[0].(*VarRef); ok && fn.metricAggType() == ValueCount {
+			return fmt.Sprintf(`value_count_%s`, cleanDocString(ref.Val))
+		}
+	}
+	return fmt.Sprintf(`%s(%s)`, fn.Name, fieldName(fn.Args[0]))
+}
+
+// nestedAggregation returns the nested aggregation of a NESTED(path, metric)
+// field, wrapping the metric aggregation named by the field alias.
+func (f *Field) nestedAggregation() *Agg {
+	fn := f.Expr.(*Call)
+	agg := &Agg{}
+	agg.name = fieldName(fn.Args[0])
+	agg.typ = Nested
+	agg.params = map[string]interface{}{"path": agg.name}
+
+	metric := &Field{Expr: fn.Args[1], Alias: f.Alias}
+	inner := fn.Args[1].(*Call)
+	// count(*) is the nested doc_count.
+	if inner.metricAggType() != StarCount {
+		agg.aggs = Aggs{{name: metric.metricAggName(), typ: inner.metricAggType(), params: inner.metricAggParams()}}
+	}
+	return agg
+}
+
+// pipelineAggs returns the metric of a pipeline function and the pipeline
+// aggregation over it, both under the innermost histogram bucket. A metric
+// referenced by its alias is computed by its select field.
+func (s *SelectStatement) pipelineAggs(f *Field) Aggs {
+	fn := f.Expr.(*Call)
+	var aggs Aggs
+	var path string
+	switch inner := fn.Args[0].(type) {
+	case *VarRef:
+		path = s.bucketsPath(inner.Val)
+	case *Call:
+		metric := &Agg{
+			name:   (&Field{Expr: inner}).metricAggName(),
+			typ:    inner.metricAggType(),
+			params: inner.metricAggParams(),
+		}
+		path = metric.name
+		if metric.typ == StarCount {
+			path = "_count"
+		}
+		aggs = append(aggs, metric)
+	}
+
+	agg := &Agg{name: f.metricAggName(), typ: fn.metricAggType()}
+	agg.params = map[string]interface{}{"buckets_path": path}
+	switch fn.Name {
+	case "moving_avg":
+		agg.params["window"] = literalValue(fn.Args[1])
+		agg.params["script"] = "MovingFunctions.unweightedAvg(values)"
+	}
+	return append(aggs, agg)
 }
 
-func (s *SelectStatement) metricAggs() Aggs {
+func (s *SelectStatement) metricAggs() (Aggs, error) {
 	var aggs Aggs
 	for _, field := range s.Fields {
 		fn, ok := field.Expr.(*Call)
 		if !ok {
 			continue
 		}
+		if fn.Name == "nested" {
+			aggs = append(aggs, field.nestedAggregation())
+			continue
+		}
+		if isPipelineFunction(fn.Name) {
+			aggs = append(aggs, s.pipelineAggs(field)...)
+			continue
+		}
 		agg := &Agg{}
 		agg.name = field.metricAggName()
 		agg.typ = fn.metricAggType()
 		agg.params = fn.metricAggParams()
+		if field.Filter != nil {
+			var err error
+			if agg, err = field.filterAggregation(agg); err != nil {
+				return nil, err
+			}
+		}
 
 		aggs = append(aggs, agg)
 	}
 
+	//append metric aggregations only referenced by having
+	aggs = append(aggs, s.havingMetricAggs()...)
 	//append bucket script aggregation
 	aggs = append(aggs, s.bucketScriptAggs()...)
 	//append bucket selector aggregation
@@ -506,5 +1427,22 @@ func (s *SelectStatement) metricAggs() Aggs {
 		aggs = append(aggs, pipeAgg)
 	}
 
-	return aggs
+	return aggs, nil
+}
+
+// filterAggregation wraps the metric aggregation of f in a filter aggregation
+// of its FILTER (WHERE ...) condition, count(*) is the filter doc_count.
+func (f *Field) filterAggregation(metric *Agg) (*Agg, error) {
+	q, err := conditionQuery(f.Filter)
+	if err != nil {
+		return nil, err
+	}
+	agg := &Agg{}
+	agg.name = "filtered_" + metric.name
+	agg.typ = Filter
+	agg.params = q
+	if metric.typ != StarCount {
+		agg.aggs = Aggs{metric}
+	}
+	return agg, nil
 }
@@ -1,6 +1,7 @@
 package sp_test
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 
@@ -25,6 +26,24 @@ func TestTranslator_EsDsl(t *testing.T) {
                     "sort": []
                   }`,
 		},
+		// offset pagination
+		{
+			sql: `select * from logs limit 20 offset 40`,
+			dsl: `{
+                    "from": 40,
+                    "size": 20,
+                    "sort": []
+                  }`,
+		},
+		// mysql style offset pagination
+		{
+			sql: `select * from logs limit 40, 20`,
+			dsl: `{
+                    "from": 40,
+                    "size": 20,
+                    "sort": []
+                  }`,
+		},
 		// desc sort
 		{
 			sql: `select * from symbol order by name desc limit 1`,
@@ -51,6 +70,54 @@ func TestTranslator_EsDsl(t *testing.T) {
                     ]
                   }`,
 		},
+		//multi-column sort
+		{
+			sql: `select * from logs order by ts desc, host limit 5`,
+			dsl: `{
+                    "from": 0,
+                    "size": 5,
+                    "sort": [
+                      {
+                        "ts": "desc"
+                      },
+                      {
+                        "host": "asc"
+                      }
+                    ]
+                  }`,
+		},
+		//sort with missing values placement
+		{
+			sql: `select * from logs order by referer desc nulls first, host nulls last limit 5`,
+			dsl: `{
+                    "from": 0,
+                    "size": 5,
+                    "sort": [
+                      {
+                        "referer": {
+                          "missing": "_first",
+                          "order": "desc"
+                        }
+                      },
+                      {
+                        "host": {
+                          "missing": "_last",
+                          "order": "asc"
+                        }
+                      }
+                    ]
+                  }`,
+		},
+		//sort by the field of an alias, other names are fields of the index
+		{
+			sql: `select referer as r from logs order by r desc, ts limit 5`,
+			dsl: `{
+                    "_source": ["referer"],
+                    "from": 0,
+                    "size": 5,
+                    "sort": [{"referer": "desc"}, {"ts": "asc"}]
+                  }`,
+		},
 		//where EQ condition
 		{
 			sql: `select * from symbol where exchange='nyse' limit 1`,
@@ -77,8 +144,8 @@ func TestTranslator_EsDsl(t *testing.T) {
                     "query": {
                       "bool": {
                         "filter": {
-                          "script": {
-                            "script": "doc['last_sale'].value > 985"
+                          "range": {
+                            "last_sale": {"gt": 985}
                           }
                         }
                       }
@@ -113,8 +180,11 @@ func TestTranslator_EsDsl(t *testing.T) {
                       "query": {
                         "bool": {
                           "filter": {
-                            "script": {
-                              "script": "doc['exchange'].value == 'nyse' && doc['sector'].value == 'Technology'"
+                            "bool": {
+                              "must": [
+                                {"script": {"script": "doc['exchange'].value == 'nyse'"}},
+                                {"script": {"script": "doc['sector'].value == 'Technology'"}}
+                              ]
                             }
                           }
                         }
@@ -131,8 +201,12 @@ func TestTranslator_EsDsl(t *testing.T) {
                     "query": {
                       "bool": {
                         "filter": {
-                          "script": {
-                            "script": "doc['exchange'].value == 'nyse' || doc['sector'].value != 'Technology'"
+                          "bool": {
+                            "minimum_should_match": 1,
+                            "should": [
+                              {"script": {"script": "doc['exchange'].value == 'nyse'"}},
+                              {"script": {"script": "doc['sector'].value != 'Technology'"}}
+                            ]
                           }
                         }
                       }
@@ -141,166 +215,1763 @@ func TestTranslator_EsDsl(t *testing.T) {
                     "sort": []
                   }`,
 		},
-		//condition field has @
+		//where three-way OR condition
 		{
-			sql: `select * from quote where @timestamp > 1482908284586 limit 1`,
+			sql: `select * from logs where status = 500 OR status = 502 OR status = 503 limit 1`,
 			dsl: `{
-                  "from": 0,
-                  "query": {
-                    "bool": {
-                      "filter": {
-                        "script": {
-                          "script": "doc['@timestamp'].value > 1482908284586"
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "minimum_should_match": 1,
+                            "should": [
+                              {"script": {"script": "doc['status'].value == 500"}},
+                              {"script": {"script": "doc['status'].value == 502"}},
+                              {"script": {"script": "doc['status'].value == 503"}}
+                            ]
+                          }
                         }
                       }
-                    }
-                  },
-                  "size": 1,
-                  "sort": []
-                }`,
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
 		},
-		//count * metric
+		//where NOT condition
 		{
-			sql: `select count(*) from quote`,
+			sql: `select * from logs where NOT (status = 200) limit 1`,
 			dsl: `{
-                    "aggs": {},
                     "from": 0,
-                    "size": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must_not": [
+                              {"script": {"script": "doc['status'].value == 200"}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
                     "sort": []
                   }`,
 		},
-		//count field metric
+		//where NOT binds to the comparison under AND
 		{
-			sql: `select count(ipo_year) from symbol`,
+			sql: `select * from logs where NOT status = 200 AND host = 'a' limit 1`,
 			dsl: `{
-                    "aggs": {
-                      "count(ipo_year)": {
-                        "value_count": {
-                          "field": "ipo_year"
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must": [
+                              {
+                                "bool": {
+                                  "must_not": [
+                                    {"script": {"script": "doc['status'].value == 200"}}
+                                  ]
+                                }
+                              },
+                              {"script": {"script": "doc['host'].value == 'a'"}}
+                            ]
+                          }
                         }
                       }
                     },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where OR of an AND group and a NOT condition
+		{
+			sql: `select * from logs where (a = 1 AND b = 2) OR NOT c = 3 limit 1`,
+			dsl: `{
                     "from": 0,
-                    "size": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "minimum_should_match": 1,
+                            "should": [
+                              {
+                                "bool": {
+                                  "must": [
+                                    {"script": {"script": "doc['a'].value == 1"}},
+                                    {"script": {"script": "doc['b'].value == 2"}}
+                                  ]
+                                }
+                              },
+                              {
+                                "bool": {
+                                  "must_not": [
+                                    {"script": {"script": "doc['c'].value == 3"}}
+                                  ]
+                                }
+                              }
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
                     "sort": []
                   }`,
 		},
-		//count field metric use Alias
+		//where groups nest bool queries at every level
 		{
-			sql: `select count(ipo_year) AS xx from symbol`,
+			sql: `select * from logs where a > 1 AND (b > 2 OR NOT (c > 3 AND d > 4)) limit 1`,
 			dsl: `{
-                    "aggs": {
-                      "xx": {
-                        "value_count": {
-                          "field": "ipo_year"
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must": [
+                              {"range": {"a": {"gt": 1}}},
+                              {
+                                "bool": {
+                                  "minimum_should_match": 1,
+                                  "should": [
+                                    {"range": {"b": {"gt": 2}}},
+                                    {
+                                      "bool": {
+                                        "must_not": [
+                                          {
+                                            "bool": {
+                                              "must": [
+                                                {"range": {"c": {"gt": 3}}},
+                                                {"range": {"d": {"gt": 4}}}
+                                              ]
+                                            }
+                                          }
+                                        ]
+                                      }
+                                    }
+                                  ]
+                                }
+                              }
+                            ]
+                          }
                         }
                       }
                     },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//post filter on the hits, the aggregations count all the matches
+		{
+			sql: `select max(price), min(price) from products where match(title, 'shoe') post_filter(where color = 'red' and size in (40, 41)) limit 10`,
+			dsl: `{
+                    "aggs": {
+                      "max_price": {"max": {"field": "price"}},
+                      "min_price": {"min": {"field": "price"}}
+                    },
                     "from": 0,
-                    "size": 0,
+                    "post_filter": {
+                      "bool": {
+                        "must": [
+                          {"script": {"script": "doc['color'].value == 'red'"}},
+                          {"terms": {"size": [40, 41]}}
+                        ]
+                      }
+                    },
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"match": {"title": {"query": "shoe"}}}
+                        ]
+                      }
+                    },
+                    "size": 10,
                     "sort": []
                   }`,
 		},
-		//cardinality field metric
+		//group by a composite aggregation sorted by its sources
 		{
-			sql: `select cardinality(ipo_year) from symbol`,
+			sql: `select host, day, avg(latency) from logs group by composite host, date_histogram(ts, '1d') as day order by host desc limit 100`,
 			dsl: `{
                     "aggs": {
-                      "cardinality(ipo_year)": {
-                        "cardinality": {
-                          "field": "ipo_year"
+                      "composite": {
+                        "aggs": {
+                          "avg_latency": {"avg": {"field": "latency"}}
+                        },
+                        "composite": {
+                          "size": 100,
+                          "sources": [
+                            {"host": {"terms": {"field": "host", "order": "desc"}}},
+                            {"day": {"date_histogram": {"field": "ts", "fixed_interval": "1d"}}}
+                          ]
                         }
                       }
                     },
-                    "from": 0,
-                    "size": 0,
-                    "sort": []
+                    "query": {
+                      "bool": {
+                        "filter": {"bool": {"must": [{"exists": {"field": "host"}}, {"exists": {"field": "ts"}}]}}
+                      }
+                    },
+                    "size": 0
                   }`,
 		},
-		//sum field metric
+		//composite page after the key of the previous page
 		{
-			sql: `select sum(market_cap) from symbol`,
+			sql: `select host, status, sum(bytes) as total from logs group by composite host, status after('web-1', 500) having total > 10 limit 50`,
 			dsl: `{
                     "aggs": {
-                      "sum(market_cap)": {
-                        "sum": {
-                          "field": "market_cap"
+                      "composite": {
+                        "aggs": {
+                          "having": {
+                            "bucket_selector": {
+                              "buckets_path": {"total": "total"},
+                              "script": {"inline": "total > 10", "lang": "expression"}
+                            }
+                          },
+                          "total": {"sum": {"field": "bytes"}}
+                        },
+                        "composite": {
+                          "after": {"host": "web-1", "status": 500},
+                          "size": 50,
+                          "sources": [
+                            {"host": {"terms": {"field": "host"}}},
+                            {"status": {"terms": {"field": "status"}}}
+                          ]
                         }
                       }
                     },
+                    "query": {
+                      "bool": {
+                        "filter": {"bool": {"must": [{"exists": {"field": "host"}}, {"exists": {"field": "status"}}]}}
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//collapse hits by a field
+		{
+			sql: `select * from logs collapse(session_id) limit 10`,
+			dsl: `{
+                    "collapse": {"field": "session_id"},
                     "from": 0,
-                    "size": 0,
+                    "size": 10,
                     "sort": []
                   }`,
 		},
-		//metric field and filter
+		//collapse with inner hits
 		{
-			sql: `select sum(market_cap) from symbol where ipo_year=1998`,
+			sql: `select host, msg from logs where status >= 500 collapse(user.id, 3) order by ts desc limit 10`,
 			dsl: `{
-                    "aggs": {
-                      "sum(market_cap)": {"sum": {"field": "market_cap"}}
+                    "_source": ["host", "msg"],
+                    "collapse": {
+                      "field": "user.id",
+                      "inner_hits": {"name": "user.id", "size": 3}
                     },
                     "from": 0,
                     "query": {
                       "bool": {
-                        "filter": {
-                          "script": {"script": "doc['ipo_year'].value == 1998"}
-                        }
+                        "filter": {"range": {"status": {"gte": 500}}}
                       }
                     },
-                    "size": 0,
+                    "size": 10,
+                    "sort": [{"ts": "desc"}]
+                  }`,
+		},
+		//highlight the full-text matches
+		{
+			sql: `select * from logs where match(message, 'error') highlight(message, title) limit 10`,
+			dsl: `{
+                    "from": 0,
+                    "highlight": {
+                      "fields": {"message": {}, "title": {}}
+                    },
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"match": {"message": {"query": "error"}}}
+                        ]
+                      }
+                    },
+                    "size": 10,
                     "sort": []
                   }`,
 		},
-		//metric field and group by
+		//highlight with fragment size and number of fragments
 		{
-			sql: `select exchange, count(*) from symbol group by exchange`,
+			sql: `select * from logs where match(message, 'error') highlight(message, 150, 3) limit 10`,
 			dsl: `{
-                    "aggs": {
-                      "exchange": {
-                        "aggs": {},
-                        "terms": {
-                          "field": "exchange",
-                          "size": 0
-                        }
+                    "from": 0,
+                    "highlight": {
+                      "fields": {"message": {}},
+                      "fragment_size": 150,
+                      "number_of_fragments": 3
+                    },
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"match": {"message": {"query": "error"}}}
+                        ]
+                      }
+                    },
+                    "size": 10,
+                    "sort": []
+                  }`,
+		},
+		//random order
+		{
+			sql: `select * from logs order by random() limit 5`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "function_score": {
+                        "boost_mode": "replace",
+                        "query": {"match_all": {}},
+                        "random_score": {}
                       }
                     },
+                    "size": 5,
+                    "sort": [{"_score": "asc"}]
+                  }`,
+		},
+		//random order without hits isn't scored
+		{
+			sql: `select * from logs where status >= 500 order by random()`,
+			dsl: `{
+                    "from": 0,
                     "query": {
                       "bool": {
-                        "filter": {
-                          "and": [{"exists": {"field": "exchange"}}]
-                        }
+                        "filter": {"range": {"status": {"gte": 500}}}
                       }
                     },
+                    "size": 0,
+                    "sort": [{"_score": "asc"}]
+                  }`,
+		},
+		//random order of an aggregation isn't scored
+		{
+			sql: `select avg(bytes) from logs order by random() desc`,
+			dsl: `{
+                    "aggs": {"avg_bytes": {"avg": {"field": "bytes"}}},
                     "size": 0
                   }`,
 		},
-		//metric field and group by
+		//random order with a seed, wrapping the WHERE query
 		{
-			sql: `select exchange, count(*) from symbol group by exchange`,
+			sql: `select * from logs where status >= 500 order by random(42) desc limit 5`,
 			dsl: `{
-                    "aggs": {
-                      "exchange": {
-                        "aggs": {},
-                        "terms": {
-                          "field": "exchange",
-                          "size": 0
-                        }
+                    "from": 0,
+                    "query": {
+                      "function_score": {
+                        "boost_mode": "replace",
+                        "query": {
+                          "bool": {
+                            "filter": {"range": {"status": {"gte": 500}}}
+                          }
+                        },
+                        "random_score": {"field": "_seq_no", "seed": 42}
+                      }
+                    },
+                    "size": 5,
+                    "sort": [{"_score": "desc"}]
+                  }`,
+		},
+		//where MATCH full text query
+		{
+			sql: `select * from logs where match(message, 'error timeout') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"match": {"message": {"query": "error timeout"}}}
+                        ]
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where MATCH with operator and other predicates
+		{
+			sql: `select * from logs where MATCH(message, 'error timeout', 'AND') and status = 500 limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"match": {"message": {"operator": "and", "query": "error timeout"}}}
+                        ],
+                        "filter": {"script": {"script": "doc['status'].value == 500"}}
                       }
                     },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where MATCH scored while the exact predicates are filtered
+		{
+			sql: `select * from logs where match(msg, 'x') and host = 'a' and ts > 5 limit 1`,
+			dsl: `{
+                    "from": 0,
                     "query": {
                       "bool": {
+                        "must": [
+                          {"match": {"msg": {"query": "x"}}}
+                        ],
                         "filter": {
-                          "and": [{"exists": {"field": "exchange"}}]
+                          "bool": {
+                            "must": [
+                              {"script": {"script": "doc['host'].value == 'a'"}},
+                              {"range": {"ts": {"gt": 5}}}
+                            ]
+                          }
                         }
                       }
                     },
-                    "size": 0
+                    "size": 1,
+                    "sort": []
                   }`,
 		},
-		//group by groovy function
+		//where OR holding a MATCH is scored
 		{
-			sql: `SELECT shares_count, COUNT(*) FROM symbol GROUP BY floor(market_cap / last_sale / 1000000) AS shares_count ORDER BY shares_count LIMIT 3`,
+			sql: `select * from logs where match(msg, 'x') or host = 'a' limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {
+                            "bool": {
+                              "minimum_should_match": 1,
+                              "should": [
+                                {"match": {"msg": {"query": "x"}}},
+                                {"script": {"script": "doc['host'].value == 'a'"}}
+                              ]
+                            }
+                          }
+                        ]
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where MATCH_PHRASE query
+		{
+			sql: `select * from logs where match_phrase(message, 'connection refused') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"match_phrase": {"message": {"query": "connection refused"}}}
+                        ]
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where MATCH_PHRASE query with slop
+		{
+			sql: `select * from logs where MATCH_PHRASE(message, 'connection refused', 2) limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"match_phrase": {"message": {"query": "connection refused", "slop": 2}}}
+                        ]
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where GEO_DISTANCE query
+		{
+			sql: `select * from shops where geo_distance(location, 40.7, -74, '10km') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "geo_distance": {
+                            "distance": "10km",
+                            "location": {"lat": 40.7, "lon": -74}
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where GEO_BOUNDING_BOX query
+		{
+			sql: `select * from shops where geo_bounding_box(location, 40.73, -74.1, 40.01, -71.12) limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "geo_bounding_box": {
+                            "location": {
+                              "top_left": {"lat": 40.73, "lon": -74.1},
+                              "bottom_right": {"lat": 40.01, "lon": -71.12}
+                            }
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where MULTI_MATCH query
+		{
+			sql: `select * from posts where multi_match('timeout', title, body) limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"multi_match": {"fields": ["title", "body"], "query": "timeout"}}
+                        ]
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where MULTI_MATCH query with type
+		{
+			sql: `select * from posts where MULTI_MATCH('timeout', title, body, 'most_fields') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"multi_match": {"fields": ["title", "body"], "query": "timeout", "type": "most_fields"}}
+                        ]
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where PREFIX query, optionally case insensitive
+		{
+			sql: `select * from logs where prefix(path, '/api') or prefix(path, '/V2', true) limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "minimum_should_match": 1,
+                            "should": [
+                              {"prefix": {"path": {"value": "/api"}}},
+                              {"prefix": {"path": {"value": "/V2", "case_insensitive": true}}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where FUZZY query, AUTO fuzziness by default
+		{
+			sql: `select * from users where fuzzy(name, 'jon', 2) and fuzzy(city, 'pariss') and fuzzy(tag, 'x', 'auto') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must": [
+                              {"fuzzy": {"name": {"value": "jon", "fuzziness": 2}}},
+                              {"fuzzy": {"city": {"value": "pariss", "fuzziness": "AUTO"}}},
+                              {"fuzzy": {"tag": {"value": "x", "fuzziness": "AUTO"}}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where TERMS_LOOKUP query, fetching the terms from a document
+		{
+			sql: `select * from tweets where terms_lookup(user_id, 'users', '123', 'followers') or terms_lookup(user_id, 'users', 7, 'friends') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "minimum_should_match": 1,
+                            "should": [
+                              {"terms": {"user_id": {"index": "users", "id": "123", "path": "followers"}}},
+                              {"terms": {"user_id": {"index": "users", "id": "7", "path": "friends"}}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where EXISTS and NOT EXISTS
+		{
+			sql: `select * from logs where exists(user.name) and not exists(referer) limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must": [
+                              {"exists": {"field": "user.name"}},
+                              {"bool": {"must_not": [{"exists": {"field": "referer"}}]}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where QUERY_STRING lucene query
+		{
+			sql: `select * from logs where QUERY_STRING('status:200 AND path:/api/*') and host = 'a' limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "must": [
+                          {"query_string": {"query": "status:200 AND path:/api/*"}}
+                        ],
+                        "filter": {"script": {"script": "doc['host'].value == 'a'"}}
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where parenthesized OR nested under AND
+		{
+			sql: `select * from symbol where (exchange='nyse' OR exchange='nasdaq') AND sector='Technology' limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must": [
+                              {
+                                "bool": {
+                                  "minimum_should_match": 1,
+                                  "should": [
+                                    {"script": {"script": "doc['exchange'].value == 'nyse'"}},
+                                    {"script": {"script": "doc['exchange'].value == 'nasdaq'"}}
+                                  ]
+                                }
+                              },
+                              {"script": {"script": "doc['sector'].value == 'Technology'"}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where AND chain with parentheses
+		{
+			sql: `select * from symbol where exchange='nyse' AND (sector='Technology' AND last_sale > 10) limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must": [
+                              {"script": {"script": "doc['exchange'].value == 'nyse'"}},
+                              {"script": {"script": "doc['sector'].value == 'Technology'"}},
+                              {"range": {"last_sale": {"gt": 10}}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where LIKE condition
+		{
+			sql: `select * from symbol where name like 'App%' limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "wildcard": {
+                            "name": "App*"
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where NOT LIKE condition with escaped wildcards
+		{
+			sql: `select * from symbol where name not like '100\%_*' limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must_not": [
+                              {"wildcard": {"name": "100%?\\*"}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where LIKE mixed with script condition
+		{
+			sql: `select * from symbol where exchange='nyse' and name like '_pple' limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must": [
+                              {"script": {"script": "doc['exchange'].value == 'nyse'"}},
+                              {"wildcard": {"name": "?pple"}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where IN condition
+		{
+			sql: `select * from logs where status in (200, 404, 500) limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "terms": {
+                            "status": [200, 404, 500]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where NOT IN condition
+		{
+			sql: `select * from logs where host not in ('a', 'b') limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must_not": [
+                              {"terms": {"host": ["a", "b"]}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where BETWEEN condition
+		{
+			sql: `select * from symbol where last_sale between 10 and 20.5 limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "range": {
+                            "last_sale": {"gte": 10, "lte": 20.5}
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where NOT BETWEEN condition on dates
+		{
+			sql: `select * from quote where day not between '2017-01-01' and '2017-02-01' limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must_not": [
+                              {"range": {"day": {"gte": "2017-01-01", "lte": "2017-02-01"}}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where IS NULL condition
+		{
+			sql: `select * from logs where referer is null limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must_not": [
+                              {"exists": {"field": "referer"}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where IS NOT NULL condition
+		{
+			sql: `select * from logs where referer is not null limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "exists": {"field": "referer"}
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//where comparisons with NULL read as IS NULL and IS NOT NULL
+		{
+			sql: `select * from logs where referer = NULL and agent <> NULL limit 1`,
+			dsl: `{
+                    "from": 0,
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "bool": {
+                            "must": [
+                              {"bool": {"must_not": [{"exists": {"field": "referer"}}]}},
+                              {"exists": {"field": "agent"}}
+                            ]
+                          }
+                        }
+                      }
+                    },
+                    "size": 1,
+                    "sort": []
+                  }`,
+		},
+		//condition field has @
+		{
+			sql: `select * from quote where @timestamp > 1482908284586 limit 1`,
+			dsl: `{
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "range": {
+                          "@timestamp": {"gt": 1482908284586}
+                        }
+                      }
+                    }
+                  },
+                  "size": 1,
+                  "sort": []
+                }`,
+		},
+		//where relative time range
+		{
+			sql: `select * from logs where ts >= now() - 24h limit 1`,
+			dsl: `{
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "range": {
+                          "ts": {"gte": "now-24h"}
+                        }
+                      }
+                    }
+                  },
+                  "size": 1,
+                  "sort": []
+                }`,
+		},
+		//where relative time window with compound arithmetic
+		{
+			sql: `select * from logs where now() - 7d + 90s < ts and ts < now() limit 1`,
+			dsl: `{
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "bool": {
+                          "must": [
+                            {"range": {"ts": {"gt": "now-168h+90s"}}},
+                            {"range": {"ts": {"lt": "now"}}}
+                          ]
+                        }
+                      }
+                    }
+                  },
+                  "size": 1,
+                  "sort": []
+                }`,
+		},
+		//source alias prefix stripped from fields
+		{
+			sql: `select * from logs as l where l.status >= 500 order by l.ts desc limit 1`,
+			dsl: `{
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "range": {
+                          "status": {"gte": 500}
+                        }
+                      }
+                    }
+                  },
+                  "size": 1,
+                  "sort": [{"ts": "desc"}]
+                }`,
+		},
+		//where backtick-quoted field names
+		{
+			sql: "select * from logs where `user name` in ('bob') and `select` >= 3 limit 1",
+			dsl: `{
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "bool": {
+                          "must": [
+                            {"terms": {"user name": ["bob"]}},
+                            {"range": {"select": {"gte": 3}}}
+                          ]
+                        }
+                      }
+                    }
+                  },
+                  "size": 1,
+                  "sort": []
+                }`,
+		},
+		//where hex and underscore-separated integers
+		{
+			sql: `select * from events where flags in (0xFF, 0x10) and bytes >= 1_000_000 limit 1`,
+			dsl: `{
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "bool": {
+                          "must": [
+                            {"terms": {"flags": [255, 16]}},
+                            {"range": {"bytes": {"gte": 1000000}}}
+                          ]
+                        }
+                      }
+                    }
+                  },
+                  "size": 1,
+                  "sort": []
+                }`,
+		},
+		//where comparison with a negative number
+		{
+			sql: `select * from weather where temp > -5 and -1.5 >= humidity limit 1`,
+			dsl: `{
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "bool": {
+                          "must": [
+                            {"range": {"temp": {"gt": -5}}},
+                            {"range": {"humidity": {"lte": -1.5}}}
+                          ]
+                        }
+                      }
+                    }
+                  },
+                  "size": 1,
+                  "sort": []
+                }`,
+		},
+		//where subtraction of a number stays a script
+		{
+			sql: `select * from weather where temp -5 > 0 limit 1`,
+			dsl: `{
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "script": {
+                          "script": "doc['temp'].value - 5 > 0"
+                        }
+                      }
+                    }
+                  },
+                  "size": 1,
+                  "sort": []
+                }`,
+		},
+		//source filtering of selected fields
+		{
+			sql: `select host, path, host from logs where status = 500 limit 10`,
+			dsl: `{
+                  "_source": ["host", "path"],
+                  "from": 0,
+                  "query": {
+                    "bool": {
+                      "filter": {
+                        "script": {
+                          "script": "doc['status'].value == 500"
+                        }
+                      }
+                    }
+                  },
+                  "size": 10,
+                  "sort": []
+                }`,
+		},
+		//arithmetic fields are script fields
+		{
+			sql: `select host, price * quantity as total, (price - 1) / 2 from orders limit 5`,
+			dsl: `{
+                  "_source": ["host"],
+                  "from": 0,
+                  "script_fields": {
+                    "total": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "doc['price'].value * doc['quantity'].value"
+                      }
+                    },
+                    "(price - 1) / 2": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "(doc['price'].value - 1) / 2"
+                      }
+                    }
+                  },
+                  "size": 5,
+                  "sort": []
+                }`,
+		},
+		//modulo in script fields
+		{
+			sql: `select bytes % 1024 + 1 as rem from logs limit 5`,
+			dsl: `{
+                  "from": 0,
+                  "script_fields": {
+                    "rem": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "doc['bytes'].value % 1024 + 1"
+                      }
+                    }
+                  },
+                  "size": 5,
+                  "sort": []
+                }`,
+		},
+		//bitwise operators in script fields
+		{
+			sql: `select (flags & 4) | mask as f, flags ^ 1 as x from logs limit 5`,
+			dsl: `{
+                  "from": 0,
+                  "script_fields": {
+                    "f": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "(doc['flags'].value & 4) | doc['mask'].value"
+                      }
+                    },
+                    "x": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "doc['flags'].value ^ 1"
+                      }
+                    }
+                  },
+                  "size": 5,
+                  "sort": []
+                }`,
+		},
+		//string concatenation in script fields
+		{
+			sql: `select host || ':' || port as addr, 'id-' || id as label, bytes + 1 || 'B' as size from logs limit 5`,
+			dsl: `{
+                  "from": 0,
+                  "script_fields": {
+                    "addr": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "'' + doc['host'].value + ':' + doc['port'].value"
+                      }
+                    },
+                    "label": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "'id-' + doc['id'].value"
+                      }
+                    },
+                    "size": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "'' + (doc['bytes'].value + 1) + 'B'"
+                      }
+                    }
+                  },
+                  "size": 5,
+                  "sort": []
+                }`,
+		},
+		//concat function in script fields
+		{
+			sql: `select CONCAT(host, ':', port) as addr from logs`,
+			dsl: `{
+                  "from": 0,
+                  "script_fields": {
+                    "addr": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "'' + doc['host'].value + ':' + doc['port'].value"
+                      }
+                    }
+                  },
+                  "size": 0,
+                  "sort": []
+                }`,
+		},
+		//concat function next to raw fields
+		{
+			sql: `select host, concat('id-', id, '/', bytes + 1) as label from logs limit 5`,
+			dsl: `{
+                  "_source": ["host"],
+                  "from": 0,
+                  "script_fields": {
+                    "label": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "'id-' + doc['id'].value + '/' + (doc['bytes'].value + 1)"
+                      }
+                    }
+                  },
+                  "size": 5,
+                  "sort": []
+                }`,
+		},
+		//case expression script field
+		{
+			sql: `select case when status >= 500 then 'err' when status >= 400 and not (path = '/') then 'client' end as bucket from logs limit 5`,
+			dsl: `{
+                  "from": 0,
+                  "script_fields": {
+                    "bucket": {
+                      "script": {
+                        "lang": "painless",
+                        "inline": "doc['status'].value >= 500 ? 'err' : (doc['status'].value >= 400 && !(doc['path'].value == '/') ? 'client' : null)"
+                      }
+                    }
+                  },
+                  "size": 5,
+                  "sort": []
+                }`,
+		},
+		//wildcard keeps the whole source
+		{
+			sql: `select host, * from logs limit 10`,
+			dsl: `{
+                  "from": 0,
+                  "size": 10,
+                  "sort": []
+                }`,
+		},
+		//single value metrics are siblings named by alias or function and field
+		{
+			sql: `select AVG(x) AS avg_x, MAX(y), min(y), sum(z) as total from t`,
+			dsl: `{
+                    "aggs": {
+                      "avg_x": {"avg": {"field": "x"}},
+                      "max_y": {"max": {"field": "y"}},
+                      "min_y": {"min": {"field": "y"}},
+                      "total": {"sum": {"field": "z"}}
+                    },
+                    "size": 0
+                  }`,
+		},
+		//count of a field is shorthand for value_count, both are one aggregation
+		{
+			sql: `select count(referer), VALUE_COUNT(referer), value_count(host) as hosts from logs`,
+			dsl: `{
+                    "aggs": {
+                      "value_count_referer": {"value_count": {"field": "referer"}},
+                      "hosts": {"value_count": {"field": "host"}}
+                    },
+                    "size": 0
+                  }`,
+		},
+		//count of a field sorts the buckets by the value_count aggregation
+		{
+			sql: `select count(a), value_count(a) from t group by h order by count(a) desc`,
+			dsl: `{
+                    "aggs": {
+                      "h": {
+                        "aggs": {"value_count_a": {"value_count": {"field": "a"}}},
+                        "terms": {"field": "h", "order": [{"value_count_a": "desc"}]}
+                      }
+                    },
+                    "query": {"bool": {"filter": {"exists": {"field": "h"}}}},
+                    "size": 0
+                  }`,
+		},
+		//filtered metrics are wrapped in filter aggregations
+		{
+			sql: `select sum(amount) filter (where status = 'paid') as paid, count(*) filter (where status in ('void')) from orders`,
+			dsl: `{
+                    "aggs": {
+                      "filtered_paid": {
+                        "aggs": {"paid": {"sum": {"field": "amount"}}},
+                        "filter": {"script": {"script": "doc['status'].value == 'paid'"}}
+                      },
+                      "filtered_count(*)": {
+                        "filter": {"terms": {"status": ["void"]}}
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		{
+			sql: `select shop, max(amount) filter (where amount > 10) from orders group by shop order by max(amount) desc`,
+			dsl: `{
+                    "aggs": {
+                      "shop": {
+                        "aggs": {
+                          "filtered_max_amount": {
+                            "aggs": {"max_amount": {"max": {"field": "amount"}}},
+                            "filter": {"range": {"amount": {"gt": 10}}}
+                          }
+                        },
+                        "terms": {
+                          "field": "shop",
+                          "order": [{"filtered_max_amount>max_amount": "desc"}]
+                        }
+                      }
+                    },
+                    "query": {"bool": {"filter": {"exists": {"field": "shop"}}}},
+                    "size": 0
+                  }`,
+		},
+		//case expression terms script
+		{
+			sql: `select bucket, count(*) from logs group by case when status >= 500 then 'err' else 'ok' end as bucket`,
+			dsl: `{
+                    "aggs": {
+                      "bucket": {
+                        "aggs": {},
+                        "terms": {
+                          "script": {
+                            "lang": "painless",
+                            "inline": "doc['status'].value >= 500 ? 'err' : 'ok'"
+                          }
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//top hits per bucket
+		{
+			sql: `select host, top(3), top(1, order by ts desc, path) as latest from logs group by host`,
+			dsl: `{
+                    "aggs": {
+                      "host": {
+                        "aggs": {
+                          "top(3)": {"top_hits": {"size": 3}},
+                          "latest": {"top_hits": {"size": 1, "sort": [{"ts": "desc"}, {"path": "asc"}]}}
+                        },
+                        "terms": {"field": "host"}
+                      }
+                    },
+                    "query": {"bool": {"filter": {"exists": {"field": "host"}}}},
+                    "size": 0
+                  }`,
+		},
+		//count * metric, a _count request
+		{
+			sql: `select count(*) from quote`,
+			dsl: `{}`,
+		},
+		{
+			sql: `select count(*) from quote where close > 100`,
+			dsl: `{
+                    "query": {
+                      "bool": {"filter": {"range": {"close": {"gt": 100}}}}
+                    }
+                  }`,
+		},
+		//count 1 metric is the doc count of the buckets
+		{
+			sql: `select host, count(1) from logs group by host order by count(1) desc`,
+			dsl: `{
+                    "aggs": {
+                      "host": {
+                        "aggs": {},
+                        "terms": {"field": "host", "order": [{"_count": "desc"}]}
+                      }
+                    },
+                    "query": {"bool": {"filter": {"exists": {"field": "host"}}}},
+                    "size": 0
+                  }`,
+		},
+		//scripted metric with the default combine and reduce scripts
+		{
+			sql: `select script('state.n = 0', 'state.n += doc.bytes.value') as n from logs`,
+			dsl: `{
+                    "aggs": {
+                      "n": {
+                        "scripted_metric": {
+                          "init_script": "state.n = 0",
+                          "map_script": "state.n += doc.bytes.value",
+                          "combine_script": "return state",
+                          "reduce_script": "return states"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//scripted metric with all scripts
+		{
+			sql: `select script('state.n = 0', 'state.n += 1', 'return state.n', 'return states.sum()') as n from logs group by host`,
+			dsl: `{
+                    "aggs": {
+                      "host": {
+                        "aggs": {
+                          "n": {
+                            "scripted_metric": {
+                              "init_script": "state.n = 0",
+                              "map_script": "state.n += 1",
+                              "combine_script": "return state.n",
+                              "reduce_script": "return states.sum()"
+                            }
+                          }
+                        },
+                        "terms": {"field": "host"}
+                      }
+                    },
+                    "query": {"bool": {"filter": {"exists": {"field": "host"}}}},
+                    "size": 0
+                  }`,
+		},
+		//count field metric
+		{
+			sql: `select count(ipo_year) from symbol`,
+			dsl: `{
+                    "aggs": {
+                      "value_count_ipo_year": {
+                        "value_count": {
+                          "field": "ipo_year"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//count field metric use Alias
+		{
+			sql: `select count(ipo_year) AS xx from symbol`,
+			dsl: `{
+                    "aggs": {
+                      "xx": {
+                        "value_count": {
+                          "field": "ipo_year"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//cardinality field metric
+		{
+			sql: `select cardinality(ipo_year) from symbol`,
+			dsl: `{
+                    "aggs": {
+                      "cardinality(ipo_year)": {
+                        "cardinality": {
+                          "field": "ipo_year"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//select distinct single field
+		{
+			sql: `select distinct country from logs`,
+			dsl: `{
+                    "aggs": {
+                      "country": {
+                        "terms": {
+                          "field": "country",
+                          "size": 10000
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//select distinct multiple fields
+		{
+			sql: `select distinct country, city as town from logs limit 100`,
+			dsl: `{
+                    "aggs": {
+                      "distinct": {
+                        "composite": {
+                          "size": 100,
+                          "sources": [
+                            {"country": {"terms": {"field": "country"}}},
+                            {"town": {"terms": {"field": "city"}}}
+                          ]
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//count distinct
+		{
+			sql: `select count(distinct host) from logs`,
+			dsl: `{
+                    "aggs": {
+                      "count(distinct(host))": {
+                        "cardinality": {
+                          "field": "host"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//count distinct with precision threshold
+		{
+			sql: `select count(distinct host, 3000) as hosts, cardinality(agent, 100) from logs`,
+			dsl: `{
+                    "aggs": {
+                      "hosts": {
+                        "cardinality": {"field": "host", "precision_threshold": 3000}
+                      },
+                      "cardinality(agent)": {
+                        "cardinality": {"field": "agent", "precision_threshold": 100}
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//count distinct with alias
+		{
+			sql: `select count(DISTINCT host) as hosts from logs`,
+			dsl: `{
+                    "aggs": {
+                      "hosts": {
+                        "cardinality": {
+                          "field": "host"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//percentile metric
+		{
+			sql: `select percentile(latency, 50, 95, 99.9) from logs`,
+			dsl: `{
+                    "aggs": {
+                      "percentile_latency": {
+                        "percentiles": {
+                          "field": "latency",
+                          "percents": [50, 95, 99.9]
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//percentiles metric with percents
+		{
+			sql: `select percentiles(latency, 50, 99) from logs`,
+			dsl: `{
+                    "aggs": {
+                      "percentiles(latency)": {
+                        "percentiles": {
+                          "field": "latency",
+                          "percents": [50, 99]
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//percentiles metric with the default percents
+		{
+			sql: `select percentiles(latency) from logs`,
+			dsl: `{
+                    "aggs": {
+                      "percentiles(latency)": {
+                        "percentiles": {
+                          "field": "latency"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//percentile metric with alias
+		{
+			sql: `select percentile(latency, 95) as p95 from logs`,
+			dsl: `{
+                    "aggs": {
+                      "p95": {
+                        "percentiles": {
+                          "field": "latency",
+                          "percents": [95]
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//percentile rank metric
+		{
+			sql: `select percentile_rank(latency, 500, 1000) from logs`,
+			dsl: `{
+                    "aggs": {
+                      "percentile_rank_latency": {
+                        "percentile_ranks": {
+                          "field": "latency",
+                          "values": [500, 1000]
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//nested metrics
+		{
+			sql: `select NESTED(items, AVG(items.price)) AS avg_price, nested(items, max(items.qty)) from orders`,
+			dsl: `{
+                    "aggs": {
+                      "items": {
+                        "aggs": {
+                          "avg_price": {
+                            "avg": {
+                              "field": "items.price"
+                            }
+                          },
+                          "max_items.qty": {
+                            "max": {
+                              "field": "items.qty"
+                            }
+                          }
+                        },
+                        "nested": {
+                          "path": "items"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//a single nested metric isn't a count query
+		{
+			sql: `select nested(items, avg(items.price)) from orders`,
+			dsl: `{
+                    "aggs": {
+                      "items": {
+                        "aggs": {
+                          "avg_items.price": {
+                            "avg": {
+                              "field": "items.price"
+                            }
+                          }
+                        },
+                        "nested": {
+                          "path": "items"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//nested metric ordering terms buckets
+		{
+			sql: `select shop, nested(items, avg(items.price)) as avg_price from orders group by shop order by avg_price desc`,
+			dsl: `{
+                    "aggs": {
+                      "shop": {
+                        "aggs": {
+                          "items": {
+                            "aggs": {
+                              "avg_price": {
+                                "avg": {
+                                  "field": "items.price"
+                                }
+                              }
+                            },
+                            "nested": {
+                              "path": "items"
+                            }
+                          }
+                        },
+                        "terms": {
+                          "field": "shop",
+                          "order": [
+                            {
+                              "items>avg_price": "desc"
+                            }
+                          ]
+                        }
+                      }
+                    },
+                    "query": {"bool": {"filter": {"exists": {"field": "shop"}}}},
+                    "size": 0
+                  }`,
+		},
+		//stats and extended stats metrics
+		{
+			sql: `select STATS(amount), EXTENDED_STATS(amount) AS amount_ext from orders`,
+			dsl: `{
+                    "aggs": {
+                      "stats(amount)": {
+                        "stats": {
+                          "field": "amount"
+                        }
+                      },
+                      "amount_ext": {
+                        "extended_stats": {
+                          "field": "amount"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//sum field metric
+		{
+			sql: `select sum(market_cap) from symbol`,
+			dsl: `{
+                    "aggs": {
+                      "sum_market_cap": {
+                        "sum": {
+                          "field": "market_cap"
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//metric field and filter
+		{
+			sql: `select sum(market_cap) from symbol where ipo_year=1998`,
+			dsl: `{
+                    "aggs": {
+                      "sum_market_cap": {"sum": {"field": "market_cap"}}
+                    },
+                    "query": {
+                      "bool": {
+                        "filter": {
+                          "script": {"script": "doc['ipo_year'].value == 1998"}
+                        }
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
+		//metric field and group by
+		{
+			sql: `select exchange, count(*) from symbol group by exchange`,
+			dsl: `{
+                    "aggs": {
+                      "exchange": {
+                        "aggs": {},
+                        "terms": {
+                          "field": "exchange"
+                        }
+                      }
+                    },
+                    "query": {"bool": {"filter": {"exists": {"field": "exchange"}}}},
+                    "size": 0
+                  }`,
+		},
+		//metric field and group by
+		{
+			sql: `select exchange, count(*) from symbol group by exchange`,
+			dsl: `{
+                    "aggs": {
+                      "exchange": {
+                        "aggs": {},
+                        "terms": {
+                          "field": "exchange"
+                        }
+                      }
+                    },
+                    "query": {"bool": {"filter": {"exists": {"field": "exchange"}}}},
+                    "size": 0
+                  }`,
+		},
+		//group by groovy function
+		{
+			sql: `SELECT shares_count, COUNT(*) FROM symbol GROUP BY floor(market_cap / last_sale / 1000000) AS shares_count ORDER BY shares_count LIMIT 3`,
 			dsl: `{
 				    "aggs": {
 				      "shares_count": {
@@ -308,7 +1979,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				        "terms": {
 				          "order": [
 				            {
-				              "_term": "asc"
+				              "_key": "asc"
 				            }
 				          ],
 				          "script": {
@@ -322,45 +1993,202 @@ func TestTranslator_EsDsl(t *testing.T) {
 				    "query": {
 				      "bool": {
 				        "filter": {
-				          "and": [
-				            {
-				              "exists": {
-				                "field": "market_cap"
+				          "bool": {"must": [{"exists": {"field": "market_cap"}}, {"exists": {"field": "last_sale"}}]}
+				        }
+				      }
+				    },
+				    "size": 0
+				  }`,
+		},
+		//histogram aggregation
+		{
+			sql: `select ipo_year_range, count(*) from symbol group by histogram(ipo_year, 5) as ipo_year_range`,
+			dsl: `{
+				    "aggs": {
+				      "ipo_year_range": {
+				        "aggs": {},
+				        "histogram": {
+				          "field": "ipo_year",
+				          "interval": 5,
+				          "min_doc_count": 0
+				        }
+				      }
+				    },
+					"query": {"bool": {"filter": {"exists": {"field": "ipo_year"}}}},
+				    "size": 0
+				  }`,
+		},
+		//histogram aggregation with min_doc_count
+		{
+			sql: `select count(*) from logs group by histogram(response_ms, 100, 1) as latency`,
+			dsl: `{
+				    "aggs": {
+				      "latency": {
+				        "aggs": {},
+				        "histogram": {
+				          "field": "response_ms",
+				          "interval": 100,
+				          "min_doc_count": 1
+				        }
+				      }
+				    },
+				    "query": {"bool": {"filter": {"exists": {"field": "response_ms"}}}},
+				    "size": 0
+				  }`,
+		},
+		//moving average of the doc count of date histogram buckets
+		{
+			sql: `select moving_avg(count(*), 5) as smooth from logs group by date_histogram(ts, '1h') as per_hour`,
+			dsl: `{
+				    "aggs": {
+				      "per_hour": {
+				        "aggs": {
+				          "smooth": {
+				            "moving_fn": {
+				              "buckets_path": "_count",
+				              "script": "MovingFunctions.unweightedAvg(values)",
+				              "window": 5
+				            }
+				          }
+				        },
+				        "date_histogram": {"field": "ts", "fixed_interval": "1h"}
+				      }
+				    },
+				    "query": {"bool": {"filter": {"exists": {"field": "ts"}}}},
+				    "size": 0
+				  }`,
+		},
+		//moving average of a metric under the innermost histogram
+		{
+			sql: `select sum(bytes), moving_avg(sum(bytes), 3) from logs group by host, histogram(latency, 100) as latency`,
+			dsl: `{
+				    "aggs": {
+				      "host": {
+				        "aggs": {
+				          "latency": {
+				            "aggs": {
+				              "sum_bytes": {"sum": {"field": "bytes"}},
+				              "moving_avg(sum(bytes))": {
+				                "moving_fn": {
+				                  "buckets_path": "sum_bytes",
+				                  "script": "MovingFunctions.unweightedAvg(values)",
+				                  "window": 3
+				                }
 				              }
 				            },
-				            {
-				              "exists": {
-				                "field": "last_sale"
-				              }
+				            "histogram": {"field": "latency", "interval": 100, "min_doc_count": 0}
+				          }
+				        },
+				        "terms": {"field": "host"}
+				      }
+				    },
+				    "query": {
+				      "bool": {
+				        "filter": {"bool": {"must": [{"exists": {"field": "host"}}, {"exists": {"field": "latency"}}]}}
+				      }
+				    },
+				    "size": 0
+				  }`,
+		},
+		//derivative and cumulative sum of date histogram buckets
+		{
+			sql: `select sum(bytes), derivative(sum(bytes)) as rate, cumulative_sum(count(*)) as total from logs group by date_histogram(ts, '1h') as per_hour`,
+			dsl: `{
+				    "aggs": {
+				      "per_hour": {
+				        "aggs": {
+				          "sum_bytes": {"sum": {"field": "bytes"}},
+				          "rate": {"derivative": {"buckets_path": "sum_bytes"}},
+				          "total": {"cumulative_sum": {"buckets_path": "_count"}}
+				        },
+				        "date_histogram": {"field": "ts", "fixed_interval": "1h"}
+				      }
+				    },
+				    "query": {"bool": {"filter": {"exists": {"field": "ts"}}}},
+				    "size": 0
+				  }`,
+		},
+		//metric aliases resolved in having, order and pipelines
+		{
+			sql: `select sum(bytes) filter(where status >= 500) as errors, derivative(errors) as rate from logs group by date_histogram(ts, '1h') as per_hour having errors > 10`,
+			dsl: `{
+				    "aggs": {
+				      "per_hour": {
+				        "aggs": {
+				          "filtered_errors": {
+				            "aggs": {"errors": {"sum": {"field": "bytes"}}},
+				            "filter": {"range": {"status": {"gte": 500}}}
+				          },
+				          "having": {
+				            "bucket_selector": {
+				              "buckets_path": {"errors": "filtered_errors>errors"},
+				              "script": {"inline": "errors > 10", "lang": "expression"}
 				            }
-				          ]
+				          },
+				          "rate": {"derivative": {"buckets_path": "filtered_errors>errors"}}
+				        },
+				        "date_histogram": {"field": "ts", "fixed_interval": "1h"}
+				      }
+				    },
+				    "query": {"bool": {"filter": {"exists": {"field": "ts"}}}},
+				    "size": 0
+				  }`,
+		},
+		//quoted field names in metric arguments
+		{
+			sql: "select avg(\"response time\"), max(\"geo.lat\") as lat, count(distinct \"user id\") as users, sum(`bytes sent`) from logs",
+			dsl: `{
+				    "aggs": {
+				      "avg_response time": {"avg": {"field": "response time"}},
+				      "lat": {"max": {"field": "geo.lat"}},
+				      "users": {"cardinality": {"field": "user id"}},
+				      "sum_bytes sent": {"sum": {"field": "bytes sent"}}
+				    },
+				    "size": 0
+				  }`,
+		},
+		//geohash grid aggregation
+		{
+			sql: `select cell, count(*), avg(latency) from logs group by geohash(location, 5) as cell`,
+			dsl: `{
+				    "aggs": {
+				      "cell": {
+				        "aggs": {"avg_latency": {"avg": {"field": "latency"}}},
+				        "geohash_grid": {"field": "location", "precision": 5}
+				      }
+				    },
+				    "query": {"bool": {"filter": {"exists": {"field": "location"}}}},
+				    "size": 0
+				  }`,
+		},
+		//terms aggregation with explicit size and order
+		{
+			sql: `select host, count(*), avg(latency) as l from logs group by terms(host, 20, l desc, _key)`,
+			dsl: `{
+				    "aggs": {
+				      "host": {
+				        "aggs": {"l": {"avg": {"field": "latency"}}},
+				        "terms": {
+				          "field": "host",
+				          "order": [{"l": "desc"}, {"_key": "asc"}],
+				          "size": 20
 				        }
 				      }
 				    },
+				    "query": {"bool": {"filter": {"exists": {"field": "host"}}}},
 				    "size": 0
 				  }`,
 		},
-		//histogram aggregation
+		//terms aggregation with a missing bucket, documents without host aren't filtered
 		{
-			sql: `select ipo_year_range, count(*) from symbol group by histogram(ipo_year, 5) as ipo_year_range`,
+			sql: `select count(*) from logs group by terms(host, 20, missing('unknown'))`,
 			dsl: `{
 				    "aggs": {
-				      "ipo_year_range": {
+				      "host": {
 				        "aggs": {},
-				        "histogram": {
-				          "field": "ipo_year",
-				          "interval": "5",
-				          "min_doc_count": 0
-				        }
+				        "terms": {"field": "host", "missing": "unknown", "size": 20}
 				      }
 				    },
-					"query": {
-                      "bool": {
-                        "filter": {
-                          "and": [{"exists": {"field": "ipo_year"}}]
-                        }
-                      }
-                    },
 				    "size": 0
 				  }`,
 		},
@@ -371,7 +2199,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				    "aggs": {
 				      "year": {
 				        "aggs": {
-				          "max(adj_close)": {
+				          "max_adj_close": {
 				            "max": {
 				              "field": "adj_close"
 				            }
@@ -379,7 +2207,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				        },
 				        "date_histogram": {
 				          "field": "@timestamp",
-				          "interval": "1y"
+				          "calendar_interval": "1y"
 				        }
 				      }
 				    },
@@ -395,6 +2223,23 @@ func TestTranslator_EsDsl(t *testing.T) {
 				    "size": 0
 				  }`,
 		},
+		//date histogram aggregation with fixed interval
+		{
+			sql: `select count(*) from logs group by date_histogram(timestamp, '1h')`,
+			dsl: `{
+				    "aggs": {
+				      "date_histogram(timestamp, '1h')": {
+				        "aggs": {},
+				        "date_histogram": {
+				          "field": "timestamp",
+				          "fixed_interval": "1h"
+				        }
+				      }
+				    },
+				    "query": {"bool": {"filter": {"exists": {"field": "timestamp"}}}},
+				    "size": 0
+				  }`,
+		},
 		//range aggregation
 		{
 			sql: `SELECT ipo_year_range, COUNT(*) FROM symbol GROUP BY range(ipo_year, 1980, 1990, 2000) AS ipo_year_range`,
@@ -424,9 +2269,46 @@ func TestTranslator_EsDsl(t *testing.T) {
 				        }
 				      }
 				    },
-					"query": {
-				      "bool": {"filter": {"and": [{"exists": {"field": "ipo_year"}}]}}
+					"query": {"bool": {"filter": {"exists": {"field": "ipo_year"}}}},
+				    "size": 0
+				  }`,
+		},
+		//range with a single breakpoint
+		{
+			sql: `SELECT COUNT(*) FROM people GROUP BY RANGE(age, 18) AS adult`,
+			dsl: `{
+				    "aggs": {
+				      "adult": {
+				        "aggs": {},
+				        "range": {
+				          "field": "age",
+				          "keyed": true,
+				          "ranges": [{"to": "18"}, {"from": "18"}]
+				        }
+				      }
+				    },
+					"query": {"bool": {"filter": {"exists": {"field": "age"}}}},
+				    "size": 0
+				  }`,
+		},
+		//date range buckets between consecutive bounds
+		{
+			sql: `SELECT COUNT(*) FROM logs GROUP BY DATE_RANGE(ts, 'now-1d', 'now-12h', 'now') AS period`,
+			dsl: `{
+				    "aggs": {
+				      "period": {
+				        "aggs": {},
+				        "date_range": {
+				          "field": "ts",
+				          "keyed": true,
+				          "ranges": [
+				            {"from": "now-1d", "to": "now-12h"},
+				            {"from": "now-12h", "to": "now"}
+				          ]
+				        }
+				      }
 				    },
+					"query": {"bool": {"filter": {"exists": {"field": "ts"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -437,18 +2319,12 @@ func TestTranslator_EsDsl(t *testing.T) {
                     "aggs": {
                       "exchange": {
                         "aggs": {
-                          "max(market_cap)": {"max": {"field": "market_cap"}}
+                          "max_market_cap": {"max": {"field": "market_cap"}}
                         },
-                        "terms": {"field": "exchange", "size": 0}
-                      }
-                    },
-                    "query": {
-                      "bool": {
-                        "filter": {
-                          "and": [{"exists": {"field": "exchange"}}]
-                        }
+                        "terms": {"field": "exchange"}
                       }
                     },
+                    "query": {"bool": {"filter": {"exists": {"field": "exchange"}}}},
                     "size": 0
                   }`,
 		},
@@ -460,26 +2336,46 @@ func TestTranslator_EsDsl(t *testing.T) {
                       "exchange": {
                         "aggs": {
                           "sector": {
-                            "aggs": {"max(market_cap)": {"max": {"field": "market_cap"}}},
-                            "terms": {"field": "sector","size": 0}
+                            "aggs": {"max_market_cap": {"max": {"field": "market_cap"}}},
+                            "terms": {"field": "sector"}
                           }
                         },
-                        "terms": {"field": "exchange","size": 0}
+                        "terms": {"field": "exchange"}
                       }
                     },
                     "query": {
                       "bool": {
                         "filter": {
-                          "and": [
-                            {"exists": {"field": "exchange"}},
-                            {"exists": {"field": "sector"}}
-                          ]
+                          "bool": {"must": [{"exists": {"field": "exchange"}}, {"exists": {"field": "sector"}}]}
                         }
                       }
                     },
                     "size": 0
                   }`,
 		},
+		//terms and date histogram nested aggregation
+		{
+			sql: `select host, max(bytes), count(*) from logs group by host, date_histogram(ts, '5m') as per_5m`,
+			dsl: `{
+                    "aggs": {
+                      "host": {
+                        "aggs": {
+                          "per_5m": {
+                            "aggs": {"max_bytes": {"max": {"field": "bytes"}}},
+                            "date_histogram": {"field": "ts", "fixed_interval": "5m"}
+                          }
+                        },
+                        "terms": {"field": "host"}
+                      }
+                    },
+                    "query": {
+                      "bool": {
+                        "filter": {"bool": {"must": [{"exists": {"field": "host"}}, {"exists": {"field": "ts"}}]}}
+                      }
+                    },
+                    "size": 0
+                  }`,
+		},
 		//metric(field op field) and group by multi field
 		{
 			sql: `select exchange, sum(ipo_year+last_sale) from symbol group by exchange`,
@@ -489,12 +2385,10 @@ func TestTranslator_EsDsl(t *testing.T) {
 				        "aggs": {
 				          "sum(ipo_year + last_sale)": {"sum": {"script": "doc['ipo_year'].value + doc['last_sale'].value"}}
 				        },
-				        "terms": {"field": "exchange","size": 0}
+				        "terms": {"field": "exchange"}
 				      }
 				    },
-				    "query": {
-				      "bool": {"filter": {"and": [{"exists": {"field": "exchange"}}]}}
-				    },
+				    "query": {"bool": {"filter": {"exists": {"field": "exchange"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -506,18 +2400,46 @@ func TestTranslator_EsDsl(t *testing.T) {
 				      "ipo_year_rem": {
 				        "aggs": {},
 				        "terms": {
-				          "script": "doc['ipo_year'].value % 5",
-				          "size": 0
+				          "script": "doc['ipo_year'].value % 5"
 				        }
 				      }
 				    },
-					"query": {
-				      "bool": {"filter": {"and": [{"exists": {"field": "ipo_year"}}]}}
+					"query": {"bool": {"filter": {"exists": {"field": "ipo_year"}}}},
+				    "size": 0
+				  }`,
+		},
+		//limit terms size
+		{
+			sql: `SELECT host, COUNT(*) FROM logs GROUP BY host LIMIT 50`,
+			dsl: `{
+				    "aggs": {
+				      "host": {
+				        "aggs": {},
+				        "terms": {
+				          "field": "host",
+				          "size": 50
+				        }
+				      }
+				    },
+					"query": {"bool": {"filter": {"exists": {"field": "host"}}}},
+				    "size": 0
+				  }`,
+		},
+		//limit 0 returns only aggregations
+		{
+			sql: `SELECT AVG(latency) FROM logs LIMIT 0`,
+			dsl: `{
+				    "aggs": {
+				      "avg_latency": {
+				        "avg": {
+				          "field": "latency"
+				        }
+				      }
 				    },
 				    "size": 0
 				  }`,
 		},
-		//order by _term
+		//order by _key
 		{
 			sql: `SELECT ipo_year, COUNT(*) FROM symbol GROUP BY ipo_year ORDER BY ipo_year LIMIT 3`,
 			dsl: `{
@@ -528,16 +2450,14 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          "field": "ipo_year",
 				          "order": [
 				            {
-				              "_term": "asc"
+				              "_key": "asc"
 				            }
 				          ],
 				          "size": 3
 				        }
 				      }
 				    },
-					"query": {
-				      "bool": {"filter": {"and": [{"exists": {"field": "ipo_year"}}]}}
-				    },
+					"query": {"bool": {"filter": {"exists": {"field": "ipo_year"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -558,10 +2478,96 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          "size": 2
 				        }
 				      }
+				    },
+					"query": {"bool": {"filter": {"exists": {"field": "ipo_year"}}}},
+				    "size": 0
+				  }`,
+		},
+		//order by metric and multiple dimensions
+		{
+			sql: `SELECT host, status, COUNT(*) FROM logs GROUP BY host, status ORDER BY COUNT(*) DESC, status`,
+			dsl: `{
+				    "aggs": {
+				      "host": {
+				        "aggs": {
+				          "status": {
+				            "aggs": {},
+				            "terms": {
+				              "field": "status",
+				              "order": [
+				                {
+				                  "_count": "desc"
+				                },
+				                {
+				                  "_key": "asc"
+				                }
+				              ]
+				            }
+				          }
+				        },
+				        "terms": {
+				          "field": "host",
+				          "order": [
+				            {
+				              "_count": "desc"
+				            }
+				          ]
+				        }
+				      }
 				    },
 					"query": {
-				      "bool": {"filter": {"and": [{"exists": {"field": "ipo_year"}}]}}
+					  "bool": {
+					    "filter": {"bool": {"must": [{"exists": {"field": "host"}}, {"exists": {"field": "status"}}]}}
+					  }
+					},
+				    "size": 0
+				  }`,
+		},
+		//order by count(*) call
+		{
+			sql: `SELECT COUNT(*) FROM logs GROUP BY host ORDER BY COUNT(*) DESC`,
+			dsl: `{
+				    "aggs": {
+				      "host": {
+				        "aggs": {},
+				        "terms": {
+				          "field": "host",
+				          "order": [
+				            {
+				              "_count": "desc"
+				            }
+				          ]
+				        }
+				      }
+				    },
+					"query": {"bool": {"filter": {"exists": {"field": "host"}}}},
+				    "size": 0
+				  }`,
+		},
+		//order by metric call
+		{
+			sql: `SELECT host, MAX(latency) FROM logs GROUP BY host ORDER BY MAX(latency) DESC`,
+			dsl: `{
+				    "aggs": {
+				      "host": {
+				        "aggs": {
+				          "max_latency": {
+				            "max": {
+				              "field": "latency"
+				            }
+				          }
+				        },
+				        "terms": {
+				          "field": "host",
+				          "order": [
+				            {
+				              "max_latency": "desc"
+				            }
+				          ]
+				        }
+				      }
 				    },
+					"query": {"bool": {"filter": {"exists": {"field": "host"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -589,9 +2595,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				        }
 				      }
 				    },
-					"query": {
-				      "bool": {"filter": {"and": [{"exists": {"field": "ipo_year"}}]}}
-				    },
+					"query": {"bool": {"filter": {"exists": {"field": "ipo_year"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -615,14 +2619,11 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          }
 				        },
 				        "terms": {
-				          "field": "ipo_year",
-				          "size": 0
+				          "field": "ipo_year"
 				        }
 				      }
 				    },
-					"query": {
-					  "bool": {"filter": {"and": [{"exists": {"field": "ipo_year"}}]}}
-					},
+					"query": {"bool": {"filter": {"exists": {"field": "ipo_year"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -652,14 +2653,78 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          }
 				        },
 				        "terms": {
-				          "field": "ipo_year",
-				          "size": 0
+				          "field": "ipo_year"
 				        }
 				      }
 				    },
-					"query": {
-					  "bool": {"filter": {"and": [{"exists": {"field": "ipo_year"}}]}}
-					},
+					"query": {"bool": {"filter": {"exists": {"field": "ipo_year"}}}},
+				    "size": 0
+				  }`,
+		},
+		//having on metric calls
+		{
+			sql: `SELECT host, COUNT(*) FROM logs GROUP BY host HAVING COUNT(*) > 100`,
+			dsl: `{
+				    "aggs": {
+				      "host": {
+				        "aggs": {
+				          "having": {
+				            "bucket_selector": {
+				              "buckets_path": {
+				                "path0": "_count"
+				              },
+				              "script": {
+				                "inline": "path0 > 100",
+				                "lang": "expression"
+				              }
+				            }
+				          }
+				        },
+				        "terms": {
+				          "field": "host"
+				        }
+				      }
+				    },
+					"query": {"bool": {"filter": {"exists": {"field": "host"}}}},
+				    "size": 0
+				  }`,
+		},
+		//having on selected and unselected metric calls
+		{
+			sql: `SELECT host, AVG(latency) AS avg_latency FROM logs GROUP BY host HAVING AVG(latency) > 100 AND MAX(latency) < 1000`,
+			dsl: `{
+				    "aggs": {
+				      "host": {
+				        "aggs": {
+				          "avg_latency": {
+				            "avg": {
+				              "field": "latency"
+				            }
+				          },
+				          "having": {
+				            "bucket_selector": {
+				              "buckets_path": {
+				                "path0": "avg_latency",
+				                "path1": "max_latency"
+				              },
+				              "script": {
+				                "inline": "path0 > 100 && path1 < 1000",
+				                "lang": "expression"
+				              }
+				            }
+				          },
+				          "max_latency": {
+				            "max": {
+				              "field": "latency"
+				            }
+				          }
+				        },
+				        "terms": {
+				          "field": "host"
+				        }
+				      }
+				    },
+					"query": {"bool": {"filter": {"exists": {"field": "host"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -670,12 +2735,12 @@ func TestTranslator_EsDsl(t *testing.T) {
 				    "aggs": {
 				      "exchange": {
 				        "aggs": {
-				          "sum(ipo_year)": {
+				          "sum_ipo_year": {
 				            "sum": {
 				              "field": "ipo_year"
 				            }
 				          },
-				          "sum(last_sale)": {
+				          "sum_last_sale": {
 				            "sum": {
 				              "field": "last_sale"
 				            }
@@ -683,8 +2748,8 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          "yyyy": {
 				            "bucket_script": {
 				              "buckets_path": {
-				                "path0": "sum(ipo_year)",
-				                "path1": "sum(last_sale)"
+				                "path0": "sum_ipo_year",
+				                "path1": "sum_last_sale"
 				              },
 				              "script": {
 				                "inline": "path0 / path1",
@@ -694,14 +2759,11 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          }
 				        },
 				        "terms": {
-				          "field": "exchange",
-				          "size": 0
+				          "field": "exchange"
 				        }
 				      }
 				    },
-					"query": {
-					  "bool": {"filter": {"and": [{"exists": {"field": "exchange"}}]}}
-					},
+					"query": {"bool": {"filter": {"exists": {"field": "exchange"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -712,7 +2774,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				    "aggs": {
 				      "exchange": {
 				        "aggs": {
-				          "avg(last_sale)": {
+				          "avg_last_sale": {
 				            "avg": {
 				              "field": "last_sale"
 				            }
@@ -722,7 +2784,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				              "script": "doc['ipo_year'].value * 2"
 				            }
 				          },
-				          "sum(ipo_year)": {
+				          "sum_ipo_year": {
 				            "sum": {
 				              "field": "ipo_year"
 				            }
@@ -731,7 +2793,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				            "bucket_script": {
 				              "buckets_path": {
 				                "path0": "sum(ipo_year * 2)",
-				                "path1": "avg(last_sale)"
+				                "path1": "avg_last_sale"
 				              },
 				              "script": {
 				                "inline": "path0 / path1",
@@ -741,14 +2803,11 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          }
 				        },
 				        "terms": {
-				          "field": "exchange",
-				          "size": 0
+				          "field": "exchange"
 				        }
 				      }
 				    },
-					"query": {
-					  "bool": {"filter": {"and": [{"exists": {"field": "exchange"}}]}}
-					},
+					"query": {"bool": {"filter": {"exists": {"field": "exchange"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -764,12 +2823,12 @@ func TestTranslator_EsDsl(t *testing.T) {
 				              "script": "doc['ipo_year'].value + doc['last_sale'].value"
 				            }
 				          },
-				          "sum(ipo_year)": {
+				          "sum_ipo_year": {
 				            "sum": {
 				              "field": "ipo_year"
 				            }
 				          },
-				          "sum(last_sale)": {
+				          "sum_last_sale": {
 				            "sum": {
 				              "field": "last_sale"
 				            }
@@ -778,7 +2837,7 @@ func TestTranslator_EsDsl(t *testing.T) {
 				            "bucket_script": {
 				              "buckets_path": {
 				                "path0": "sum(ipo_year + last_sale)",
-				                "path1": "sum(last_sale)"
+				                "path1": "sum_last_sale"
 				              },
 				              "script": {
 				                "inline": "path0 / path1",
@@ -788,14 +2847,11 @@ func TestTranslator_EsDsl(t *testing.T) {
 				          }
 				        },
 				        "terms": {
-				          "field": "exchange",
-				          "size": 0
+				          "field": "exchange"
 				        }
 				      }
 				    },
-					"query": {
-					  "bool": {"filter": {"and": [{"exists": {"field": "exchange"}}]}}
-					},
+					"query": {"bool": {"filter": {"exists": {"field": "exchange"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -817,21 +2873,18 @@ func TestTranslator_EsDsl(t *testing.T) {
 				                "path0": "sum(ipo_year + last_sale * 2)"
 				              },
 				              "script": {
-				                "inline": "0 - 5 * path0",
+				                "inline": "-5 * path0",
 				                "lang": "expression"
 				              }
 				            }
 				          }
 				        },
 				        "terms": {
-				          "field": "exchange",
-				          "size": 0
+				          "field": "exchange"
 				        }
 				      }
 				    },
-					"query": {
-					  "bool": {"filter": {"and": [{"exists": {"field": "exchange"}}]}}
-					},
+					"query": {"bool": {"filter": {"exists": {"field": "exchange"}}}},
 				    "size": 0
 				  }`,
 		},
@@ -849,3 +2902,125 @@ func TestTranslator_EsDsl(t *testing.T) {
 		}
 	}
 }
+
+// Ensure the translator rejects statements it cannot express.
+func TestTranslator_EsDsl_Errors(t *testing.T) {
+	var tests = []struct {
+		sql string
+		err string
+	}{
+		{sql: `select * from logs where status in ()`, err: `invalid filter, empty list for IN at 1:36`},
+		{sql: `select * from logs where status not in ()`, err: `invalid filter, empty list for NOT IN at 1:40`},
+		{sql: `select price + 'x' from orders`, err: `invalid field, unsupport expression 'x' in script field at 1:15`},
+	}
+	for i, tt := range tests {
+		_, err := sp.EsDsl(tt.sql)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+		}
+	}
+}
+
+// Ensure the dsl map can be manipulated before encoding.
+// Ensure the translation options are applied.
+func TestTranslate(t *testing.T) {
+	var tests = []struct {
+		sql  string
+		opts *sp.Options
+		dsl  string
+	}{
+		{sql: `select * from logs`, dsl: `{"from":0,"size":0,"sort":[]}`},
+		{sql: `select * from logs`, opts: &sp.Options{DefaultSize: 20}, dsl: `{"from":0,"size":20,"sort":[]}`},
+		{sql: `select * from logs limit 5`, opts: &sp.Options{DefaultSize: 20}, dsl: `{"from":0,"size":5,"sort":[]}`},
+		{sql: `select * from logs limit 0`, opts: &sp.Options{DefaultSize: 20}, dsl: `{"from":0,"size":0,"sort":[]}`},
+		{sql: `select max(bytes) from logs limit 0`, opts: &sp.Options{DefaultSize: 20}, dsl: `{"aggs":{"max_bytes":{"max":{"field":"bytes"}}},"size":0}`},
+		{sql: `select host, max(bytes) from logs group by host`, opts: &sp.Options{DefaultSize: 20}, dsl: `{"aggs":{"host":{"aggs":{"max_bytes":{"max":{"field":"bytes"}}},"terms":{"field":"host"}}},"query":{"bool":{"filter":{"exists":{"field":"host"}}}},"size":0}`},
+		{sql: `select max(bytes) from logs`, opts: &sp.Options{DefaultSize: 20}, dsl: `{"aggs":{"max_bytes":{"max":{"field":"bytes"}}},"size":0}`},
+		{sql: `select max(bytes) from logs limit 5`, opts: &sp.Options{DefaultSize: 20}, dsl: `{"aggs":{"max_bytes":{"max":{"field":"bytes"}}},"from":0,"size":5,"sort":[]}`},
+		{sql: `select count(*) from logs group by host`, opts: &sp.Options{DefaultSize: 20, TrackTotalHits: true}, dsl: `{"aggs":{"host":{"aggs":{},"terms":{"field":"host"}}},"query":{"bool":{"filter":{"exists":{"field":"host"}}}},"size":0,"track_total_hits":true}`},
+		{sql: `select /*+ track_total_hits */ * from logs limit 1`, dsl: `{"from":0,"size":1,"sort":[],"track_total_hits":true}`},
+		{sql: `select * from logs where match(msg, 'x') limit 1`, opts: &sp.Options{ForceFilter: true}, dsl: `{"from":0,"query":{"bool":{"filter":{"match":{"msg":{"query":"x"}}}}},"size":1,"sort":[]}`},
+		{sql: `select host, count(*) from logs where match(msg, 'x') group by host`, dsl: `{"aggs":{"host":{"aggs":{},"terms":{"field":"host"}}},"query":{"bool":{"filter":{"exists":{"field":"host"}},"must":[{"match":{"msg":{"query":"x"}}}]}},"size":0}`},
+		{sql: `select host, count(*) from logs where a > 1 and b > 2 group by host`, dsl: `{"aggs":{"host":{"aggs":{},"terms":{"field":"host"}}},"query":{"bool":{"filter":{"bool":{"must":[{"range":{"a":{"gt":1}}},{"range":{"b":{"gt":2}}},{"exists":{"field":"host"}}]}}}},"size":0}`},
+		{sql: `select host, count(*) from logs where a > 1 group by host`, dsl: `{"aggs":{"host":{"aggs":{},"terms":{"field":"host"}}},"query":{"bool":{"filter":{"bool":{"must":[{"range":{"a":{"gt":1}}},{"exists":{"field":"host"}}]}}}},"size":0}`},
+		{sql: `select * from logs limit 1`, opts: &sp.Options{Pretty: true}, dsl: "{\n  \"from\": 0,\n  \"size\": 1,\n  \"sort\": []\n}"},
+	}
+	for i, tt := range tests {
+		dsl, err := sp.Translate(tt.sql, tt.opts)
+		if err != nil {
+			t.Errorf("%d. %s: error\n\n %s", i, tt.sql, err)
+		} else if dsl != tt.dsl {
+			t.Errorf("%d. %s: dsl mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.dsl, dsl)
+		}
+	}
+}
+
+// Ensure UNION queries are translated into an _msearch body.
+func TestMultiSearch(t *testing.T) {
+	var tests = []struct {
+		sql  string
+		opts *sp.Options
+		body string
+		err  string
+	}{
+		{sql: `select * from logs limit 1`, body: "{\"index\":\"logs\"}\n{\"from\":0,\"size\":1,\"sort\":[]}\n"},
+		{sql: `select host from logs where status = 500 union select host from archive-*, old limit 5`, body: "{\"index\":\"logs\"}\n{\"_source\":[\"host\"],\"from\":0,\"query\":{\"bool\":{\"filter\":{\"script\":{\"script\":\"doc['status'].value == 500\"}}}},\"size\":0,\"sort\":[]}\n{\"index\":\"archive-*,old\"}\n{\"_source\":[\"host\"],\"from\":0,\"size\":5,\"sort\":[]}\n"},
+		{sql: `select * from a union select x, y from b`, opts: &sp.Options{DefaultSize: 10, Pretty: true}, body: "{\"index\":\"a\"}\n{\"from\":0,\"size\":10,\"sort\":[]}\n{\"index\":\"b\"}\n{\"_source\":[\"x\",\"y\"],\"from\":0,\"size\":10,\"sort\":[]}\n"},
+		{sql: `select host, count(*) from a group by host union select host, count(*) from b group by host`, body: "{\"index\":\"a\"}\n{\"aggs\":{\"host\":{\"aggs\":{},\"terms\":{\"field\":\"host\"}}},\"query\":{\"bool\":{\"filter\":{\"exists\":{\"field\":\"host\"}}}},\"size\":0}\n{\"index\":\"b\"}\n{\"aggs\":{\"host\":{\"aggs\":{},\"terms\":{\"field\":\"host\"}}},\"query\":{\"bool\":{\"filter\":{\"exists\":{\"field\":\"host\"}}}},\"size\":0}\n"},
+		{sql: `select nested(items, max(items.qty)) from a union select nested(items, max(items.qty)) from b`, body: "{\"index\":\"a\"}\n{\"aggs\":{\"items\":{\"aggs\":{\"max_items.qty\":{\"max\":{\"field\":\"items.qty\"}}},\"nested\":{\"path\":\"items\"}}},\"size\":0}\n{\"index\":\"b\"}\n{\"aggs\":{\"items\":{\"aggs\":{\"max_items.qty\":{\"max\":{\"field\":\"items.qty\"}}},\"nested\":{\"path\":\"items\"}}},\"size\":0}\n"},
+		{sql: `select a from x union select a, b from y`, err: `invalid UNION, each SELECT must have the same number of columns`},
+		{sql: `select a from x union select max(a) from y`, err: `invalid UNION, can't combine documents with aggregation buckets`},
+		{sql: `select count(*) from x union select count(*) from y`, err: `invalid UNION, COUNT(*) without GROUP BY is sent to the _count endpoint`},
+		{sql: `select a from x union`, err: `found EOF, expected SELECT at 1:23`},
+	}
+	for i, tt := range tests {
+		body, err := sp.MultiSearch(tt.sql, tt.opts)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.err, err)
+		} else if body != tt.body {
+			t.Errorf("%d. %s: body mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.sql, tt.body, body)
+		}
+	}
+}
+
+func TestTranslator_EsDslMap(t *testing.T) {
+	sql := `select * from logs where status = 500 limit 10`
+	dsl, err := sp.EsDslMap(sql)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s, err := sp.EsDsl(sql)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	js, _ := simplejson.NewJson([]byte(s))
+	encoded, _ := json.Marshal(dsl)
+	_js, _ := simplejson.NewJson(encoded)
+	if !reflect.DeepEqual(js.MustMap(), _js.MustMap()) {
+		t.Errorf("dsl mismatch:\n\nexp=%s\n\ngot=%s\n\n", s, encoded)
+	}
+
+	if _, ok := dsl["query"].(map[string]interface{}); !ok {
+		t.Errorf("expected query map, got %T", dsl["query"])
+	}
+}
+
+// Ensure translating a query doesn't change how other expressions are printed.
+func TestTranslate_KeepsSQLOperators(t *testing.T) {
+	sql := `select host, count(*) as n from logs where a = 1 and b = 2 or c = 3 group by host having n > 1 and n < 10`
+	if _, err := sp.Translate(sql, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expr, err := sp.ParseExpr(`a = 1 AND b = 2 OR c = 3`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp := `a = 1 AND b = 2 OR c = 3`; expr.String() != exp {
+		t.Errorf("expr mismatch:\n  exp=%s\n  got=%s", exp, expr.String())
+	}
+	if _, err := sp.ParseExpr(expr.String()); err != nil {
+		t.Errorf("unable to parse expr string: %s", err)
+	}
+}